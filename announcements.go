@@ -0,0 +1,569 @@
+// Scheduled announcements module
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type AnnouncementsConfig struct {
+	// Time zone cron schedules are evaluated in, e.g. "Europe/Berlin".
+	// Defaults to the time zone of the system.
+	Timezone string
+}
+
+func (c *AnnouncementsConfig) Validate() error {
+	_, err := LoadTimezone(c.Timezone)
+	if err != nil {
+		return Errorf("Announcements.Timezone: %w", err)
+	}
+	return nil
+}
+
+type Announcement struct {
+	ID        int
+	GuildID   string
+	ChannelID string
+	Schedule  string
+	Title     string
+	Content   string
+	Color     int
+	PingRole  string
+	Paused    bool
+	NextRunAt time.Time
+	LastRunAt *time.Time
+	CreatedBy string
+}
+
+var announcementsLog = ModuleLogger("announcements")
+
+type AnnouncementsModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *AnnouncementsConfig
+
+	// Config.Timezone
+	location *time.Location
+}
+
+const announcementsSchema = `
+CREATE TABLE IF NOT EXISTS announcements (
+	id          SERIAL PRIMARY KEY,
+	guild_id    TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	schedule    TEXT NOT NULL,
+	title       TEXT NOT NULL DEFAULT '',
+	content     TEXT NOT NULL,
+	color       INTEGER NOT NULL DEFAULT 0,
+	ping_role   TEXT NOT NULL DEFAULT '',
+	paused      BOOLEAN NOT NULL DEFAULT FALSE,
+	next_run_at TIMESTAMPTZ NOT NULL,
+	last_run_at TIMESTAMPTZ,
+	created_by  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS announcements_due ON announcements (next_run_at) WHERE NOT paused;
+`
+
+const announcementColumns = `id, guild_id, channel_id, schedule, title, content, color, ping_role, paused, next_run_at, last_run_at, created_by`
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "announcements", NeedsDB: true}, func(config *Config) *AnnouncementsConfig {
+		return config.Announcements
+	}, NewAnnouncementsModule)
+}
+
+func NewAnnouncementsModule(config *AnnouncementsConfig) *AnnouncementsModule {
+	// Validate already loaded it once
+	location, _ := LoadTimezone(config.Timezone)
+	return &AnnouncementsModule{
+		Config:   config,
+		location: location,
+	}
+}
+
+func (m *AnnouncementsModule) Register(bot *Bot) error {
+	announcementsLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(announcementsSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	idOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "id",
+			Description: "Announcement number, see /announce list",
+			Required:    true,
+			MinValue:    Ptr(1.0),
+		}
+	}
+	contentOptions := func(required bool) []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "channel",
+				Description:  "Where to post",
+				Required:     required,
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "when",
+				Description: `Cron expression like "0 18 * * fri" or interval like "every 2 days"`,
+				Required:    required,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "message",
+				Description: `Text of the announcement, \n starts a new line`,
+				Required:    required,
+				MaxLength:   4000,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "title",
+				Description: "Title of the announcement",
+				MaxLength:   256,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "ping",
+				Description: "Role to mention with the announcement",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "color",
+				Description: "Embed color like #5865f2",
+			},
+		}
+	}
+
+	editOptions := append([]*discordgo.ApplicationCommandOption{idOption()}, contentOptions(false)...)
+	editOptions = append(editOptions, &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionBoolean,
+		Name:        "remove_ping",
+		Description: "Stop mentioning a role",
+	})
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "announce",
+		Description:              "Manage scheduled announcements",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionManageServer)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "schedule",
+				Description: "Schedule a recurring announcement",
+				Options:     contentOptions(true),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List the announcements of this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "edit",
+				Description: "Change an announcement",
+				Options:     editOptions,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "pause",
+				Description: "Stop posting an announcement for now",
+				Options:     []*discordgo.ApplicationCommandOption{idOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "resume",
+				Description: "Post a paused announcement again",
+				Options:     []*discordgo.ApplicationCommandOption{idOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "delete",
+				Description: "Delete an announcement",
+				Options:     []*discordgo.ApplicationCommandOption{idOption()},
+			},
+		},
+	}, m.AnnounceCommand)
+
+	bot.Scheduler.Every("announcements", 30*time.Second, m.PostDueAnnouncements)
+	return nil
+}
+
+func (m *AnnouncementsModule) parseSchedule(text string) (Schedule, error) {
+	schedule, err := ParseSchedule(text, m.location)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, Errorf("%q never happens", text)
+	}
+	return schedule, nil
+}
+
+func scanAnnouncement(row interface{ Scan(...any) error }) (*Announcement, error) {
+	announcement := &Announcement{}
+	err := row.Scan(&announcement.ID, &announcement.GuildID, &announcement.ChannelID, &announcement.Schedule,
+		&announcement.Title, &announcement.Content, &announcement.Color, &announcement.PingRole, &announcement.Paused,
+		&announcement.NextRunAt, &announcement.LastRunAt, &announcement.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// Returns nil if the announcement doesn't exist in the guild
+func (m *AnnouncementsModule) GetAnnouncement(guildID string, id int) (*Announcement, error) {
+	row := m.DB.QueryRow(`SELECT `+announcementColumns+` FROM announcements WHERE guild_id = $1 AND id = $2`, guildID, id)
+	announcement, err := scanAnnouncement(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return announcement, nil
+}
+
+func (m *AnnouncementsModule) AnnounceCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	if subcommand == "schedule" {
+		return m.scheduleAnnouncement(interaction, options)
+	}
+	if subcommand == "list" {
+		return m.listAnnouncements(interaction)
+	}
+
+	announcement, err := m.GetAnnouncement(interaction.GuildID, int(OptionInt(options, "id")))
+	if err != nil {
+		return err
+	}
+	data := NewTemplateData()
+	data.ID = int(OptionInt(options, "id"))
+	if announcement == nil {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.not_found", data, locales...))
+	}
+
+	switch subcommand {
+	case "edit":
+		return m.editAnnouncement(interaction, announcement, options)
+
+	case "pause":
+		_, err = m.DB.Exec(`UPDATE announcements SET paused = TRUE WHERE id = $1`, announcement.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.paused", data, locales...))
+
+	case "resume":
+		schedule, err := m.parseSchedule(announcement.Schedule)
+		if err != nil {
+			return WrapError(err)
+		}
+		// Runs missed while paused are skipped
+		nextRun := schedule.Next(time.Now())
+		_, err = m.DB.Exec(`UPDATE announcements SET paused = FALSE, next_run_at = $1 WHERE id = $2`, nextRun, announcement.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+		data.Time = fmt.Sprintf("<t:%d:f>", nextRun.Unix())
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.resumed", data, locales...))
+
+	case "delete":
+		_, err = m.DB.Exec(`DELETE FROM announcements WHERE id = $1`, announcement.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+		announcementsLog.Info("Announcement deleted", "announcement", announcement.ID, "guild", interaction.GuildID, "staff", interaction.Member.User.ID)
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.deleted", data, locales...))
+	}
+	return nil
+}
+
+// Fills in the announcement from the given options, leaving out options that
+// weren't given. Returns a message for the user if an option is invalid or
+// the member couldn't post the announcement themselves.
+func (m *AnnouncementsModule) applyOptions(interaction *discordgo.Interaction, announcement *Announcement, options map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	locales := InteractionLocales(interaction)
+	if channelID := OptionID(options, "channel"); channelID != "" {
+		announcement.ChannelID = channelID
+	}
+	if when := OptionString(options, "when"); when != "" {
+		schedule, err := m.parseSchedule(when)
+		if err != nil {
+			data := NewTemplateData()
+			data.Error = err.Error()
+			return m.Localizer.Text("announcements.invalid_schedule", data, locales...), nil
+		}
+		announcement.Schedule = schedule.String()
+		announcement.NextRunAt = schedule.Next(time.Now())
+	}
+	if message := OptionString(options, "message"); message != "" {
+		announcement.Content = strings.ReplaceAll(message, `\n`, "\n")
+	}
+	if title := OptionString(options, "title"); title != "" {
+		announcement.Title = title
+	}
+	if roleID := OptionID(options, "ping"); roleID != "" {
+		announcement.PingRole = roleID
+	}
+	if OptionBool(options, "remove_ping") {
+		announcement.PingRole = ""
+	}
+	if colorText := OptionString(options, "color"); colorText != "" {
+		parsed, err := parseHexColor(colorText)
+		if err != nil {
+			data := NewTemplateData()
+			data.Error = err.Error()
+			return m.Localizer.Text("announcements.invalid_color", data, locales...), nil
+		}
+		announcement.Color = int(parsed.R)<<16 | int(parsed.G)<<8 | int(parsed.B)
+	}
+
+	// The bot posts for the staff member, so it shouldn't reach channels or
+	// ping roles they couldn't themselves
+	permissions, err := m.Discord.UserChannelPermissions(interaction.Member.User.ID, announcement.ChannelID)
+	if err != nil {
+		return "", WrapError(err)
+	}
+	if permissions&(discordgo.PermissionViewChannel|discordgo.PermissionSendMessages) != discordgo.PermissionViewChannel|discordgo.PermissionSendMessages {
+		data := NewTemplateData()
+		data.Channel = "<#" + announcement.ChannelID + ">"
+		return m.Localizer.Text("announcements.no_channel_access", data, locales...), nil
+	}
+	if announcement.PingRole != "" && permissions&discordgo.PermissionMentionEveryone == 0 && !m.mentionable(interaction, announcement.PingRole) {
+		data := NewTemplateData()
+		data.Role = "<@&" + announcement.PingRole + ">"
+		if announcement.PingRole == announcement.GuildID {
+			data.Role = "@everyone"
+		}
+		return m.Localizer.Text("announcements.ping_not_allowed", data, locales...), nil
+	}
+	return "", nil
+}
+
+// Whether anyone may mention the role. @everyone never counts as mentionable.
+func (m *AnnouncementsModule) mentionable(interaction *discordgo.Interaction, roleID string) bool {
+	if roleID == interaction.GuildID {
+		return false
+	}
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	if guild == nil {
+		return false
+	}
+	for _, role := range guild.Roles {
+		if role.ID == roleID {
+			return role.Mentionable
+		}
+	}
+	return false
+}
+
+func (m *AnnouncementsModule) scheduleAnnouncement(interaction *discordgo.Interaction, options map[string]*discordgo.ApplicationCommandInteractionDataOption) error {
+	locales := InteractionLocales(interaction)
+
+	announcement := &Announcement{
+		GuildID:   interaction.GuildID,
+		Color:     ColorBlue,
+		CreatedBy: interaction.Member.User.ID,
+	}
+	problem, err := m.applyOptions(interaction, announcement, options)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		return m.Discord.FollowupEphemeral(interaction, problem)
+	}
+
+	err = m.DB.QueryRow(`
+		INSERT INTO announcements (guild_id, channel_id, schedule, title, content, color, ping_role, next_run_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`,
+		announcement.GuildID, announcement.ChannelID, announcement.Schedule, announcement.Title, announcement.Content,
+		announcement.Color, announcement.PingRole, announcement.NextRunAt, announcement.CreatedBy,
+	).Scan(&announcement.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	announcementsLog.Info("Announcement scheduled", "announcement", announcement.ID, "guild", interaction.GuildID, "staff", interaction.Member.User.ID)
+
+	data := NewTemplateData()
+	data.ID = announcement.ID
+	data.Time = fmt.Sprintf("<t:%d:f>", announcement.NextRunAt.Unix())
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.scheduled", data, locales...),
+		m.AnnouncementEmbed(announcement))
+}
+
+func (m *AnnouncementsModule) editAnnouncement(interaction *discordgo.Interaction, announcement *Announcement, options map[string]*discordgo.ApplicationCommandInteractionDataOption) error {
+	locales := InteractionLocales(interaction)
+
+	problem, err := m.applyOptions(interaction, announcement, options)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		return m.Discord.FollowupEphemeral(interaction, problem)
+	}
+
+	_, err = m.DB.Exec(`
+		UPDATE announcements SET channel_id = $1, schedule = $2, title = $3, content = $4, color = $5, ping_role = $6, next_run_at = $7
+		WHERE id = $8`,
+		announcement.ChannelID, announcement.Schedule, announcement.Title, announcement.Content, announcement.Color,
+		announcement.PingRole, announcement.NextRunAt, announcement.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	data := NewTemplateData()
+	data.ID = announcement.ID
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.updated", data, locales...),
+		m.AnnouncementEmbed(announcement))
+}
+
+func (m *AnnouncementsModule) listAnnouncements(interaction *discordgo.Interaction) error {
+	locales := InteractionLocales(interaction)
+
+	rows, err := m.DB.Query(`SELECT `+announcementColumns+` FROM announcements WHERE guild_id = $1 ORDER BY id`, interaction.GuildID)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		announcement, err := scanAnnouncement(rows)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		name := announcement.Title
+		if name == "" {
+			name = truncateText(announcement.Content, 40)
+		}
+		next := fmt.Sprintf("<t:%d:R>", announcement.NextRunAt.Unix())
+		if announcement.Paused {
+			next = m.Localizer.Text("announcements.paused_marker", nil, locales...)
+		}
+		lines = append(lines, fmt.Sprintf("**%d** %s in <#%s>, `%s`, %s", announcement.ID, name, announcement.ChannelID, announcement.Schedule, next))
+	}
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	if len(lines) == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("announcements.none", nil, locales...))
+	}
+	return m.Discord.FollowupEphemeral(interaction, truncateText(strings.Join(lines, "\n"), 2000))
+}
+
+func (m *AnnouncementsModule) AnnouncementEmbed(announcement *Announcement) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       announcement.Title,
+		Description: announcement.Content,
+		Color:       announcement.Color,
+	}
+}
+
+func (m *AnnouncementsModule) PostDueAnnouncements(now time.Time) error {
+	rows, err := m.DB.Query(`SELECT `+announcementColumns+` FROM announcements WHERE NOT paused AND next_run_at <= $1`, now)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	due := []*Announcement{}
+	for rows.Next() {
+		announcement, err := scanAnnouncement(rows)
+		if err != nil {
+			rows.Close()
+			return WrapError(err)
+		}
+		due = append(due, announcement)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	for _, announcement := range due {
+		// Failures, like a deleted channel, are only logged so that it doesn't
+		// retry every few seconds
+		m.post(announcement)
+
+		// Runs missed while the bot was down are posted once, not once per run
+		paused := false
+		schedule, err := m.parseSchedule(announcement.Schedule)
+		nextRun := now
+		if err != nil {
+			announcementsLog.Warn("Pausing announcement", "announcement", announcement.ID, "guild", announcement.GuildID, "error", err)
+			paused = true
+		} else {
+			nextRun = nextRunAfter(schedule, announcement.NextRunAt, now)
+		}
+
+		_, err = m.DB.Exec(`UPDATE announcements SET next_run_at = $1, last_run_at = $2, paused = $3 WHERE id = $4`,
+			nextRun, now, paused, announcement.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+	}
+	return nil
+}
+
+// The first run of the schedule after now, counted from the run that was due
+// so that interval schedules don't drift by the polling delay
+func nextRunAfter(schedule Schedule, due time.Time, now time.Time) time.Time {
+	next := schedule.Next(due)
+	for i := 0; i < 1000 && !next.After(now); i++ {
+		next = schedule.Next(next)
+	}
+	if !next.After(now) {
+		// Down for a very long time, give up on keeping the phase
+		next = schedule.Next(now)
+	}
+	return next
+}
+
+// Queues the announcement, announcements due at the same time in the same
+// channel are combined
+func (m *AnnouncementsModule) post(announcement *Announcement) {
+	message := &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{m.AnnouncementEmbed(announcement)},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if announcement.PingRole != "" {
+		message.Content = "<@&" + announcement.PingRole + ">"
+		if announcement.PingRole == announcement.GuildID {
+			message.Content = "@everyone"
+			message.AllowedMentions.Parse = []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}
+		} else {
+			message.AllowedMentions.Roles = []string{announcement.PingRole}
+		}
+	}
+
+	m.Discord.Announce(announcement.ChannelID, message)
+	announcementsLog.Info("Posted announcement", "announcement", announcement.ID, "guild", announcement.GuildID, "channel", announcement.ChannelID)
+}
@@ -0,0 +1,546 @@
+// Anti-raid module
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type AntiRaidConfig struct {
+	// Lockdown starts when any of the enabled triggers fires. Triggers with a
+	// zero threshold are disabled.
+
+	// JoinThreshold joins within JoinWindow
+	JoinThreshold int
+	JoinWindow    Duration
+	// NewAccountThreshold joins within JoinWindow of accounts younger than
+	// NewAccountAge
+	NewAccountThreshold int
+	NewAccountAge       Duration
+	// ClusterSize joins within JoinWindow of accounts created within
+	// ClusterSpan of each other
+	ClusterSize int
+	ClusterSpan Duration
+
+	// Staff alert with a summary and a button to lift the lockdown
+	AlertChannel string
+	// Sent along with the alert embed, e.g. to ping a staff role
+	AlertMessage string
+
+	// What lockdown does
+	PauseVerification bool
+	// Raised to this level while in lockdown, 0 leaves it untouched
+	VerificationLevel discordgo.VerificationLevel
+	KickNewJoins      bool
+
+	// Lift the lockdown automatically after this time, 0 waits for staff
+	AutoLiftAfter Duration
+}
+
+func (c *AntiRaidConfig) Validate() error {
+	if c.JoinWindow <= 0 {
+		return Errorf("AntiRaid.JoinWindow must be set")
+	}
+	if c.JoinThreshold == 0 && c.NewAccountThreshold == 0 && c.ClusterSize == 0 {
+		return Errorf("AntiRaid needs at least one of JoinThreshold, NewAccountThreshold or ClusterSize")
+	}
+	if c.NewAccountThreshold > 0 && c.NewAccountAge <= 0 {
+		return Errorf("AntiRaid.NewAccountAge must be set when NewAccountThreshold is")
+	}
+	if c.ClusterSize > 0 && c.ClusterSpan <= 0 {
+		return Errorf("AntiRaid.ClusterSpan must be set when ClusterSize is")
+	}
+	if c.VerificationLevel < discordgo.VerificationLevelNone || c.VerificationLevel > discordgo.VerificationLevelVeryHigh {
+		return Errorf("AntiRaid.VerificationLevel must be between 0 and 4")
+	}
+
+	return nil
+}
+
+type raidJoin struct {
+	UserID    string
+	Username  string
+	JoinedAt  time.Time
+	CreatedAt time.Time
+}
+
+type raidState struct {
+	joins []raidJoin
+
+	// The rest of the lockdown is stored in antiraid_lockdowns
+	lockdown bool
+	autoLift *time.Timer
+}
+
+// Adds the join and drops the ones that are more than window older
+func (s *raidState) addJoin(join raidJoin, window time.Duration) {
+	s.joins = append(s.joins, join)
+	cutoff := join.JoinedAt.Add(-window)
+	s.joins = slices.DeleteFunc(s.joins, func(join raidJoin) bool {
+		return join.JoinedAt.Before(cutoff)
+	})
+}
+
+const antiraidSchema = `
+CREATE TABLE IF NOT EXISTS antiraid_lockdowns (
+	guild_id         TEXT PRIMARY KEY,
+	-- Restored when the lockdown is lifted, NULL if it wasn't changed
+	previous_level   INTEGER,
+	alert_channel_id TEXT NOT NULL DEFAULT '',
+	alert_message_id TEXT NOT NULL DEFAULT '',
+	kicked           INTEGER NOT NULL DEFAULT 0,
+	lift_at          TIMESTAMPTZ,
+	started_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+var antiraidLog = ModuleLogger("antiraid")
+
+type AntiRaidModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *AntiRaidConfig
+
+	// Keyed by guild ID
+	states     map[string]*raidState
+	statesLock sync.Mutex
+}
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "antiraid",
+		NeedsDB: true,
+		Intents: discordgo.IntentsGuildMembers,
+	}, func(config *Config) *AntiRaidConfig {
+		return config.AntiRaid
+	}, NewAntiRaidModule)
+}
+
+func NewAntiRaidModule(config *AntiRaidConfig) *AntiRaidModule {
+	return &AntiRaidModule{
+		Config: config,
+		states: map[string]*raidState{},
+	}
+}
+
+func (m *AntiRaidModule) Register(bot *Bot) error {
+	antiraidLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(antiraidSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+	err = m.resumeLockdowns()
+	if err != nil {
+		return err
+	}
+
+	HandleEvent(bot.Discord, m.OnGuildMemberAdd)
+
+	bot.Router.AddComponent("AntiRaidLiftButton", m.LiftButtonClick)
+	return nil
+}
+
+// Picks up lockdowns that were active when the bot stopped
+func (m *AntiRaidModule) resumeLockdowns() error {
+	rows, err := m.DB.Query(`SELECT guild_id, lift_at FROM antiraid_lockdowns`)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer rows.Close()
+
+	m.statesLock.Lock()
+	defer m.statesLock.Unlock()
+	for rows.Next() {
+		var guildID string
+		var liftAt sql.NullTime
+		err := rows.Scan(&guildID, &liftAt)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		state := m.state(guildID)
+		state.lockdown = true
+		if liftAt.Valid {
+			state.autoLift = m.scheduleLift(guildID, time.Until(liftAt.Time))
+		}
+		antiraidLog.Info("Lockdown still active", "guild", guildID)
+	}
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Stops pending automatic lifts, they would fire after the gateway is closed.
+// Lockdowns are stored and resume after a restart.
+func (m *AntiRaidModule) Stop() error {
+	m.statesLock.Lock()
+	defer m.statesLock.Unlock()
+
+	for _, state := range m.states {
+		if state.autoLift != nil {
+			state.autoLift.Stop()
+		}
+	}
+	return nil
+}
+
+func (m *AntiRaidModule) scheduleLift(guildID string, after time.Duration) *time.Timer {
+	// Timers run outside the event handlers, which would recover a panic
+	return time.AfterFunc(max(after, 0), func() {
+		runHandler("antiraid", []any{"guild", guildID}, func() error {
+			_, err := m.LiftLockdown(guildID, nil)
+			return err
+		})
+	})
+}
+
+func (m *AntiRaidModule) state(guildID string) *raidState {
+	state, ok := m.states[guildID]
+	if !ok {
+		state = &raidState{}
+		m.states[guildID] = state
+	}
+	return state
+}
+
+// Whether verification forms are currently not accepted in the guild
+func (m *AntiRaidModule) VerificationPaused(guildID string) bool {
+	m.statesLock.Lock()
+	defer m.statesLock.Unlock()
+
+	return m.Config.PauseVerification && m.state(guildID).lockdown
+}
+
+func (m *AntiRaidModule) OnGuildMemberAdd(member *discordgo.GuildMemberAdd) error {
+	if member.User.Bot {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(member.GuildID))
+	createdAt, _ := discordgo.SnowflakeTimestamp(member.User.ID)
+	join := raidJoin{
+		UserID:    member.User.ID,
+		Username:  member.User.Username,
+		JoinedAt:  time.Now(),
+		CreatedAt: createdAt,
+	}
+
+	m.statesLock.Lock()
+	state := m.state(member.GuildID)
+	state.addJoin(join, time.Duration(m.Config.JoinWindow))
+
+	if state.lockdown {
+		m.statesLock.Unlock()
+		if !m.Config.KickNewJoins {
+			return nil
+		}
+
+		err := m.Discord.GuildMemberDeleteWithReason(member.GuildID, member.User.ID, m.Localizer.Text("antiraid.kick_reason", nil, locales...))
+		if err != nil {
+			return WrapError(err)
+		}
+		antiraidLog.Info("Kicked user joining during lockdown", "guild", member.GuildID, "user", member.User.ID)
+
+		_, err = m.DB.Exec(`UPDATE antiraid_lockdowns SET kicked = kicked + 1 WHERE guild_id = $1`, member.GuildID)
+		if err != nil {
+			return WrapError(err)
+		}
+		return nil
+	}
+
+	trigger := m.detectRaid(state.joins, locales)
+	if trigger == "" {
+		m.statesLock.Unlock()
+		return nil
+	}
+
+	// Keeps further joins from triggering again until the lockdown is stored
+	state.lockdown = true
+	recentJoins := slices.Clone(state.joins)
+	m.statesLock.Unlock()
+
+	antiraidLog.Warn("Raid detected", "guild", member.GuildID, "trigger", trigger)
+	return m.StartLockdown(member.GuildID, trigger, recentJoins)
+}
+
+// Returns a description of the trigger that fired, empty if none did
+func (m *AntiRaidModule) detectRaid(joins []raidJoin, locales []discordgo.Locale) string {
+	data := NewTemplateData()
+	data.Duration = FormatDuration(time.Duration(m.Config.JoinWindow))
+
+	if m.Config.JoinThreshold > 0 && len(joins) >= m.Config.JoinThreshold {
+		data.Count = len(joins)
+		return m.Localizer.Text("antiraid.trigger.joins", data, locales...)
+	}
+
+	if m.Config.NewAccountThreshold > 0 {
+		newAccounts := 0
+		for _, join := range joins {
+			if join.JoinedAt.Sub(join.CreatedAt) < time.Duration(m.Config.NewAccountAge) {
+				newAccounts++
+			}
+		}
+		if newAccounts >= m.Config.NewAccountThreshold {
+			data.Count = newAccounts
+			data.Age = FormatDuration(time.Duration(m.Config.NewAccountAge))
+			return m.Localizer.Text("antiraid.trigger.new_accounts", data, locales...)
+		}
+	}
+
+	if m.Config.ClusterSize > 0 && len(joins) >= m.Config.ClusterSize {
+		// Sliding window over the account creation times
+		createdAt := []time.Time{}
+		for _, join := range joins {
+			createdAt = append(createdAt, join.CreatedAt)
+		}
+		slices.SortFunc(createdAt, func(a, b time.Time) int {
+			return a.Compare(b)
+		})
+
+		start := 0
+		for end := range createdAt {
+			for createdAt[end].Sub(createdAt[start]) > time.Duration(m.Config.ClusterSpan) {
+				start++
+			}
+			if end-start+1 >= m.Config.ClusterSize {
+				data.Count = end - start + 1
+				data.Age = FormatDuration(time.Duration(m.Config.ClusterSpan))
+				return m.Localizer.Text("antiraid.trigger.cluster", data, locales...)
+			}
+		}
+	}
+
+	return ""
+}
+
+func (m *AntiRaidModule) StartLockdown(guildID string, trigger string, recentJoins []raidJoin) error {
+	guild := m.Discord.CachedGuild(guildID)
+	locales := GuildLocales(guild)
+	measures := []string{}
+
+	var liftAt *time.Time
+	if m.Config.AutoLiftAfter > 0 {
+		liftAt = Ptr(time.Now().Add(time.Duration(m.Config.AutoLiftAfter)))
+	}
+	_, err := m.DB.Exec(`INSERT INTO antiraid_lockdowns (guild_id, lift_at) VALUES ($1, $2) ON CONFLICT (guild_id) DO NOTHING`,
+		guildID, liftAt)
+	m.statesLock.Lock()
+	// Without the row nothing could lift the lockdown
+	m.state(guildID).lockdown = err == nil
+	m.statesLock.Unlock()
+	if err != nil {
+		return WrapError(err)
+	}
+
+	if m.Config.PauseVerification {
+		measures = append(measures, m.Localizer.Text("antiraid.measure.pause", nil, locales...))
+	}
+
+	if m.Config.VerificationLevel > 0 && guild != nil && guild.VerificationLevel < m.Config.VerificationLevel {
+		_, err := m.Discord.GuildEdit(guildID, &discordgo.GuildParams{
+			VerificationLevel: &m.Config.VerificationLevel,
+		}, discordgo.WithAuditLogReason(trigger))
+		if err != nil {
+			antiraidLog.Warn("Could not raise verification level", "guild", guildID, "error", err)
+		} else {
+			_, err = m.DB.Exec(`UPDATE antiraid_lockdowns SET previous_level = $1 WHERE guild_id = $2`, int(guild.VerificationLevel), guildID)
+			if err != nil {
+				return WrapError(err)
+			}
+			measures = append(measures, m.Localizer.Text("antiraid.measure.level", nil, locales...))
+		}
+	}
+
+	if m.Config.KickNewJoins {
+		measures = append(measures, m.Localizer.Text("antiraid.measure.kick", nil, locales...))
+	}
+
+	if m.Config.AutoLiftAfter > 0 {
+		data := NewTemplateData()
+		data.Duration = FormatDuration(time.Duration(m.Config.AutoLiftAfter))
+		measures = append(measures, m.Localizer.Text("antiraid.measure.auto_lift", data, locales...))
+
+		timer := m.scheduleLift(guildID, time.Duration(m.Config.AutoLiftAfter))
+		m.statesLock.Lock()
+		m.state(guildID).autoLift = timer
+		m.statesLock.Unlock()
+	}
+
+	if m.Config.AlertChannel == "" {
+		return nil
+	}
+
+	// Newest joins last, an embed field fits roughly 20 of them
+	joinLines := []string{}
+	for _, join := range recentJoins[max(0, len(recentJoins)-20):] {
+		joinLines = append(joinLines, fmt.Sprintf("<@%s> %s (%s)", join.UserID, join.Username, FormatDuration(join.JoinedAt.Sub(join.CreatedAt))))
+	}
+	if len(measures) == 0 {
+		measures = append(measures, "-")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Title: m.Localizer.Text("antiraid.alert_title", nil, locales...),
+		Color: ColorRed,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  m.Localizer.Text("antiraid.field.trigger", nil, locales...),
+				Value: trigger,
+			},
+			{
+				Name:  m.Localizer.Text("antiraid.field.measures", nil, locales...),
+				Value: strings.Join(measures, "\n"),
+			},
+			{
+				Name:  m.Localizer.Text("antiraid.field.recent_joins", nil, locales...),
+				Value: strings.Join(joinLines, "\n"),
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	message, err := m.Discord.ChannelMessageSendComplex(m.Config.AlertChannel, &discordgo.MessageSend{
+		Content: m.Config.AlertMessage,
+		Embeds:  []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					&discordgo.Button{
+						Label:    m.Localizer.Text("antiraid.lift_button", nil, locales...),
+						Style:    discordgo.SuccessButton,
+						CustomID: "AntiRaidLiftButton|" + guildID,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+
+	_, err = m.DB.Exec(`UPDATE antiraid_lockdowns SET alert_channel_id = $1, alert_message_id = $2 WHERE guild_id = $3`,
+		message.ChannelID, message.ID, guildID)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Ends the lockdown and restores the verification level. Staff is nil when
+// lifted automatically. Returns false if there was no lockdown.
+func (m *AntiRaidModule) LiftLockdown(guildID string, staff *discordgo.Member) (bool, error) {
+	var previousLevel sql.NullInt64
+	var alertChannelID, alertMessageID string
+	var kicked int
+	err := m.DB.QueryRow(`DELETE FROM antiraid_lockdowns WHERE guild_id = $1 RETURNING previous_level, alert_channel_id, alert_message_id, kicked`,
+		guildID).Scan(&previousLevel, &alertChannelID, &alertMessageID, &kicked)
+	if err != nil && err != sql.ErrNoRows {
+		return false, WrapError(err)
+	}
+
+	// Also without a stored lockdown, in case storing it failed
+	m.statesLock.Lock()
+	state := m.state(guildID)
+	wasActive := state.lockdown
+	if state.autoLift != nil {
+		state.autoLift.Stop()
+	}
+	// Joins during the raid shouldn't count towards the next one
+	*state = raidState{}
+	m.statesLock.Unlock()
+
+	if err == sql.ErrNoRows {
+		return wasActive, nil
+	}
+
+	antiraidLog.Info("Lockdown lifted", "guild", guildID, "kicked", kicked)
+
+	if previousLevel.Valid {
+		level := discordgo.VerificationLevel(previousLevel.Int64)
+		_, err := m.Discord.GuildEdit(guildID, &discordgo.GuildParams{
+			VerificationLevel: &level,
+		})
+		if err != nil {
+			antiraidLog.Warn("Could not restore verification level", "guild", guildID, "error", err)
+		}
+	}
+
+	if alertMessageID == "" {
+		return true, nil
+	}
+	alertMessage, err := m.Discord.ChannelMessage(alertChannelID, alertMessageID)
+	if err != nil {
+		antiraidLog.Warn("Could not find lockdown alert", "guild", guildID, "error", err)
+		return true, nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(guildID))
+	data := NewTemplateData()
+	data.Count = kicked
+	footer := m.Localizer.Text("antiraid.auto_lifted_footer", data, locales...)
+	if staff != nil {
+		data.Staff = NewTemplateUser(nil, staff)
+		footer = m.Localizer.Text("antiraid.lifted_footer", data, locales...)
+	}
+
+	// Staff can suppress the embeds of the alert
+	embeds := alertMessage.Embeds
+	if len(embeds) == 0 {
+		return true, nil
+	}
+	embeds[0].Color = ColorGreen
+	embeds[0].Footer = &discordgo.MessageEmbedFooter{
+		Text: footer,
+	}
+	_, err = m.Discord.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         alertMessage.ID,
+		Channel:    alertMessage.ChannelID,
+		Embeds:     &embeds,
+		Components: &[]discordgo.MessageComponent{},
+	})
+	if err != nil {
+		return true, WrapError(err)
+	}
+
+	return true, nil
+}
+
+func (m *AntiRaidModule) LiftButtonClick(interaction *discordgo.Interaction) error {
+	guildID := interaction.GuildID
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	if interaction.Member.Permissions&discordgo.PermissionModerateMembers == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("antiraid.no_permission", nil, locales...))
+	}
+
+	lifted, err := m.LiftLockdown(guildID, interaction.Member)
+	if err != nil {
+		return err
+	}
+	if !lifted {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("antiraid.not_active", nil, locales...))
+	}
+	antiraidLog.Info("Lockdown lifted by staff", "guild", guildID, "staff", interaction.Member.User.ID)
+
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("antiraid.lifted", nil, locales...))
+}
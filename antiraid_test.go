@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRaidStateAddJoin(t *testing.T) {
+	start := time.Now()
+	state := &raidState{}
+	// One join every 20 seconds
+	for i := 0; i < 10; i++ {
+		state.addJoin(raidJoin{UserID: strconv.Itoa(i), JoinedAt: start.Add(time.Duration(i) * 20 * time.Second)}, time.Minute)
+	}
+
+	ids := []string{}
+	for _, join := range state.joins {
+		ids = append(ids, join.UserID)
+	}
+	// The join exactly a minute before the last one still counts
+	if strings.Join(ids, ",") != "6,7,8,9" {
+		t.Fatalf("kept joins %v, want 6 to 9", ids)
+	}
+}
+
+func TestDetectRaid(t *testing.T) {
+	localizer, err := NewLocalizer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := now.AddDate(-2, 0, 0)
+
+	// Joins a second apart, of accounts created at the given times
+	joins := func(createdAt ...time.Time) []raidJoin {
+		result := []raidJoin{}
+		for i, created := range createdAt {
+			result = append(result, raidJoin{
+				UserID:    strconv.Itoa(i),
+				JoinedAt:  now.Add(time.Duration(i-len(createdAt)) * time.Second),
+				CreatedAt: created,
+			})
+		}
+		return result
+	}
+	repeat := func(count int, created time.Time) []time.Time {
+		result := []time.Time{}
+		for i := 0; i < count; i++ {
+			// A year apart, so they don't form a cluster
+			result = append(result, created.AddDate(-i, 0, 0))
+		}
+		return result
+	}
+	// Accounts created step apart, starting at created
+	spaced := func(count int, created time.Time, step time.Duration) []time.Time {
+		result := []time.Time{}
+		for i := 0; i < count; i++ {
+			result = append(result, created.Add(time.Duration(i)*step))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		config AntiRaidConfig
+		joins  []raidJoin
+		// Start of the trigger text, empty if none fires
+		want string
+	}{
+		{"join threshold", AntiRaidConfig{JoinThreshold: 5}, joins(repeat(5, old)...), "5 joins within"},
+		{"below the join threshold", AntiRaidConfig{JoinThreshold: 5}, joins(repeat(4, old)...), ""},
+		{"new accounts", AntiRaidConfig{NewAccountThreshold: 3, NewAccountAge: Duration(24 * time.Hour)},
+			joins(append(repeat(3, old), spaced(3, now.Add(-time.Hour), -5*time.Hour)...)...), "3 accounts younger than"},
+		{"accounts just too old", AntiRaidConfig{NewAccountThreshold: 3, NewAccountAge: Duration(24 * time.Hour)},
+			joins(append(spaced(2, now.Add(-time.Hour), -time.Hour), now.Add(-25*time.Hour))...), ""},
+		{"cluster", AntiRaidConfig{ClusterSize: 4, ClusterSpan: Duration(10 * time.Minute)},
+			joins(append(repeat(3, old), spaced(4, old.AddDate(0, 1, 0), 3*time.Minute)...)...), "4 accounts created within"},
+		{"cluster in any join order", AntiRaidConfig{ClusterSize: 3, ClusterSpan: Duration(10 * time.Minute)},
+			joins(old, old.AddDate(-1, 0, 0), old.Add(5*time.Minute), old.AddDate(-3, 0, 0), old.Add(-4*time.Minute)), "3 accounts created within"},
+		{"cluster too spread", AntiRaidConfig{ClusterSize: 4, ClusterSpan: Duration(10 * time.Minute)},
+			joins(spaced(6, old, 4*time.Minute)...), ""},
+		{"cluster exactly the span", AntiRaidConfig{ClusterSize: 3, ClusterSpan: Duration(10 * time.Minute)},
+			joins(spaced(3, old, 5*time.Minute)...), "3 accounts created within"},
+		{"sliding window finds the later cluster", AntiRaidConfig{ClusterSize: 3, ClusterSpan: Duration(time.Hour)},
+			joins(append(spaced(2, old, 50*time.Minute), spaced(3, old.Add(3*time.Hour), 20*time.Minute)...)...), "3 accounts created within"},
+		{"disabled triggers", AntiRaidConfig{}, joins(repeat(20, now)...), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.JoinWindow = Duration(time.Minute)
+			module := NewAntiRaidModule(&test.config)
+			module.Localizer = localizer
+
+			trigger := module.detectRaid(test.joins, nil)
+			if test.want == "" && trigger != "" {
+				t.Fatalf("%q fired", trigger)
+			}
+			if !strings.HasPrefix(trigger, test.want) {
+				t.Fatalf("trigger %q, want %q...", trigger, test.want)
+			}
+		})
+	}
+}
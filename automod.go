@@ -0,0 +1,585 @@
+// Automod module
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type AutomodConfig struct {
+	// Where rule hits with the "log" action get reported
+	LogChannel string
+	// Members with any of these roles and messages in these channels are
+	// never checked
+	ExemptRoles    []string
+	ExemptChannels []string
+	Rules          []*AutomodRule
+}
+
+type AutomodRuleType string
+
+const (
+	// Count identical messages within Window
+	AutomodDuplicates AutomodRuleType = "duplicates"
+	// Count messages within Window
+	AutomodRate AutomodRuleType = "rate"
+	// Discord invite links
+	AutomodInvites AutomodRuleType = "invites"
+	// Count user and role mentions in a single message
+	AutomodMentions AutomodRuleType = "mentions"
+	// Words and regex Patterns
+	AutomodWords AutomodRuleType = "words"
+	// Ratio of combining characters, at least MinLength of them
+	AutomodZalgo AutomodRuleType = "zalgo"
+	// Ratio of uppercase letters, at least MinLength letters
+	AutomodCaps AutomodRuleType = "caps"
+)
+
+type AutomodActionType string
+
+const (
+	AutomodDelete  AutomodActionType = "delete"
+	AutomodWarn    AutomodActionType = "warn"
+	AutomodTimeout AutomodActionType = "timeout"
+	AutomodLog     AutomodActionType = "log"
+)
+
+type AutomodRule struct {
+	// Shown in logs and used as the reason of warnings and timeouts
+	Name string
+	Type AutomodRuleType
+
+	Count     int
+	Window    Duration
+	Words     []string
+	Patterns  []string
+	Ratio     float64
+	MinLength int
+
+	Actions         []AutomodActionType
+	TimeoutDuration Duration
+
+	// In addition to the global exemptions
+	ExemptRoles    []string
+	ExemptChannels []string
+
+	wordsRegexp    *regexp.Regexp
+	patternRegexps []*regexp.Regexp
+}
+
+var inviteRegexp = regexp.MustCompile(`(?i)(discord\.(gg|io|me|li)|discord(app)?\.com/invite)/[a-z0-9-]+`)
+
+func (c *AutomodConfig) Validate() error {
+	for i, rule := range c.Rules {
+		prefix := fmt.Sprintf("Automod.Rules.%d", i)
+
+		switch rule.Type {
+		case AutomodDuplicates, AutomodRate:
+			if rule.Count < 2 || rule.Window <= 0 {
+				return Errorf("%s: Count must be at least 2 and Window set", prefix)
+			}
+		case AutomodMentions:
+			if rule.Count < 1 {
+				return Errorf("%s: Count must be at least 1", prefix)
+			}
+		case AutomodInvites:
+		case AutomodWords:
+			for _, pattern := range rule.Patterns {
+				_, err := regexp.Compile(pattern)
+				if err != nil {
+					return Errorf("%s: pattern %q: %w", prefix, pattern, err)
+				}
+			}
+		case AutomodZalgo, AutomodCaps:
+			if rule.Ratio < 0 || rule.Ratio > 1 {
+				return Errorf("%s: Ratio must be between 0 and 1", prefix)
+			}
+			if rule.MinLength < 0 {
+				return Errorf("%s: MinLength can't be negative", prefix)
+			}
+		default:
+			return Errorf("%s: unknown rule type %q", prefix, rule.Type)
+		}
+
+		for _, action := range rule.Actions {
+			switch action {
+			case AutomodDelete, AutomodWarn, AutomodLog:
+			case AutomodTimeout:
+				if rule.TimeoutDuration <= 0 || time.Duration(rule.TimeoutDuration) > MaxTimeout {
+					return Errorf("%s: TimeoutDuration must be between 1s and 28d", prefix)
+				}
+			default:
+				return Errorf("%s: unknown action %q", prefix, action)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Copy of the rule with defaults filled in and its patterns compiled. The
+// rule must have passed Validate.
+func (rule *AutomodRule) prepare() *AutomodRule {
+	prepared := *rule
+	if prepared.Name == "" {
+		prepared.Name = string(prepared.Type)
+	}
+
+	switch prepared.Type {
+	case AutomodWords:
+		if len(prepared.Words) > 0 {
+			words := []string{}
+			for _, word := range prepared.Words {
+				words = append(words, regexp.QuoteMeta(word))
+			}
+			// Not \b, which only knows ASCII letters and needs a letter at
+			// the edge of the word, so "ber" would match in "über" and "c++"
+			// would never match
+			prepared.wordsRegexp = regexp.MustCompile(`(?i)(^|[^\pL\pN_])(` + strings.Join(words, "|") + `)($|[^\pL\pN_])`)
+		}
+		prepared.patternRegexps = nil
+		for _, pattern := range prepared.Patterns {
+			prepared.patternRegexps = append(prepared.patternRegexps, regexp.MustCompile(pattern))
+		}
+	case AutomodZalgo:
+		if prepared.Ratio == 0 {
+			prepared.Ratio = 0.5
+		}
+		if prepared.MinLength == 0 {
+			prepared.MinLength = 10
+		}
+	case AutomodCaps:
+		if prepared.Ratio == 0 {
+			prepared.Ratio = 0.7
+		}
+		if prepared.MinLength == 0 {
+			prepared.MinLength = 10
+		}
+	}
+
+	return &prepared
+}
+
+// Recent messages of a single member, oldest first
+type automodHistory struct {
+	messages []automodMessage
+}
+
+type automodMessage struct {
+	ID        string
+	ChannelID string
+	Content   string
+	Time      time.Time
+}
+
+var automodLog = ModuleLogger("automod")
+
+type AutomodModule struct {
+	Discord    DiscordAPI
+	Localizer  *Localizer
+	Moderation *ModerationModule
+	Config     *AutomodConfig
+
+	// Config.Rules with defaults and compiled patterns, in the same order
+	rules []*AutomodRule
+
+	// Keyed by guild ID and user ID
+	histories     map[string]*automodHistory
+	historiesLock sync.Mutex
+	// Longest window of any rule, older messages are dropped
+	historyWindow time.Duration
+}
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "automod",
+		Intents: discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent,
+	}, func(config *Config) *AutomodConfig {
+		return config.Automod
+	}, NewAutomodModule)
+}
+
+func NewAutomodModule(config *AutomodConfig) *AutomodModule {
+	m := &AutomodModule{
+		Config:    config,
+		histories: map[string]*automodHistory{},
+	}
+
+	for _, rule := range config.Rules {
+		m.rules = append(m.rules, rule.prepare())
+		if time.Duration(rule.Window) > m.historyWindow {
+			m.historyWindow = time.Duration(rule.Window)
+		}
+	}
+
+	return m
+}
+
+func (m *AutomodModule) Register(bot *Bot) error {
+	automodLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.Localizer = bot.Localizer
+	m.Moderation = FindModule[*ModerationModule](bot)
+
+	for _, rule := range m.rules {
+		if slices.Contains(rule.Actions, AutomodWarn) && m.Moderation == nil {
+			return Errorf("automod rule %v warns, which requires the moderation module", rule.Name)
+		}
+	}
+
+	HandleEvent(bot.Discord, m.OnMessageCreate)
+
+	bot.Scheduler.Every("automod.prune", time.Minute, m.pruneHistories)
+	return nil
+}
+
+func (m *AutomodModule) OnMessageCreate(message *discordgo.MessageCreate) error {
+	if message.GuildID == "" || message.Author == nil || message.Author.Bot {
+		return nil
+	}
+	if m.isExempt(message.Message, m.Config.ExemptRoles, m.Config.ExemptChannels) {
+		return nil
+	}
+
+	recent := m.recordMessage(message.Message)
+
+	for _, rule := range m.rules {
+		if m.isExempt(message.Message, rule.ExemptRoles, rule.ExemptChannels) {
+			continue
+		}
+
+		matched := rule.Match(message.Message, recent)
+		if len(matched) == 0 {
+			continue
+		}
+
+		// Matched messages shouldn't trigger the rule again
+		m.forgetMessages(message.GuildID, message.Author.ID, matched)
+
+		err := m.ApplyRule(rule, message.Message, matched)
+		if err != nil {
+			return err
+		}
+
+		// One rule hit per message is enough
+		break
+	}
+
+	return nil
+}
+
+func (m *AutomodModule) isExempt(message *discordgo.Message, roles []string, channels []string) bool {
+	if slices.Contains(channels, message.ChannelID) {
+		return true
+	}
+	if message.Member != nil {
+		for _, role := range message.Member.Roles {
+			if slices.Contains(roles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Adds the message to the author's history and returns a copy of the history
+// within the longest rule window
+func (m *AutomodModule) recordMessage(message *discordgo.Message) []automodMessage {
+	m.historiesLock.Lock()
+	defer m.historiesLock.Unlock()
+
+	key := message.GuildID + "|" + message.Author.ID
+	history, ok := m.histories[key]
+	if !ok {
+		history = &automodHistory{}
+		m.histories[key] = history
+	}
+
+	now := time.Now()
+	history.prune(now.Add(-m.historyWindow))
+	history.messages = append(history.messages, automodMessage{
+		ID:        message.ID,
+		ChannelID: message.ChannelID,
+		Content:   normalizeAutomodContent(message.Content),
+		Time:      now,
+	})
+
+	return slices.Clone(history.messages)
+}
+
+func (m *AutomodModule) forgetMessages(guildID string, userID string, forget []automodMessage) {
+	m.historiesLock.Lock()
+	defer m.historiesLock.Unlock()
+
+	history, ok := m.histories[guildID+"|"+userID]
+	if !ok {
+		return
+	}
+	history.messages = slices.DeleteFunc(history.messages, func(message automodMessage) bool {
+		return slices.ContainsFunc(forget, func(other automodMessage) bool {
+			return other.ID == message.ID
+		})
+	})
+}
+
+// Drops histories of members that went quiet, so memory stays bounded by the
+// number of recently active members
+func (m *AutomodModule) pruneHistories(now time.Time) error {
+	m.historiesLock.Lock()
+	defer m.historiesLock.Unlock()
+
+	cutoff := now.Add(-m.historyWindow)
+	for key, history := range m.histories {
+		history.prune(cutoff)
+		if len(history.messages) == 0 {
+			delete(m.histories, key)
+		}
+	}
+	return nil
+}
+
+func (h *automodHistory) prune(cutoff time.Time) {
+	i := 0
+	for i < len(h.messages) && h.messages[i].Time.Before(cutoff) {
+		i++
+	}
+	h.messages = h.messages[i:]
+}
+
+func normalizeAutomodContent(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
+
+// Returns the messages that violate the rule, empty if the message is fine.
+// Flood rules return every message of the flood, content rules just the
+// message itself.
+func (rule *AutomodRule) Match(message *discordgo.Message, recent []automodMessage) []automodMessage {
+	current := recent[len(recent)-1]
+	cutoff := current.Time.Add(-time.Duration(rule.Window))
+
+	switch rule.Type {
+	case AutomodDuplicates:
+		if current.Content == "" {
+			return nil
+		}
+		matched := []automodMessage{}
+		for _, previous := range recent {
+			if !previous.Time.Before(cutoff) && previous.Content == current.Content {
+				matched = append(matched, previous)
+			}
+		}
+		if len(matched) >= rule.Count {
+			return matched
+		}
+
+	case AutomodRate:
+		matched := []automodMessage{}
+		for _, previous := range recent {
+			if !previous.Time.Before(cutoff) {
+				matched = append(matched, previous)
+			}
+		}
+		if len(matched) >= rule.Count {
+			return matched
+		}
+
+	case AutomodInvites:
+		if inviteRegexp.MatchString(message.Content) {
+			return []automodMessage{current}
+		}
+
+	case AutomodMentions:
+		mentions := len(message.Mentions) + len(message.MentionRoles)
+		if message.MentionEveryone {
+			mentions++
+		}
+		if mentions >= rule.Count {
+			return []automodMessage{current}
+		}
+
+	case AutomodWords:
+		if rule.wordsRegexp != nil && rule.wordsRegexp.MatchString(message.Content) {
+			return []automodMessage{current}
+		}
+		for _, pattern := range rule.patternRegexps {
+			if pattern.MatchString(message.Content) {
+				return []automodMessage{current}
+			}
+		}
+
+	case AutomodZalgo:
+		marks, total := 0, 0
+		for _, r := range message.Content {
+			total++
+			if unicode.Is(unicode.Mn, r) {
+				marks++
+			}
+		}
+		if marks >= rule.MinLength && float64(marks)/float64(total) >= rule.Ratio {
+			return []automodMessage{current}
+		}
+
+	case AutomodCaps:
+		upper, letters := 0, 0
+		for _, r := range message.Content {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+		if letters >= rule.MinLength && float64(upper)/float64(letters) >= rule.Ratio {
+			return []automodMessage{current}
+		}
+	}
+
+	return nil
+}
+
+func (m *AutomodModule) ApplyRule(rule *AutomodRule, message *discordgo.Message, matched []automodMessage) error {
+	automodLog.Info("Rule hit", "rule", rule.Name, "guild", message.GuildID, "channel", message.ChannelID, "user", message.Author.ID)
+
+	locales := GuildLocales(m.Discord.CachedGuild(message.GuildID))
+	data := NewTemplateData()
+	data.Rule = rule.Name
+	reason := m.Localizer.Text("automod.reason", data, locales...)
+
+	for _, action := range rule.Actions {
+		switch action {
+		case AutomodDelete:
+			m.deleteMessages(matched)
+
+		case AutomodWarn:
+			_, err := m.Moderation.Apply(&ModerationAction{
+				GuildID:     message.GuildID,
+				Type:        ActionWarn,
+				TargetID:    message.Author.ID,
+				ModeratorID: m.Discord.BotUserID(),
+				Reason:      reason,
+			})
+			if err != nil {
+				automodLog.Warn("Could not warn user", "guild", message.GuildID, "user", message.Author.ID, "error", ErrorToStr(err))
+			}
+
+		case AutomodTimeout:
+			var err error
+			if m.Moderation != nil {
+				_, err = m.Moderation.Apply(&ModerationAction{
+					GuildID:     message.GuildID,
+					Type:        ActionTimeout,
+					TargetID:    message.Author.ID,
+					ModeratorID: m.Discord.BotUserID(),
+					Reason:      reason,
+					Duration:    time.Duration(rule.TimeoutDuration),
+				})
+			} else {
+				until := time.Now().Add(time.Duration(rule.TimeoutDuration))
+				err = m.Discord.GuildMemberTimeout(message.GuildID, message.Author.ID, &until, discordgo.WithAuditLogReason(reason))
+			}
+			if err != nil {
+				automodLog.Warn("Could not time out user", "guild", message.GuildID, "user", message.Author.ID, "error", ErrorToStr(err))
+			}
+
+		case AutomodLog:
+			err := m.logHit(rule, message, locales)
+			if err != nil {
+				automodLog.Warn("Could not log rule hit", "guild", message.GuildID, "user", message.Author.ID, "error", ErrorToStr(err))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *AutomodModule) deleteMessages(messages []automodMessage) {
+	byChannel := map[string][]string{}
+	for _, message := range messages {
+		byChannel[message.ChannelID] = append(byChannel[message.ChannelID], message.ID)
+	}
+
+	for channelID, messageIDs := range byChannel {
+		// Bulk deletes take at most 100 messages
+		for len(messageIDs) > 0 {
+			batch := messageIDs[:min(len(messageIDs), 100)]
+			messageIDs = messageIDs[len(batch):]
+
+			var err error
+			if len(batch) == 1 {
+				err = m.Discord.ChannelMessageDelete(channelID, batch[0])
+			} else {
+				err = m.Discord.ChannelMessagesBulkDelete(channelID, batch)
+			}
+			if err != nil {
+				automodLog.Warn("Could not delete messages", "channel", channelID, "error", err)
+			}
+		}
+	}
+}
+
+func (m *AutomodModule) logHit(rule *AutomodRule, message *discordgo.Message, locales []discordgo.Locale) error {
+	if m.Config.LogChannel == "" {
+		return nil
+	}
+
+	data := NewTemplateData()
+	data.Rule = rule.Name
+
+	actions := []string{}
+	for _, action := range rule.Actions {
+		actions = append(actions, string(action))
+	}
+
+	content := truncateText(message.Content, 1000)
+	if content == "" {
+		content = "-"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Title: m.Localizer.Text("automod.log_title", data, locales...),
+		Color: ColorDarkOrange,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    message.Author.Username,
+			IconURL: message.Author.AvatarURL(""),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   m.Localizer.Text("moderation.field.user", nil, locales...),
+				Value:  fmt.Sprintf("<@%s> (%s)", message.Author.ID, message.Author.ID),
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("automod.field.channel", nil, locales...),
+				Value:  "<#" + message.ChannelID + ">",
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("automod.field.actions", nil, locales...),
+				Value:  strings.Join(actions, ", "),
+				Inline: true,
+			},
+			{
+				Name:  m.Localizer.Text("automod.field.message", nil, locales...),
+				Value: content,
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	_, err := m.Discord.ChannelMessageSendEmbed(m.Config.LogChannel, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
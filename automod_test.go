@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestAutomodRuleMatch(t *testing.T) {
+	now := time.Now()
+	// Earlier messages of the author, seconds ago
+	type earlier struct {
+		content string
+		age     int
+	}
+
+	tests := []struct {
+		name    string
+		rule    AutomodRule
+		earlier []earlier
+		message *discordgo.Message
+		matched int
+	}{
+		{"duplicates", AutomodRule{Type: AutomodDuplicates, Count: 3, Window: Duration(time.Minute)},
+			[]earlier{{"buy now", 20}, {"Buy  NOW", 10}}, &discordgo.Message{Content: "buy now"}, 3},
+		{"duplicates outside the window", AutomodRule{Type: AutomodDuplicates, Count: 3, Window: Duration(time.Minute)},
+			[]earlier{{"buy now", 90}, {"buy now", 10}}, &discordgo.Message{Content: "buy now"}, 0},
+		{"different messages", AutomodRule{Type: AutomodDuplicates, Count: 2, Window: Duration(time.Minute)},
+			[]earlier{{"hello", 10}}, &discordgo.Message{Content: "hello there"}, 0},
+		{"empty messages aren't duplicates", AutomodRule{Type: AutomodDuplicates, Count: 2, Window: Duration(time.Minute)},
+			[]earlier{{"", 10}}, &discordgo.Message{}, 0},
+		{"rate", AutomodRule{Type: AutomodRate, Count: 4, Window: Duration(10 * time.Second)},
+			[]earlier{{"a", 30}, {"b", 8}, {"c", 5}, {"d", 1}}, &discordgo.Message{Content: "e"}, 4},
+		{"rate below the count", AutomodRule{Type: AutomodRate, Count: 4, Window: Duration(10 * time.Second)},
+			[]earlier{{"a", 30}, {"b", 20}, {"c", 5}}, &discordgo.Message{Content: "d"}, 0},
+		{"invite", AutomodRule{Type: AutomodInvites}, nil, &discordgo.Message{Content: "join discord.gg/abc-123 now"}, 1},
+		{"invite link", AutomodRule{Type: AutomodInvites}, nil, &discordgo.Message{Content: "https://discordapp.com/invite/abc"}, 1},
+		{"no invite", AutomodRule{Type: AutomodInvites}, nil, &discordgo.Message{Content: "discord.gg is blocked here"}, 0},
+		{"mentions", AutomodRule{Type: AutomodMentions, Count: 3}, nil,
+			&discordgo.Message{Mentions: []*discordgo.User{{ID: "1"}, {ID: "2"}}, MentionRoles: []string{"3"}}, 1},
+		{"everyone counts", AutomodRule{Type: AutomodMentions, Count: 2}, nil,
+			&discordgo.Message{Mentions: []*discordgo.User{{ID: "1"}}, MentionEveryone: true}, 1},
+		{"few mentions", AutomodRule{Type: AutomodMentions, Count: 3}, nil, &discordgo.Message{Mentions: []*discordgo.User{{ID: "1"}}}, 0},
+		{"word", AutomodRule{Type: AutomodWords, Words: []string{"bad"}}, nil, &discordgo.Message{Content: "This is BAD!"}, 1},
+		{"word inside another", AutomodRule{Type: AutomodWords, Words: []string{"bad"}}, nil, &discordgo.Message{Content: "a badge"}, 0},
+		{"word inside a non-ASCII word", AutomodRule{Type: AutomodWords, Words: []string{"ber"}}, nil, &discordgo.Message{Content: "über"}, 0},
+		{"word with symbols", AutomodRule{Type: AutomodWords, Words: []string{"c++"}}, nil, &discordgo.Message{Content: "I like c++ a lot"}, 1},
+		{"non-ASCII word", AutomodRule{Type: AutomodWords, Words: []string{"bär"}}, nil, &discordgo.Message{Content: "Ein Bär!"}, 1},
+		{"pattern", AutomodRule{Type: AutomodWords, Patterns: []string{`free\s+nitro`}}, nil, &discordgo.Message{Content: "get free  nitro"}, 1},
+		{"zalgo", AutomodRule{Type: AutomodZalgo}, nil, &discordgo.Message{Content: "h" + strings.Repeat("́̂", 6) + "i"}, 1},
+		{"a few accents", AutomodRule{Type: AutomodZalgo}, nil, &discordgo.Message{Content: "café olé"}, 0},
+		{"caps", AutomodRule{Type: AutomodCaps}, nil, &discordgo.Message{Content: "WHY IS NOBODY ANSWERING"}, 1},
+		{"short caps", AutomodRule{Type: AutomodCaps}, nil, &discordgo.Message{Content: "OK LOL"}, 0},
+		{"some caps", AutomodRule{Type: AutomodCaps}, nil, &discordgo.Message{Content: "I read the FAQ and the README"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &AutomodConfig{Rules: []*AutomodRule{&test.rule}}
+			err := config.Validate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule := NewAutomodModule(config).rules[0]
+
+			recent := []automodMessage{}
+			for i, message := range test.earlier {
+				recent = append(recent, automodMessage{
+					ID:      strconv.Itoa(i),
+					Content: normalizeAutomodContent(message.content),
+					Time:    now.Add(-time.Duration(message.age) * time.Second),
+				})
+			}
+			recent = append(recent, automodMessage{ID: "current", Content: normalizeAutomodContent(test.message.Content), Time: now})
+
+			matched := rule.Match(test.message, recent)
+			if len(matched) != test.matched {
+				t.Fatalf("matched %v messages, want %v", len(matched), test.matched)
+			}
+		})
+	}
+}
+
+func TestAutomodConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule AutomodRule
+	}{
+		{"unknown type", AutomodRule{Type: "spam"}},
+		{"rate without window", AutomodRule{Type: AutomodRate, Count: 5}},
+		{"invalid pattern", AutomodRule{Type: AutomodWords, Patterns: []string{"(unclosed"}}},
+		{"ratio above 1", AutomodRule{Type: AutomodCaps, Ratio: 70}},
+		{"timeout without duration", AutomodRule{Type: AutomodInvites, Actions: []AutomodActionType{AutomodTimeout}}},
+		{"unknown action", AutomodRule{Type: AutomodInvites, Actions: []AutomodActionType{"ban"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &AutomodConfig{Rules: []*AutomodRule{&test.rule}}
+			if config.Validate() == nil {
+				t.Fatalf("%+v was accepted", test.rule)
+			}
+		})
+	}
+
+	// Defaults are filled in by the module, not the config
+	rule := &AutomodRule{Type: AutomodCaps}
+	config := &AutomodConfig{Rules: []*AutomodRule{rule}}
+	err := config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared := NewAutomodModule(config).rules[0]
+	if rule.Name != "" || rule.Ratio != 0 || prepared.Name != "caps" || prepared.Ratio != 0.7 || prepared.MinLength != 10 {
+		t.Fatalf("config rule %+v, module rule %+v", rule, prepared)
+	}
+}
+
+func TestAutomodDeleteMessagesInBatches(t *testing.T) {
+	discord := NewRecordingDiscord()
+	m := NewAutomodModule(&AutomodConfig{})
+	m.Discord = discord
+
+	counts := map[string]int{"10": 201, "20": 1, "30": 2}
+	messages := []automodMessage{}
+	for channelID, count := range counts {
+		for i := 0; i < count; i++ {
+			message := &discordgo.Message{ID: channelID + strconv.Itoa(i), ChannelID: channelID}
+			discord.Messages[channelID] = append(discord.Messages[channelID], message)
+			messages = append(messages, automodMessage{ID: message.ID, ChannelID: channelID})
+		}
+	}
+
+	m.deleteMessages(messages)
+
+	for channelID := range counts {
+		if remaining := len(discord.Messages[channelID]); remaining != 0 {
+			t.Errorf("%v messages left in channel %v", remaining, channelID)
+		}
+	}
+	calls := map[string]int{}
+	for _, call := range discord.Calls {
+		calls[call]++
+	}
+	if calls["ChannelMessagesBulkDelete"] != 3 || calls["ChannelMessageDelete"] != 2 {
+		t.Errorf("deleted with %v", discord.Calls)
+	}
+}
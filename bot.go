@@ -6,66 +6,288 @@ import (
 	"database/sql"
 	"github.com/bwmarrin/discordgo"
 	_ "github.com/lib/pq"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 type Config struct {
 	DiscordToken       string
 	DbConnectionString string
+	// Base URL of the Discord API, only set to test against a fake server
+	DiscordEndpoint string
+	// How long shutdown waits for running handlers, defaults to 30s
+	ShutdownTimeout Duration
 
+	Localization *LocalizationConfig
+	Logging      *LoggingConfig
+	Metrics      *MetricsConfig
+	Health       *HealthConfig
+	Outbound     *OutboundConfig
+	Modules      *ModulesConfig
+
+	// Module sections, see RegisterModule in the module files
 	VerificationSystem *VerificationConfig
+	Moderation         *ModerationConfig
+	Automod            *AutomodConfig
+	AntiRaid           *AntiRaidConfig
+	Lockdown           *LockdownConfig
+	RoleMenus          *RoleMenuConfig
+	MessageLog         *MessageLogConfig
+	Welcome            *WelcomeConfig
+	Tickets            *TicketConfig
+	Modmail            *ModmailConfig
+	Announcements      *AnnouncementsConfig
+	Reminders          *RemindersConfig
 }
 
-type Module interface {
-	Register(*Bot)
+func (c *Config) Validate() error {
+	if c.Logging != nil {
+		err := c.Logging.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Metrics != nil {
+		err := c.Metrics.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Health != nil {
+		err := c.Health.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Outbound != nil {
+		err := c.Outbound.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return validateModules(c)
 }
 
 type Bot struct {
-	Discord *Discord
-	DB      *sql.DB
+	Discord   *Discord
+	DB        *sql.DB
+	Localizer *Localizer
+	Router    *InteractionRouter
+	Scheduler *Scheduler
+	// Nil without a database
+	Jobs    *JobQueue
+	HTTP    *HTTPServers
 	Modules []Module
+
+	// Gateway intents by module
+	intents            map[string]discordgo.Intent
+	shutdownTimeout    time.Duration
+	gatewayGracePeriod time.Duration
+
+	// Modules registered and connected, for the readiness probe
+	started  atomic.Bool
+	stopping atomic.Bool
 }
 
 func NewBot(config *Config) (*Bot, error) {
-	Logf("Initializing bot instance")
+	Log.Info("Initializing bot instance")
 
-	discord, err := NewDiscord(config.DiscordToken)
+	discord, err := NewDiscord(config.DiscordToken, config.DiscordEndpoint, config.Outbound)
 	if err != nil {
 		return nil, err
 	}
 
-	modules := []Module{
-		NewVerificationModule(config.VerificationSystem),
+	localizer, err := NewLocalizer(config.Localization)
+	if err != nil {
+		return nil, err
+	}
+
+	var db *sql.DB
+	if config.DbConnectionString != "" {
+		db, err = sql.Open("postgres", config.DbConnectionString)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		err = db.Ping()
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		Log.Info("Connected to database")
+	}
+
+	modules, intents, err := createModules(config, db != nil)
+	if err != nil {
+		return nil, err
 	}
+	setGuildModules(config.Modules)
 
 	bot := &Bot{
-		Discord: discord,
-		Modules: modules,
+		Discord:   discord,
+		DB:        db,
+		Localizer: localizer,
+		Router:    NewInteractionRouter(),
+		Scheduler: NewScheduler(),
+		HTTP:      NewHTTPServers(),
+		Modules:   modules,
+
+		intents:         intents,
+		shutdownTimeout: 30 * time.Second,
+	}
+	if db != nil {
+		bot.Jobs = NewJobQueue(db)
+	}
+	bot.Router.OnDisabled = bot.respondModuleDisabled
+	if config.ShutdownTimeout > 0 {
+		bot.shutdownTimeout = time.Duration(config.ShutdownTimeout)
 	}
-	Logf("Initialization done")
+	if config.Metrics != nil {
+		path := config.Metrics.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		bot.HTTP.Handle(config.Metrics.Listen, path, Metrics)
+	}
+	if config.Health != nil {
+		bot.gatewayGracePeriod = time.Duration(config.Health.GatewayGracePeriod)
+		if bot.gatewayGracePeriod == 0 {
+			bot.gatewayGracePeriod = 2 * time.Minute
+		}
+		bot.HTTP.Handle(config.Health.Listen, "/healthz", http.HandlerFunc(bot.serveHealthz))
+		bot.HTTP.Handle(config.Health.Listen, "/readyz", http.HandlerFunc(bot.serveReadyz))
+	}
+	Log.Info("Initialization done")
 	return bot, nil
 }
 
-func (bot *Bot) Run() error {
-	bot.Discord.Identify.Intents = discordgo.IntentsAll
+// Finds an enabled module by type, e.g. FindModule[*ModerationModule](bot).
+// Returns the zero value if the module isn't enabled.
+func FindModule[T Module](bot *Bot) T {
+	for _, module := range bot.Modules {
+		if found, ok := module.(T); ok {
+			return found
+		}
+	}
+
+	var zero T
+	return zero
+}
 
+// Registers the modules and connects, returns once the bot is running
+func (bot *Bot) Start() error {
+	if bot.Jobs != nil {
+		err := bot.Jobs.Register(bot)
+		if err != nil {
+			return err
+		}
+	}
 	for _, module := range bot.Modules {
-		module.Register(bot)
+		err := module.Register(bot)
+		if err != nil {
+			return err
+		}
+	}
+	err := bot.Localizer.CheckCommandKeys(bot.Router.definitions)
+	if err != nil {
+		return err
 	}
+	bot.Discord.AddHandler(func(_ *discordgo.Session, event *discordgo.InteractionCreate) {
+		bot.Discord.track(func() {
+			bot.Router.OnInteractionCreate(event)
+		})
+	})
 
-	err := bot.Discord.Open()
+	err = bot.HTTP.Start()
 	if err != nil {
-		return WrapError(err)
+		return err
+	}
+
+	err = bot.Discord.OpenWithIntents(bot.intents)
+	if err != nil {
+		return err
+	}
+	Log.Info("Connected")
+
+	err = bot.Router.SyncCommands(bot.Discord, bot.Localizer)
+	if err != nil {
+		return err
+	}
+
+	for _, module := range bot.Modules {
+		starter, ok := module.(ModuleStarter)
+		if !ok {
+			continue
+		}
+		err := starter.Start()
+		if err != nil {
+			return err
+		}
+	}
+	bot.Scheduler.Start()
+	if bot.Jobs != nil {
+		bot.Jobs.Start()
+	}
+	bot.started.Store(true)
+	return nil
+}
+
+// Shuts down in order: new events are dropped, running handlers, scheduled
+// tasks and jobs get to finish, then modules are stopped, pending
+// announcements sent and the gateway and the database closed. The readiness
+// probe fails from the start, probes keep being answered until everything
+// else is closed.
+func (bot *Bot) Stop() {
+	bot.stopping.Store(true)
+
+	Log.Info("Waiting for running handlers", "timeout", bot.shutdownTimeout.String())
+	if !bot.Discord.DrainHandlers(bot.shutdownTimeout) {
+		Log.Warn("Handlers still running after shutdown timeout")
+	}
+	bot.Scheduler.Stop()
+	if bot.Jobs != nil {
+		bot.Jobs.Stop()
+	}
+
+	for i := len(bot.Modules) - 1; i >= 0; i-- {
+		stopper, ok := bot.Modules[i].(ModuleStopper)
+		if !ok {
+			continue
+		}
+		err := stopper.Stop()
+		if err != nil {
+			Log.Error("Stopping module failed", "module", moduleName(bot.Modules[i]), "error", ErrorToStr(err))
+		}
+	}
+	bot.Discord.FlushAnnouncements()
+
+	bot.Discord.Close()
+	if bot.DB != nil {
+		bot.DB.Close()
+	}
+	bot.HTTP.Stop()
+	Log.Info("Stopped")
+}
+
+// Runs the bot until it is interrupted
+func (bot *Bot) Run() error {
+	err := bot.Start()
+	if err != nil {
+		return err
 	}
-	Logf("Connected!")
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
-	Logf("Exiting ...")
-	bot.Discord.Close()
+	Log.Info("Exiting")
+	go func() {
+		<-sc
+		Log.Warn("Interrupted again, exiting without waiting")
+		os.Exit(1)
+	}()
+	bot.Stop()
 	return nil
 }
@@ -3,30 +3,19 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/go-errors/errors"
-	"log"
-	"runtime"
+	"github.com/tailscale/hujson"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Error = *errors.Error
 
-func Logf(format string, args ...any) {
-	pc, file, line, _ := runtime.Caller(1)
-
-	files := strings.Split(file, "/")
-	file = files[len(files)-1]
-
-	name := runtime.FuncForPC(pc).Name()
-	fns := strings.Split(name, ".")
-	name = fns[len(fns)-1]
-
-	msg := fmt.Sprintf(format, args...)
-
-	log.Printf("[FBot] %s:%d:%s() %s\n", file, line, name, msg)
-}
-
 func WrapError(err error) Error {
 	return errors.Wrap(err, 1)
 }
@@ -39,3 +28,138 @@ func ErrorToStr(err error) string {
 		return err.Error()
 	}
 }
+
+// Unmarshals JSON with comments and trailing commas
+func UnmarshalJSONC(jsonBytes []byte, v any) error {
+	ast, err := hujson.Parse(jsonBytes)
+	if err != nil {
+		return WrapError(err)
+	}
+	ast.Standardize()
+
+	err = json.Unmarshal(ast.Pack(), v)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Human readable duration using the two largest units, e.g. "3 days 4 hours"
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+
+	units := []struct {
+		name   string
+		length time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+
+	parts := []string{}
+	for _, unit := range units {
+		count := d / unit.length
+		if count == 0 {
+			continue
+		}
+		d -= count * unit.length
+
+		part := fmt.Sprintf("%d %s", count, unit.name)
+		if count != 1 {
+			part += "s"
+		}
+		parts = append(parts, part)
+		if len(parts) == 2 {
+			break
+		}
+	}
+
+	if len(parts) == 0 {
+		return "0 seconds"
+	}
+	return strings.Join(parts, " ")
+}
+
+var durationPattern = regexp.MustCompile(`(\d+)\s*([a-z]+)`)
+
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// Parses durations like "1h30m", "2d" or "1 hour and 30 minutes"
+func ParseDuration(text string) (time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return 0, Errorf("empty duration")
+	}
+
+	var total time.Duration
+	matches := durationPattern.FindAllStringSubmatchIndex(text, -1)
+	rest := text
+	for _, match := range matches {
+		count, err := strconv.Atoi(text[match[2]:match[3]])
+		if err != nil {
+			return 0, Errorf("invalid duration %q", text)
+		}
+		unit, ok := durationUnits[text[match[4]:match[5]]]
+		if !ok {
+			return 0, Errorf("unknown unit %q in duration %q", text[match[4]:match[5]], text)
+		}
+		if int64(count) > math.MaxInt64/int64(unit) || total > math.MaxInt64-time.Duration(count)*unit {
+			return 0, Errorf("duration %q is too long", text)
+		}
+		total += time.Duration(count) * unit
+		rest = strings.Replace(rest, text[match[0]:match[1]], "", 1)
+	}
+
+	// Everything apart from the numbers and units has to be filler
+	rest = strings.NewReplacer(",", " ", "and", " ").Replace(rest)
+	if len(matches) == 0 || strings.TrimSpace(rest) != "" {
+		return 0, Errorf("invalid duration %q", text)
+	}
+	return total, nil
+}
+
+// Cuts text to at most max characters, marking that it was cut
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+func Ptr[T any](value T) *T {
+	return &value
+}
+
+func Errorf(format string, args ...any) Error {
+	return errors.Wrap(fmt.Errorf(format, args...), 1)
+}
+
+// Duration configured as a string like "30d" or "1h30m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+
+	duration, err := ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  time.Duration
+		valid bool
+	}{
+		{"10m", 10 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"2 days, 3 hours and 5 minutes", 51*time.Hour + 5*time.Minute, true},
+		{"1W", 7 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"10", 0, false},
+		{"10 parsecs", 0, false},
+		{"10m later", 0, false},
+		{"99999999999 days", 0, false},
+		{"15300 weeks", 0, false},
+		{"106751 days 23 hours 47 minutes 16 seconds", 106751*24*time.Hour + 23*time.Hour + 47*time.Minute + 16*time.Second, true},
+		{"106751 days 24 hours", 0, false},
+		{"9223372036854775807 seconds", 0, false},
+		{"99999999999999999999s", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			duration, err := ParseDuration(test.text)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("%q was accepted as %v", test.text, duration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duration != test.want {
+				t.Fatalf("%q is %v, want %v", test.text, duration, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Config overrides from the environment
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Prefix of environment variables overriding config keys
+const envPrefix = "FBOT_"
+
+// Path of the config file, unless given with --config
+const envConfigPath = envPrefix + "CONFIG"
+
+// Applies FBOT_ environment variables to the config. Keys are the config path
+// in upper snake case with "__" between levels, e.g. FBOT_DISCORD_TOKEN or
+// FBOT_LOGGING__LEVEL. Values that aren't strings are JSON, e.g.
+// FBOT_MODULES__DISABLED='["automod"]'. With a _FILE suffix the value is read
+// from the named file, e.g. FBOT_DISCORD_TOKEN_FILE=/run/secrets/token.
+func ApplyEnvOverrides(config *Config, environ []string) error {
+	// Sorted so that a parent key is set before its children
+	sort.Strings(environ)
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, envPrefix) || name == envConfigPath {
+			continue
+		}
+		path := strings.Split(strings.TrimPrefix(name, envPrefix), "__")
+
+		found, err := setConfigValue(reflect.ValueOf(config).Elem(), path, value)
+		if err != nil {
+			return Errorf("%v: %v", name, err)
+		}
+		if found {
+			continue
+		}
+
+		last := path[len(path)-1]
+		if !strings.HasSuffix(last, "_FILE") {
+			return Errorf("%v doesn't match a config key", name)
+		}
+		path[len(path)-1] = strings.TrimSuffix(last, "_FILE")
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return Errorf("%v: %v", name, err)
+		}
+		found, err = setConfigValue(reflect.ValueOf(config).Elem(), path, strings.TrimRight(string(content), "\r\n"))
+		if err != nil {
+			return Errorf("%v: %v", name, err)
+		}
+		if !found {
+			return Errorf("%v doesn't match a config key", name)
+		}
+	}
+	return nil
+}
+
+// "DbConnectionString" becomes "DB_CONNECTION_STRING", "RoleID" "ROLE_ID"
+func envKey(fieldName string) string {
+	runes := []rune(fieldName)
+	key := []rune{}
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				key = append(key, '_')
+			}
+		}
+		key = append(key, unicode.ToUpper(r))
+	}
+	return string(key)
+}
+
+// Sets the value at path below target, allocating nil pointers and maps on
+// the way. Returns false if the path doesn't exist. Map keys are matched
+// case-insensitively, new ones are lowercase.
+func setConfigValue(target reflect.Value, path []string, text string) (bool, error) {
+	if len(path) == 0 {
+		return true, setConfigLeaf(target, text)
+	}
+
+	switch target.Kind() {
+	case reflect.Pointer:
+		if !target.IsNil() {
+			return setConfigValue(target.Elem(), path, text)
+		}
+		// Only kept if the path exists
+		value := reflect.New(target.Type().Elem())
+		found, err := setConfigValue(value.Elem(), path, text)
+		if found && err == nil {
+			target.Set(value)
+		}
+		return found, err
+
+	case reflect.Struct:
+		for i := 0; i < target.NumField(); i++ {
+			field := target.Type().Field(i)
+			if field.IsExported() && envKey(field.Name) == path[0] {
+				return setConfigValue(target.Field(i), path[1:], text)
+			}
+		}
+		return false, nil
+
+	case reflect.Map:
+		if target.Type().Key().Kind() != reflect.String {
+			return false, nil
+		}
+		key := reflect.ValueOf(strings.ToLower(path[0])).Convert(target.Type().Key())
+		element := reflect.New(target.Type().Elem()).Elem()
+		iterator := target.MapRange()
+		for iterator.Next() {
+			if strings.EqualFold(iterator.Key().String(), path[0]) {
+				key = iterator.Key()
+				element.Set(iterator.Value())
+				break
+			}
+		}
+
+		found, err := setConfigValue(element, path[1:], text)
+		if found && err == nil {
+			if target.IsNil() {
+				target.Set(reflect.MakeMap(target.Type()))
+			}
+			target.SetMapIndex(key, element)
+		}
+		return found, err
+	}
+	return false, nil
+}
+
+// Strings are taken as they are, everything else is parsed as JSON. Types
+// configured as strings, like durations and templates, also take bare text.
+func setConfigLeaf(target reflect.Value, text string) error {
+	if target.Kind() == reflect.String {
+		target.SetString(text)
+		return nil
+	}
+
+	value := reflect.New(target.Type())
+	err := json.Unmarshal([]byte(text), value.Interface())
+	if err != nil {
+		quoted, _ := json.Marshal(text)
+		if json.Unmarshal(quoted, value.Interface()) != nil {
+			return err
+		}
+	}
+	target.Set(value.Elem())
+	return nil
+}
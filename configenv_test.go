@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvKey(t *testing.T) {
+	tests := map[string]string{
+		"DiscordToken":       "DISCORD_TOKEN",
+		"DbConnectionString": "DB_CONNECTION_STRING",
+		"RoleID":             "ROLE_ID",
+		"VerificationSystem": "VERIFICATION_SYSTEM",
+		"URL":                "URL",
+		"DmMessage":          "DM_MESSAGE",
+	}
+
+	for fieldName, want := range tests {
+		if key := envKey(fieldName); key != want {
+			t.Errorf("%v became %v, want %v", fieldName, key, want)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	directory := t.TempDir()
+	tokenFile := filepath.Join(directory, "token")
+	err := os.WriteFile(tokenFile, []byte("secret\r\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		environ []string
+		valid   bool
+		want    func(config *Config) bool
+	}{
+		{
+			"plain string", Config{}, []string{"FBOT_DISCORD_TOKEN=token"}, true,
+			func(config *Config) bool { return config.DiscordToken == "token" },
+		},
+		{
+			"other variables", Config{}, []string{"PATH=/bin", "FBOT_CONFIG=config.jsonc", "TEST_FBOT_DB=postgres://"}, true,
+			func(config *Config) bool { return reflect.DeepEqual(*config, Config{}) },
+		},
+		{
+			"nil pointer is allocated", Config{}, []string{"FBOT_LOGGING__LEVEL=debug"}, true,
+			func(config *Config) bool { return config.Logging != nil && config.Logging.Level == "debug" },
+		},
+		{
+			"unknown key", Config{}, []string{"FBOT_LOGGING__COLOR=red"}, false,
+			func(config *Config) bool { return config.Logging == nil },
+		},
+		{
+			"unknown top-level key", Config{}, []string{"FBOT_TEST_DB=postgres://"}, false, nil,
+		},
+		{
+			"JSON leaf", Config{}, []string{`FBOT_MODULES__DISABLED=["automod"]`}, true,
+			func(config *Config) bool {
+				return config.Modules != nil && reflect.DeepEqual(config.Modules.Disabled, []string{"automod"})
+			},
+		},
+		{
+			"invalid JSON leaf", Config{}, []string{"FBOT_MODULES__DISABLED=automod"}, false, nil,
+		},
+		{
+			"bare duration", Config{}, []string{"FBOT_SHUTDOWN_TIMEOUT=45s"}, true,
+			func(config *Config) bool { return time.Duration(config.ShutdownTimeout) == 45*time.Second },
+		},
+		{
+			"bare template", Config{}, []string{"FBOT_MODERATION__DM_MESSAGE=You were {{.Action}}"}, true,
+			func(config *Config) bool {
+				return config.Moderation != nil && config.Moderation.DmMessage != nil && config.Moderation.DmMessage.Content != nil
+			},
+		},
+		{
+			"existing map key", Config{Logging: &LoggingConfig{Modules: map[string]string{"AutoMod": "info"}}},
+			[]string{"FBOT_LOGGING__MODULES__AUTOMOD=debug"}, true,
+			func(config *Config) bool {
+				return reflect.DeepEqual(config.Logging.Modules, map[string]string{"AutoMod": "debug"})
+			},
+		},
+		{
+			"new map key", Config{}, []string{"FBOT_LOGGING__MODULES__AUTOMOD=debug"}, true,
+			func(config *Config) bool {
+				return config.Logging != nil && reflect.DeepEqual(config.Logging.Modules, map[string]string{"automod": "debug"})
+			},
+		},
+		{
+			"map of pointers", Config{}, []string{`FBOT_MODULES__GUILDS__123__DISABLED=["tickets"]`}, true,
+			func(config *Config) bool {
+				return config.Modules != nil && config.Modules.Guilds["123"] != nil &&
+					reflect.DeepEqual(config.Modules.Guilds["123"].Disabled, []string{"tickets"})
+			},
+		},
+		{
+			"file", Config{}, []string{"FBOT_DISCORD_TOKEN_FILE=" + tokenFile}, true,
+			func(config *Config) bool { return config.DiscordToken == "secret" },
+		},
+		{
+			"missing file", Config{}, []string{"FBOT_DISCORD_TOKEN_FILE=" + filepath.Join(directory, "missing")}, false, nil,
+		},
+		{
+			"file of an unknown key", Config{}, []string{"FBOT_DISCORD_PASSWORD_FILE=" + tokenFile}, false, nil,
+		},
+		{
+			"parent before child", Config{},
+			[]string{"FBOT_LOGGING__LEVEL=debug", `FBOT_LOGGING={"Level": "warn", "Format": "json"}`}, true,
+			func(config *Config) bool {
+				return config.Logging != nil && config.Logging.Level == "debug" && config.Logging.Format == "json"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			err := ApplyEnvOverrides(&config, test.environ)
+			if test.valid && err != nil {
+				t.Fatalf("%v was rejected: %v", test.environ, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("%v was accepted", test.environ)
+			}
+			if test.want != nil && !test.want(&config) {
+				t.Fatalf("%v gave %+v", test.environ, config)
+			}
+		})
+	}
+}
@@ -2,20 +2,366 @@
 
 package main
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+// For downloads from the Discord CDN
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Attachments bigger than this aren't downloaded, it's the upload limit of
+// servers without boosts
+const maxAttachmentSize = 25 * 1024 * 1024
 
 type Discord struct {
 	*discordgo.Session
+
+	connects  atomic.Int64
+	connected atomic.Bool
+	// Unix nanoseconds of the disconnect the gateway hasn't recovered from,
+	// 0 while connected
+	disconnectedAt atomic.Int64
+	handlers       handlerGate
+	announcer      *announcer
 }
 
-func NewDiscord(token string) (*Discord, error) {
+// Endpoint is the base URL of the API, empty for the real one. Outbound may
+// be nil for the defaults.
+func NewDiscord(token string, endpoint string, outbound *OutboundConfig) (*Discord, error) {
 	session, err := discordgo.New("Bot " + token)
 	if err != nil {
 		return nil, WrapError(err)
 	}
+	// Filtered by the "discordgo" module level instead
+	session.LogLevel = discordgo.LogDebug
+
+	transport := http.DefaultTransport
+	if endpoint != "" {
+		base, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		transport = &endpointTransport{base: base, next: transport}
+	}
+	session.Client.Transport = newOutboundTransport(outbound, &metricsTransport{next: transport})
 
 	discord := &Discord{
 		Session: session,
 	}
+	discord.announcer = newAnnouncer(discord, outbound)
+	discord.AddHandler(func(_ *discordgo.Session, event *discordgo.Event) {
+		gatewayEvents.Inc(event.Type)
+	})
+	discord.AddHandler(func(_ *discordgo.Session, event *discordgo.Connect) {
+		if discord.connects.Add(1) > 1 {
+			gatewayReconnects.Inc()
+		}
+	})
+	discord.AddHandler(func(_ *discordgo.Session, event *discordgo.Ready) {
+		discord.connected.Store(true)
+		discord.disconnectedAt.Store(0)
+	})
+	discord.AddHandler(func(_ *discordgo.Session, event *discordgo.Resumed) {
+		discord.connected.Store(true)
+		discord.disconnectedAt.Store(0)
+	})
+	discord.AddHandler(func(_ *discordgo.Session, event *discordgo.Disconnect) {
+		discord.connected.Store(false)
+		// Failed reconnect attempts don't restart the clock
+		discord.disconnectedAt.CompareAndSwap(0, time.Now().UnixNano())
+	})
+	NewGaugeFunc("fbot_gateway_latency_seconds", "Time between the last heartbeat and its acknowledgement.", func() float64 {
+		return max(discord.HeartbeatLatency().Seconds(), 0)
+	})
 	return discord, nil
 }
+
+// Tracks running event handlers so that shutdown can wait for them
+type handlerGate struct {
+	lock    sync.Mutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+// Adds a handler to the running ones, false once closed
+func (g *handlerGate) enter() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.closed {
+		return false
+	}
+	g.running.Add(1)
+	return true
+}
+
+func (g *handlerGate) leave() {
+	g.running.Done()
+}
+
+// Runs handler unless events are no longer accepted
+func (d *Discord) track(handler func()) {
+	if !d.handlers.enter() {
+		return
+	}
+	defer d.handlers.leave()
+	handler()
+}
+
+// Drops events from now on and waits for running handlers. Returns false if
+// they didn't finish within the timeout.
+func (d *Discord) DrainHandlers(timeout time.Duration) bool {
+	d.handlers.lock.Lock()
+	d.handlers.closed = true
+	d.handlers.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.handlers.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Intents every bot needs, guild events fill the state cache
+const baseIntents = discordgo.IntentsGuilds
+
+// Intents that have to be enabled in the developer portal, by their name there
+var privilegedIntents = []struct {
+	intent discordgo.Intent
+	name   string
+}{
+	{discordgo.IntentsGuildMembers, "Server Members Intent"},
+	{discordgo.IntentsGuildPresences, "Presence Intent"},
+	{discordgo.IntentsMessageContent, "Message Content Intent"},
+}
+
+// Connects to the gateway with the intents the modules need, keyed by module
+// name. When Discord rejects privileged intents the error says which ones
+// were requested for which modules.
+func (d *Discord) OpenWithIntents(moduleIntents map[string]discordgo.Intent) error {
+	intents := baseIntents
+	for _, moduleIntent := range moduleIntents {
+		intents |= moduleIntent
+	}
+	d.Identify.Intents = intents
+
+	err := d.Open()
+	var closeError *websocket.CloseError
+	if errors.As(err, &closeError) && closeError.Code == 4014 {
+		requested := []string{}
+		for _, privileged := range privilegedIntents {
+			if intents&privileged.intent == 0 {
+				continue
+			}
+			modules := []string{}
+			for module, moduleIntent := range moduleIntents {
+				if moduleIntent&privileged.intent != 0 {
+					modules = append(modules, module)
+				}
+			}
+			sort.Strings(modules)
+			requested = append(requested, fmt.Sprintf("%v (needed by %v)", privileged.name, strings.Join(modules, ", ")))
+		}
+		return Errorf("Discord rejected the privileged gateway intents. Enable %v under Bot > Privileged Gateway Intents in the developer portal, or disable the modules.", strings.Join(requested, " and "))
+	}
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Sends API requests to another server. The gateway URL is fetched from the
+// API, so the websocket connection follows along.
+type endpointTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *endpointTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = t.base.Scheme
+	request.URL.Host = t.base.Host
+	request.Host = t.base.Host
+	return t.next.RoundTrip(request)
+}
+
+// Whether the gateway session is identified or resumed, false while
+// reconnecting
+func (d *Discord) Connected() bool {
+	return d.connected.Load()
+}
+
+// How long the gateway has been disconnected, 0 while connected and before
+// the first connect
+func (d *Discord) DisconnectedFor() time.Duration {
+	since := d.disconnectedAt.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+func (d *Discord) BotUserID() string {
+	return d.State.User.ID
+}
+
+// Counts API requests by route, snowflakes and tokens in the path are
+// replaced so that routes don't explode the number of label values
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	route := apiRoute(request.URL.Path)
+	start := time.Now()
+	response, err := t.next.RoundTrip(request)
+	restDuration.Observe(time.Since(start).Seconds(), request.Method, route)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(response.StatusCode)
+		if response.StatusCode == http.StatusTooManyRequests {
+			restRateLimits.Inc(route)
+		}
+	}
+	restRequests.Inc(request.Method, route, status)
+	return response, err
+}
+
+// "/api/v10/channels/123/messages/456" becomes "/channels/:id/messages/:id"
+func apiRoute(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v"+discordgo.APIVersion), "/")
+	for i, part := range parts {
+		switch {
+		case part != "" && strings.Trim(part, "0123456789") == "":
+			parts[i] = ":id"
+		// Interaction and webhook tokens follow the ID
+		case i >= 2 && (parts[i-2] == "interactions" || parts[i-2] == "webhooks"):
+			parts[i] = ":token"
+		case i >= 1 && parts[i-1] == "reactions":
+			parts[i] = ":emoji"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Position of the highest of the roles in the guild's role list, 0 for
+// members with only @everyone
+func HighestRolePosition(guild *discordgo.Guild, roleIDs []string) int {
+	highest := 0
+	for _, role := range guild.Roles {
+		if slices.Contains(roleIDs, role.ID) && role.Position > highest {
+			highest = role.Position
+		}
+	}
+	return highest
+}
+
+// Looks the guild up in the state cache, falling back to the API. Returns nil
+// if the guild can't be found.
+func (d *Discord) CachedGuild(guildID string) *discordgo.Guild {
+	guild, err := d.State.Guild(guildID)
+	if err == nil {
+		return guild
+	}
+
+	guild, err = d.GuildWithCounts(guildID)
+	if err != nil {
+		Log.Warn("Could not look up guild", "guild", guildID, "error", err)
+		return nil
+	}
+	guild.MemberCount = guild.ApproximateMemberCount
+	return guild
+}
+
+// Acknowledges the interaction with an ephemeral "thinking" state, the
+// actual response is sent with FollowupEphemeral
+func (d *Discord) DeferEphemeral(interaction *discordgo.Interaction) error {
+	err := d.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (d *Discord) FollowupEphemeral(interaction *discordgo.Interaction, content string, embeds ...*discordgo.MessageEmbed) error {
+	_, err := d.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
+		Content: content,
+		Embeds:  embeds,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Sends a message to a user's DMs
+func (d *Discord) SendDM(userID string, message *discordgo.MessageSend) error {
+	dmChannel, err := d.UserChannelCreate(userID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	_, err = d.ChannelMessageSendComplex(dmChannel.ID, message)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Downloads attachments so they can be uploaded somewhere else, CDN links of
+// attachments expire. Attachments that can't be downloaded are skipped.
+func DownloadAttachments(attachments []*discordgo.MessageAttachment) []*discordgo.File {
+	files := []*discordgo.File{}
+	for _, attachment := range attachments {
+		if attachment.Size > maxAttachmentSize {
+			Log.Warn("Skipping attachment, it is too big", "file", attachment.Filename)
+			continue
+		}
+
+		response, err := httpClient.Get(attachment.URL)
+		if err != nil {
+			Log.Warn("Could not download attachment", "file", attachment.Filename, "error", err)
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(response.Body, maxAttachmentSize))
+		response.Body.Close()
+		if err != nil || response.StatusCode != http.StatusOK {
+			Log.Warn("Could not download attachment", "file", attachment.Filename, "status", response.Status, "error", err)
+			continue
+		}
+
+		files = append(files, &discordgo.File{
+			Name:        attachment.Filename,
+			ContentType: attachment.ContentType,
+			Reader:      bytes.NewReader(content),
+		})
+	}
+	return files
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"fbot/fakediscord"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Bot with the verification and welcome modules, both need the members intent
+func newIntentsTestBot(t *testing.T, server *fakediscord.Server) *Bot {
+	guild := server.AddGuild(&discordgo.Guild{Name: "Test server"})
+	channel := server.AddChannel(guild.ID, "welcome")
+	config := &Config{
+		DiscordToken:       "token",
+		DiscordEndpoint:    server.URL,
+		VerificationSystem: testVerificationConfig(t, server.NewID(), channel.ID, server.NewID(), channel.ID, channel.ID),
+		Welcome:            &WelcomeConfig{},
+	}
+	err := config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot, err := NewBot(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot
+}
+
+func TestOpenWithIntents(t *testing.T) {
+	server := fakediscord.NewServer()
+	t.Cleanup(server.Close)
+	bot := newIntentsTestBot(t, server)
+
+	err := bot.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bot.Stop)
+
+	want := baseIntents
+	for _, entry := range registeredModules {
+		if entry.Name == "verification" || entry.Name == "welcome" {
+			want |= entry.Intents
+		}
+	}
+	if intents := server.Intents(); intents != want {
+		t.Fatalf("identified with intents %b, want %b", intents, want)
+	}
+}
+
+func TestOpenWithDisallowedIntents(t *testing.T) {
+	server := fakediscord.NewServer()
+	server.DisallowedIntents = discordgo.IntentsGuildMembers
+	t.Cleanup(server.Close)
+	bot := newIntentsTestBot(t, server)
+
+	err := bot.Start()
+	if err == nil {
+		bot.Stop()
+		t.Fatal("connected with a disallowed intent")
+	}
+	message := ErrorToStr(err)
+	if !strings.Contains(message, "Server Members Intent (needed by verification, welcome)") {
+		t.Fatalf("error doesn't name the intent and its modules: %v", message)
+	}
+}
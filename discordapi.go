@@ -0,0 +1,73 @@
+// Discord API abstraction
+
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// The part of the Discord API modules use. Discord implements it with
+// discordgo, RecordingDiscord in memory for tests. Signatures match
+// discordgo.Session so Discord gets most methods through embedding.
+type DiscordAPI interface {
+	// The bot's own user ID
+	BotUserID() string
+
+	// Guilds
+	CachedGuild(guildID string) *discordgo.Guild
+	GuildWithCounts(guildID string, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	GuildEdit(guildID string, params *discordgo.GuildParams, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+
+	// Members and roles
+	GuildMember(guildID string, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	GuildMemberRoleAdd(guildID string, userID string, roleID string, options ...discordgo.RequestOption) error
+	GuildMemberRoleRemove(guildID string, userID string, roleID string, options ...discordgo.RequestOption) error
+	GuildMemberTimeout(guildID string, userID string, until *time.Time, options ...discordgo.RequestOption) error
+	GuildMemberDeleteWithReason(guildID string, userID string, reason string, options ...discordgo.RequestOption) error
+	UserChannelPermissions(userID string, channelID string, options ...discordgo.RequestOption) (int64, error)
+
+	// Bans
+	GuildBanCreateWithReason(guildID string, userID string, reason string, days int, options ...discordgo.RequestOption) error
+	GuildBanDelete(guildID string, userID string, options ...discordgo.RequestOption) error
+
+	// Channels and threads
+	GuildChannelCreateComplex(guildID string, data discordgo.GuildChannelCreateData, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelEdit(channelID string, data *discordgo.ChannelEdit, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelDelete(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelPermissionSet(channelID string, targetID string, targetType discordgo.PermissionOverwriteType, allow int64, deny int64, options ...discordgo.RequestOption) error
+	ChannelPermissionDelete(channelID string, targetID string, options ...discordgo.RequestOption) error
+	ThreadStartComplex(channelID string, data *discordgo.ThreadStart, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ForumThreadStartComplex(channelID string, threadData *discordgo.ThreadStart, messageData *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ThreadMemberAdd(threadID string, memberID string, options ...discordgo.RequestOption) error
+	ThreadMemberRemove(threadID string, memberID string, options ...discordgo.RequestOption) error
+
+	// Messages
+	ChannelMessage(channelID string, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID string, afterID string, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEditComplex(edit *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEditEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID string, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessagesBulkDelete(channelID string, messages []string, options ...discordgo.RequestOption) error
+	MessageReactionAdd(channelID string, messageID string, emojiID string, options ...discordgo.RequestOption) error
+	// Sent in the background, see Discord.Announce
+	Announce(channelID string, message *discordgo.MessageSend)
+
+	// DMs
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	SendDM(userID string, message *discordgo.MessageSend) error
+
+	// Interactions
+	InteractionRespond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	DeferEphemeral(interaction *discordgo.Interaction) error
+	FollowupEphemeral(interaction *discordgo.Interaction, content string, embeds ...*discordgo.MessageEmbed) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageEdit(interaction *discordgo.Interaction, messageID string, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+var _ DiscordAPI = (*Discord)(nil)
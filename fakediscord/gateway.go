@@ -0,0 +1,180 @@
+// Websocket gateway
+
+package fakediscord
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+type gatewayPayload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+type gatewayConnection struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+}
+
+func (c *gatewayConnection) send(payload *gatewayPayload) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn.WriteJSON(payload)
+}
+
+func (c *gatewayConnection) close() {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.conn.Close()
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (s *Server) gatewayURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/gateway"
+}
+
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	connection := &gatewayConnection{conn: conn}
+
+	hello, _ := json.Marshal(map[string]any{"heartbeat_interval": 45000})
+	err = connection.send(&gatewayPayload{Op: 10, Data: hello})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	for {
+		var payload gatewayPayload
+		err := conn.ReadJSON(&payload)
+		if err != nil {
+			s.removeConnection(connection)
+			conn.Close()
+			return
+		}
+
+		switch payload.Op {
+		// Heartbeat
+		case 1:
+			connection.send(&gatewayPayload{Op: 11, Data: json.RawMessage("null")})
+		// Identify
+		case 2:
+			var identify struct {
+				Intents discordgo.Intent `json:"intents"`
+			}
+			json.Unmarshal(payload.Data, &identify)
+			s.identify(connection, identify.Intents)
+		}
+	}
+}
+
+func (s *Server) removeConnection(connection *gatewayConnection) {
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+
+	for i, c := range s.connections {
+		if c == connection {
+			s.connections = append(s.connections[:i], s.connections[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sends READY and a GUILD_CREATE for every guild. Events are only dispatched
+// to connections which identified.
+func (s *Server) identify(connection *gatewayConnection, intents discordgo.Intent) {
+	s.gatewayLock.Lock()
+	s.intents = intents
+	s.gatewayLock.Unlock()
+
+	if intents&s.DisallowedIntents != 0 {
+		connection.writeLock.Lock()
+		connection.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4014, "Disallowed intent(s)."))
+		connection.writeLock.Unlock()
+		connection.conn.Close()
+		return
+	}
+
+	s.lock.Lock()
+	guilds := []*discordgo.Guild{}
+	for _, guild := range s.guilds {
+		guilds = append(guilds, guild)
+	}
+	ready := map[string]any{
+		"v":           10,
+		"session_id":  s.NewID(),
+		"user":        s.BotUser,
+		"application": map[string]any{"id": s.BotUser.ID},
+		"guilds":      []any{},
+	}
+	readyData, _ := json.Marshal(ready)
+	guildData := [][]byte{}
+	for _, guild := range guilds {
+		data, _ := json.Marshal(guild)
+		guildData = append(guildData, data)
+	}
+	s.lock.Unlock()
+
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+
+	s.sequence++
+	sequence := s.sequence
+	connection.send(&gatewayPayload{Op: 0, Type: "READY", Data: readyData, Sequence: &sequence})
+	for _, data := range guildData {
+		s.sequence++
+		sequence := s.sequence
+		connection.send(&gatewayPayload{Op: 0, Type: "GUILD_CREATE", Data: data, Sequence: &sequence})
+	}
+
+	s.connections = append(s.connections, connection)
+}
+
+// Sends an event to every connected bot, e.g. Dispatch("GUILD_MEMBER_ADD", member)
+func (s *Server) Dispatch(eventType string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+
+	s.sequence++
+	sequence := s.sequence
+	for _, connection := range s.connections {
+		connection.send(&gatewayPayload{Op: 0, Type: eventType, Data: encoded, Sequence: &sequence})
+	}
+}
+
+// Intents of the last identify
+func (s *Server) Intents() discordgo.Intent {
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+	return s.intents
+}
+
+// Waits until a bot has identified, events dispatched before are lost
+func (s *Server) WaitConnected(timeout time.Duration) error {
+	return s.WaitFor(timeout, func() bool {
+		s.gatewayLock.Lock()
+		defer s.gatewayLock.Unlock()
+		return len(s.connections) > 0
+	})
+}
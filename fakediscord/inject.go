@@ -0,0 +1,166 @@
+// Injecting events as if users did something
+
+package fakediscord
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// A user joins a guild
+func (s *Server) InjectMemberAdd(guildID string, user *discordgo.User) *discordgo.Member {
+	s.lock.Lock()
+	member := &discordgo.Member{
+		GuildID:  guildID,
+		User:     user,
+		JoinedAt: time.Now(),
+		Roles:    []string{},
+	}
+	if guild := s.guilds[guildID]; guild != nil {
+		guild.Members = append(guild.Members, member)
+		guild.MemberCount = len(guild.Members)
+	}
+	s.lock.Unlock()
+
+	s.Dispatch("GUILD_MEMBER_ADD", member)
+	return member
+}
+
+// Permissions of the member in interactions, there's no role hierarchy
+func (s *Server) SetPermissions(guildID string, userID string, permissions int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if member := s.member(guildID, userID); member != nil {
+		member.Permissions = permissions
+	}
+}
+
+// A user posts a message. Use an empty guild ID for DMs.
+func (s *Server) InjectMessage(guildID string, channelID string, author *discordgo.User, content string) *discordgo.Message {
+	s.lock.Lock()
+	message := &discordgo.Message{
+		ID:        s.NewID(),
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Content:   content,
+		Author:    author,
+		Timestamp: time.Now(),
+	}
+	if member := s.member(guildID, author.ID); member != nil {
+		copied := *member
+		copied.User = nil
+		message.Member = &copied
+	}
+	s.messages[channelID] = append(s.messages[channelID], message)
+	s.lock.Unlock()
+
+	s.Dispatch("MESSAGE_CREATE", message)
+	return message
+}
+
+// Dispatches an interaction, filling in the ID, token, application and the
+// member if the user is in the guild, with the permissions given to
+// SetPermissions. Responses show up in InteractionResponses and Followups
+// under the interaction's ID.
+func (s *Server) InjectInteraction(interaction *discordgo.Interaction, user *discordgo.User) *discordgo.Interaction {
+	s.lock.Lock()
+	interaction.ID = s.NewID()
+	interaction.Token = "token-" + interaction.ID
+	interaction.AppID = s.BotUser.ID
+	interaction.Version = 1
+	if interaction.Locale == "" {
+		interaction.Locale = discordgo.EnglishUS
+	}
+	// Followups are possible before the interaction is responded to
+	s.interactionTokens[interaction.Token] = interaction.ID
+
+	if interaction.GuildID == "" {
+		interaction.User = user
+	} else if member := s.member(interaction.GuildID, user.ID); member != nil {
+		copied := *member
+		interaction.Member = &copied
+	} else {
+		interaction.Member = &discordgo.Member{GuildID: interaction.GuildID, User: user}
+	}
+	s.lock.Unlock()
+
+	s.Dispatch("INTERACTION_CREATE", interactionPayload(interaction))
+	return interaction
+}
+
+// discordgo doesn't encode the components of modal submits
+func interactionPayload(interaction *discordgo.Interaction) any {
+	data, ok := interaction.Data.(discordgo.ModalSubmitInteractionData)
+	if !ok {
+		return interaction
+	}
+
+	var payload map[string]json.RawMessage
+	encoded, _ := json.Marshal(interaction)
+	json.Unmarshal(encoded, &payload)
+	payload["data"], _ = json.Marshal(map[string]any{
+		"custom_id":  data.CustomID,
+		"components": data.Components,
+	})
+	return payload
+}
+
+// A user clicks a button or picks from a select menu on a message
+func (s *Server) ClickComponent(message *discordgo.Message, user *discordgo.User, customID string, values ...string) *discordgo.Interaction {
+	componentType := discordgo.ButtonComponent
+	if len(values) > 0 {
+		componentType = discordgo.SelectMenuComponent
+	}
+	return s.InjectInteraction(&discordgo.Interaction{
+		Type:      discordgo.InteractionMessageComponent,
+		GuildID:   message.GuildID,
+		ChannelID: message.ChannelID,
+		Message:   message,
+		Data: discordgo.MessageComponentInteractionData{
+			CustomID:      customID,
+			ComponentType: componentType,
+			Values:        values,
+		},
+	}, user)
+}
+
+// A user submits a modal the bot opened in response to the source
+// interaction, values are keyed by the custom IDs of the text inputs
+func (s *Server) SubmitModal(source *discordgo.Interaction, user *discordgo.User, customID string, values map[string]string) *discordgo.Interaction {
+	components := []discordgo.MessageComponent{}
+	for id, value := range values {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{CustomID: id, Value: value},
+			},
+		})
+	}
+	return s.InjectInteraction(&discordgo.Interaction{
+		Type:      discordgo.InteractionModalSubmit,
+		GuildID:   source.GuildID,
+		ChannelID: source.ChannelID,
+		Message:   source.Message,
+		Data: discordgo.ModalSubmitInteractionData{
+			CustomID:   customID,
+			Components: components,
+		},
+	}, user)
+}
+
+// A user runs a slash command
+func (s *Server) RunCommand(guildID string, channelID string, user *discordgo.User, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return s.InjectInteraction(&discordgo.Interaction{
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Data: discordgo.ApplicationCommandInteractionData{
+			ID:          s.NewID(),
+			Name:        name,
+			CommandType: discordgo.ChatApplicationCommand,
+			Options:     options,
+		},
+	}, user)
+}
@@ -0,0 +1,793 @@
+// REST endpoints
+
+package fakediscord
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var apiPrefix = "/api/v" + discordgo.APIVersion
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	handle := func(pattern string, handler http.HandlerFunc) {
+		method, path, _ := strings.Cut(pattern, " ")
+		mux.HandleFunc(method+" "+apiPrefix+path, handler)
+	}
+
+	// discordgo appends a slash to the gateway URL
+	mux.HandleFunc("GET /gateway", s.serveGateway)
+	mux.HandleFunc("GET /gateway/{$}", s.serveGateway)
+	handle("GET /gateway", s.getGateway)
+	handle("GET /gateway/bot", s.getGateway)
+
+	handle("GET /users/@me", s.getCurrentUser)
+	handle("POST /users/@me/channels", s.createDM)
+
+	handle("GET /guilds/{guild}", s.getGuild)
+	handle("PATCH /guilds/{guild}", s.editGuild)
+	handle("GET /guilds/{guild}/channels", s.getGuildChannels)
+	handle("POST /guilds/{guild}/channels", s.createGuildChannel)
+	handle("GET /guilds/{guild}/members/{user}", s.getMember)
+	handle("PATCH /guilds/{guild}/members/{user}", s.editMember)
+	handle("DELETE /guilds/{guild}/members/{user}", s.kickMember)
+	handle("PUT /guilds/{guild}/members/{user}/roles/{role}", s.addMemberRole)
+	handle("DELETE /guilds/{guild}/members/{user}/roles/{role}", s.removeMemberRole)
+	handle("PUT /guilds/{guild}/bans/{user}", s.createBan)
+	handle("DELETE /guilds/{guild}/bans/{user}", s.removeBan)
+
+	handle("GET /channels/{channel}", s.getChannel)
+	handle("PATCH /channels/{channel}", s.editChannel)
+	handle("DELETE /channels/{channel}", s.deleteChannel)
+	handle("PUT /channels/{channel}/permissions/{target}", s.noContent)
+	handle("DELETE /channels/{channel}/permissions/{target}", s.noContent)
+	handle("GET /channels/{channel}/messages", s.getMessages)
+	handle("POST /channels/{channel}/messages", s.createMessage)
+	handle("POST /channels/{channel}/messages/bulk-delete", s.bulkDeleteMessages)
+	handle("GET /channels/{channel}/messages/{message}", s.getMessage)
+	handle("PATCH /channels/{channel}/messages/{message}", s.editMessage)
+	handle("DELETE /channels/{channel}/messages/{message}", s.deleteMessage)
+	handle("PUT /channels/{channel}/messages/{message}/reactions/{emoji}/@me", s.noContent)
+	handle("POST /channels/{channel}/threads", s.createThread)
+	handle("POST /channels/{channel}/messages/{message}/threads", s.createThread)
+	handle("PUT /channels/{channel}/thread-members/{user}", s.noContent)
+	handle("DELETE /channels/{channel}/thread-members/{user}", s.noContent)
+
+	handle("POST /interactions/{interaction}/{token}/callback", s.interactionCallback)
+	handle("POST /webhooks/{application}/{token}", s.createFollowup)
+	handle("PATCH /webhooks/{application}/{token}/messages/{message}", s.editFollowup)
+
+	handle("PUT /applications/{application}/commands", s.overwriteCommands)
+	handle("PUT /applications/{application}/guilds/{guild}/commands", s.overwriteCommands)
+
+	return s.recordRequests(mux)
+}
+
+func (s *Server) recordRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		if strings.HasPrefix(r.URL.Path, apiPrefix) {
+			s.lock.Lock()
+			s.requests = append(s.requests, Request{
+				Method: r.Method,
+				Path:   strings.TrimPrefix(r.URL.Path, apiPrefix),
+				Body:   body,
+			})
+			s.lock.Unlock()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Error in the format of the Discord API so that discordgo reports it as a
+// RESTError
+func writeError(w http.ResponseWriter, status int, code int, message string) {
+	writeJSON(w, status, map[string]any{"code": code, "message": message})
+}
+
+func (s *Server) noContent(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reads a JSON body, or the payload_json part of a multipart body with
+// attachments. Message components are interfaces which encoding/json can't
+// decode, so they're split off and returned separately along with the names
+// of attached files.
+func readBody(r *http.Request, v any) ([]discordgo.MessageComponent, []string, error) {
+	payload, files, err := readPayload(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) != nil {
+		// Not an object, e.g. the list of commands
+		return nil, files, json.Unmarshal(payload, v)
+	}
+
+	var components []discordgo.MessageComponent
+	if raw, ok := fields["components"]; ok {
+		components = unmarshalComponents(raw)
+		delete(fields, "components")
+	}
+	// Forum posts carry their message nested
+	if raw, ok := fields["message"]; ok {
+		var message map[string]json.RawMessage
+		if json.Unmarshal(raw, &message) == nil {
+			if raw, ok := message["components"]; ok {
+				components = unmarshalComponents(raw)
+				delete(message, "components")
+				fields["message"], _ = json.Marshal(message)
+			}
+		}
+	}
+	// Interaction responses carry it in data
+	if raw, ok := fields["data"]; ok {
+		var data map[string]json.RawMessage
+		if json.Unmarshal(raw, &data) == nil {
+			if raw, ok := data["components"]; ok {
+				components = unmarshalComponents(raw)
+				delete(data, "components")
+				fields["data"], _ = json.Marshal(data)
+			}
+		}
+	}
+
+	payload, _ = json.Marshal(fields)
+	return components, files, json.Unmarshal(payload, v)
+}
+
+func readPayload(r *http.Request) ([]byte, []string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		payload, err := io.ReadAll(r.Body)
+		return payload, nil, err
+	}
+
+	var payload []byte
+	files := []string{}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return payload, files, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FormName() == "payload_json" {
+			payload, err = io.ReadAll(part)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else if part.FileName() != "" {
+			files = append(files, part.FileName())
+		}
+	}
+}
+
+// Components are interfaces, discordgo can only decode them as part of a
+// message
+func unmarshalComponents(data json.RawMessage) []discordgo.MessageComponent {
+	var message discordgo.Message
+	wrapped, _ := json.Marshal(map[string]json.RawMessage{"components": data})
+	json.Unmarshal(wrapped, &message)
+	return message.Components
+}
+
+func (s *Server) getGateway(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"url": s.gatewayURL(), "shards": 1})
+}
+
+func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.BotUser)
+}
+
+func (s *Server) createDM(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RecipientID string `json:"recipient_id"`
+	}
+	_, _, err := readBody(r, &body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channelID, ok := s.dmChannels[body.RecipientID]
+	if !ok {
+		channelID = s.NewID()
+		s.dmChannels[body.RecipientID] = channelID
+		s.channels[channelID] = &discordgo.Channel{
+			ID:         channelID,
+			Type:       discordgo.ChannelTypeDM,
+			Recipients: []*discordgo.User{{ID: body.RecipientID}},
+		}
+	}
+	writeJSON(w, http.StatusOK, s.channels[channelID])
+}
+
+func (s *Server) getGuild(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	guild := s.guilds[r.PathValue("guild")]
+	if guild == nil {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	copied := *guild
+	copied.ApproximateMemberCount = len(guild.Members)
+	writeJSON(w, http.StatusOK, &copied)
+}
+
+func (s *Server) editGuild(w http.ResponseWriter, r *http.Request) {
+	var params discordgo.GuildParams
+	_, _, err := readBody(r, &params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	guild := s.guilds[r.PathValue("guild")]
+	if guild == nil {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	if params.Name != "" {
+		guild.Name = params.Name
+	}
+	if params.VerificationLevel != nil {
+		guild.VerificationLevel = *params.VerificationLevel
+	}
+	writeJSON(w, http.StatusOK, guild)
+}
+
+func (s *Server) getGuildChannels(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	guild := s.guilds[r.PathValue("guild")]
+	if guild == nil {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	writeJSON(w, http.StatusOK, guild.Channels)
+}
+
+func (s *Server) createGuildChannel(w http.ResponseWriter, r *http.Request) {
+	var data discordgo.GuildChannelCreateData
+	_, _, err := readBody(r, &data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	channel := s.AddChannel(r.PathValue("guild"), data.Name)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channel.Type = data.Type
+	channel.Topic = data.Topic
+	channel.ParentID = data.ParentID
+	channel.PermissionOverwrites = data.PermissionOverwrites
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (s *Server) getMember(w http.ResponseWriter, r *http.Request) {
+	member := s.Member(r.PathValue("guild"), r.PathValue("user"))
+	if member == nil {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	writeJSON(w, http.StatusOK, member)
+}
+
+func (s *Server) editMember(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Nick                       *string    `json:"nick"`
+		Roles                      *[]string  `json:"roles"`
+		CommunicationDisabledUntil *time.Time `json:"communication_disabled_until"`
+	}
+	_, _, err := readBody(r, &params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	member := s.member(r.PathValue("guild"), r.PathValue("user"))
+	if member == nil {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	if params.Nick != nil {
+		member.Nick = *params.Nick
+	}
+	if params.Roles != nil {
+		member.Roles = *params.Roles
+	}
+	member.CommunicationDisabledUntil = params.CommunicationDisabledUntil
+	writeJSON(w, http.StatusOK, member)
+}
+
+// Removes the member from the guild, returns false if they weren't in it
+func (s *Server) removeMember(guildID string, userID string) bool {
+	guild := s.guilds[guildID]
+	if guild == nil {
+		return false
+	}
+	for i, member := range guild.Members {
+		if member.User.ID == userID {
+			guild.Members = append(guild.Members[:i], guild.Members[i+1:]...)
+			guild.MemberCount = len(guild.Members)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) kickMember(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.removeMember(r.PathValue("guild"), r.PathValue("user")) {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	s.kicks = append(s.kicks, r.PathValue("user"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) changeMemberRole(w http.ResponseWriter, r *http.Request, added bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	guildID := r.PathValue("guild")
+	roleID := r.PathValue("role")
+	member := s.member(guildID, r.PathValue("user"))
+	if member == nil {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+
+	roles := []string{}
+	for _, id := range member.Roles {
+		if id != roleID {
+			roles = append(roles, id)
+		}
+	}
+	if added {
+		roles = append(roles, roleID)
+	}
+	member.Roles = roles
+
+	s.roleChanges = append(s.roleChanges, RoleChange{
+		GuildID: guildID,
+		UserID:  member.User.ID,
+		RoleID:  roleID,
+		Added:   added,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addMemberRole(w http.ResponseWriter, r *http.Request) {
+	s.changeMemberRole(w, r, true)
+}
+
+func (s *Server) removeMemberRole(w http.ResponseWriter, r *http.Request) {
+	s.changeMemberRole(w, r, false)
+}
+
+func (s *Server) createBan(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	guildID := r.PathValue("guild")
+	userID := r.PathValue("user")
+	reason := r.Header.Get("X-Audit-Log-Reason")
+	if reason == "" {
+		reason = r.URL.Query().Get("reason")
+	}
+
+	s.removeMember(guildID, userID)
+	s.bans = append(s.bans, Ban{GuildID: guildID, UserID: userID, Reason: reason})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeBan(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, ban := range s.bans {
+		if ban.GuildID == r.PathValue("guild") && ban.UserID == r.PathValue("user") {
+			s.bans = append(s.bans[:i], s.bans[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, 10026, "Unknown Ban")
+}
+
+func (s *Server) getChannel(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channel := s.channels[r.PathValue("channel")]
+	if channel == nil {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+		return
+	}
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (s *Server) editChannel(w http.ResponseWriter, r *http.Request) {
+	var edit discordgo.ChannelEdit
+	_, _, err := readBody(r, &edit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channel := s.channels[r.PathValue("channel")]
+	if channel == nil {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+		return
+	}
+	if edit.Name != "" {
+		channel.Name = edit.Name
+	}
+	if edit.Topic != "" {
+		channel.Topic = edit.Topic
+	}
+	if edit.Archived != nil && channel.ThreadMetadata != nil {
+		channel.ThreadMetadata.Archived = *edit.Archived
+	}
+	if edit.Locked != nil && channel.ThreadMetadata != nil {
+		channel.ThreadMetadata.Locked = *edit.Locked
+	}
+	if edit.PermissionOverwrites != nil {
+		channel.PermissionOverwrites = edit.PermissionOverwrites
+	}
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (s *Server) deleteChannel(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channel := s.channels[r.PathValue("channel")]
+	if channel == nil {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+		return
+	}
+	delete(s.channels, channel.ID)
+	if guild := s.guilds[channel.GuildID]; guild != nil {
+		for i, c := range guild.Channels {
+			if c.ID == channel.ID {
+				guild.Channels = append(guild.Channels[:i], guild.Channels[i+1:]...)
+				break
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
+	messages := s.Messages(r.PathValue("channel"))
+
+	// Newest first like the API
+	reversed := make([]*discordgo.Message, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		reversed = append(reversed, messages[i])
+	}
+	writeJSON(w, http.StatusOK, reversed)
+}
+
+// Stores a message sent by the bot and dispatches MESSAGE_CREATE for it
+func (s *Server) storeMessage(channelID string, send *discordgo.MessageSend, components []discordgo.MessageComponent, files []string) *discordgo.Message {
+	s.lock.Lock()
+	message := &discordgo.Message{
+		ID:         s.NewID(),
+		ChannelID:  channelID,
+		Content:    send.Content,
+		Embeds:     send.Embeds,
+		Components: components,
+		Author:     s.BotUser,
+		Timestamp:  time.Now(),
+	}
+	if channel := s.channels[channelID]; channel != nil {
+		message.GuildID = channel.GuildID
+	}
+	for _, name := range files {
+		message.Attachments = append(message.Attachments, &discordgo.MessageAttachment{
+			ID:       s.NewID(),
+			Filename: name,
+		})
+	}
+	s.messages[channelID] = append(s.messages[channelID], message)
+	s.lock.Unlock()
+
+	s.Dispatch("MESSAGE_CREATE", message)
+	return message
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	var send discordgo.MessageSend
+	components, files, err := readBody(r, &send)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	_, ok := s.channels[r.PathValue("channel")]
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+		return
+	}
+
+	message := s.storeMessage(r.PathValue("channel"), &send, components, files)
+	writeJSON(w, http.StatusOK, message)
+}
+
+func (s *Server) findMessage(channelID string, messageID string) (*discordgo.Message, int) {
+	for i, message := range s.messages[channelID] {
+		if message.ID == messageID {
+			return message, i
+		}
+	}
+	return nil, -1
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	message, _ := s.findMessage(r.PathValue("channel"), r.PathValue("message"))
+	if message == nil {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+func (s *Server) editMessage(w http.ResponseWriter, r *http.Request) {
+	var edit struct {
+		Content *string                    `json:"content"`
+		Embeds  *[]*discordgo.MessageEmbed `json:"embeds"`
+	}
+	components, _, err := readBody(r, &edit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channelID := r.PathValue("channel")
+	found, i := s.findMessage(channelID, r.PathValue("message"))
+	if found == nil {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	// Edited as a copy, tests may still be looking at the old message
+	copied := *found
+	message := &copied
+	s.messages[channelID][i] = message
+	if edit.Content != nil {
+		message.Content = *edit.Content
+	}
+	if edit.Embeds != nil {
+		message.Embeds = *edit.Embeds
+	}
+	if components != nil {
+		message.Components = components
+	}
+	now := time.Now()
+	message.EditedTimestamp = &now
+	writeJSON(w, http.StatusOK, message)
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channelID := r.PathValue("channel")
+	message, i := s.findMessage(channelID, r.PathValue("message"))
+	if message == nil {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	s.messages[channelID] = append(s.messages[channelID][:i], s.messages[channelID][i+1:]...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) bulkDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Messages []string `json:"messages"`
+	}
+	_, _, err := readBody(r, &body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channelID := r.PathValue("channel")
+	for _, messageID := range body.Messages {
+		_, i := s.findMessage(channelID, messageID)
+		if i >= 0 {
+			s.messages[channelID] = append(s.messages[channelID][:i], s.messages[channelID][i+1:]...)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Threads and forum posts. Forum posts carry their first message.
+func (s *Server) createThread(w http.ResponseWriter, r *http.Request) {
+	var start struct {
+		discordgo.ThreadStart
+		Message *discordgo.MessageSend `json:"message"`
+	}
+	components, files, err := readBody(r, &start)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	parent := s.channels[r.PathValue("channel")]
+	s.lock.Unlock()
+	if parent == nil {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+		return
+	}
+
+	thread := s.AddChannel(parent.GuildID, start.Name)
+
+	s.lock.Lock()
+	thread.ParentID = parent.ID
+	thread.Type = start.Type
+	if thread.Type == 0 {
+		thread.Type = discordgo.ChannelTypeGuildPublicThread
+	}
+	thread.ThreadMetadata = &discordgo.ThreadMetadata{AutoArchiveDuration: start.AutoArchiveDuration}
+	s.lock.Unlock()
+
+	if start.Message != nil {
+		s.storeMessage(thread.ID, start.Message, components, files)
+	}
+	writeJSON(w, http.StatusCreated, thread)
+}
+
+func (s *Server) interactionCallback(w http.ResponseWriter, r *http.Request) {
+	var response discordgo.InteractionResponse
+	components, _, err := readBody(r, &response)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+	if response.Data != nil {
+		response.Data.Components = components
+	}
+
+	s.lock.Lock()
+	s.interactionResponses = append(s.interactionResponses, InteractionResponse{
+		InteractionID: r.PathValue("interaction"),
+		Response:      &response,
+	})
+	s.interactionTokens[r.PathValue("token")] = r.PathValue("interaction")
+	s.lock.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createFollowup(w http.ResponseWriter, r *http.Request) {
+	var params discordgo.WebhookParams
+	components, _, err := readBody(r, &params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	interactionID, ok := s.interactionTokens[r.PathValue("token")]
+	if !ok {
+		writeError(w, http.StatusNotFound, 10015, "Unknown Webhook")
+		return
+	}
+	message := &discordgo.Message{
+		ID:         s.NewID(),
+		Content:    params.Content,
+		Embeds:     params.Embeds,
+		Components: components,
+		Flags:      params.Flags,
+		Author:     s.BotUser,
+		Timestamp:  time.Now(),
+	}
+	s.followups[interactionID] = append(s.followups[interactionID], message)
+	writeJSON(w, http.StatusOK, message)
+}
+
+func (s *Server) editFollowup(w http.ResponseWriter, r *http.Request) {
+	var edit discordgo.WebhookEdit
+	_, _, err := readBody(r, &edit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	interactionID := s.interactionTokens[r.PathValue("token")]
+	for _, message := range s.followups[interactionID] {
+		if message.ID == r.PathValue("message") {
+			if edit.Content != nil {
+				message.Content = *edit.Content
+			}
+			if edit.Embeds != nil {
+				message.Embeds = *edit.Embeds
+			}
+			writeJSON(w, http.StatusOK, message)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+}
+
+func (s *Server) overwriteCommands(w http.ResponseWriter, r *http.Request) {
+	var commands []*discordgo.ApplicationCommand
+	_, _, err := readBody(r, &commands)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, command := range commands {
+		command.ID = s.NewID()
+		command.ApplicationID = r.PathValue("application")
+	}
+	s.commands = commands
+	writeJSON(w, http.StatusOK, commands)
+}
@@ -0,0 +1,274 @@
+// Package fakediscord is an in-process stand-in for the Discord REST API and
+// gateway. The bot connects to it like to the real thing, it records what the
+// bot does and lets events like joins, messages and interactions be injected.
+package fakediscord
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type RoleChange struct {
+	GuildID string
+	UserID  string
+	RoleID  string
+	// False if the role was removed
+	Added bool
+}
+
+type Ban struct {
+	GuildID string
+	UserID  string
+	Reason  string
+}
+
+type InteractionResponse struct {
+	InteractionID string
+	Response      *discordgo.InteractionResponse
+}
+
+// Every request the bot made, in order
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+type Server struct {
+	// Base URL to point the bot at, e.g. http://127.0.0.1:1234
+	URL string
+	// The bot's own user
+	BotUser *discordgo.User
+	// Identifying with any of these closes the connection with code 4014,
+	// like privileged intents that aren't enabled in the developer portal
+	DisallowedIntents discordgo.Intent
+
+	httpServer *httptest.Server
+	nextID     atomic.Int64
+
+	lock                 sync.Mutex
+	guilds               map[string]*discordgo.Guild
+	channels             map[string]*discordgo.Channel
+	messages             map[string][]*discordgo.Message
+	dmChannels           map[string]string
+	requests             []Request
+	roleChanges          []RoleChange
+	bans                 []Ban
+	kicks                []string
+	interactionResponses []InteractionResponse
+	// Interaction token to interaction ID, to tie followups to interactions
+	interactionTokens map[string]string
+	followups         map[string][]*discordgo.Message
+	commands          []*discordgo.ApplicationCommand
+
+	gatewayLock sync.Mutex
+	connections []*gatewayConnection
+	sequence    int64
+	intents     discordgo.Intent
+}
+
+func NewServer() *Server {
+	s := &Server{
+		guilds:            map[string]*discordgo.Guild{},
+		channels:          map[string]*discordgo.Channel{},
+		messages:          map[string][]*discordgo.Message{},
+		dmChannels:        map[string]string{},
+		interactionTokens: map[string]string{},
+		followups:         map[string][]*discordgo.Message{},
+	}
+	s.nextID.Store(1100000000000000000)
+
+	s.BotUser = &discordgo.User{
+		ID:            s.NewID(),
+		Username:      "fbot",
+		Discriminator: "0",
+		Bot:           true,
+	}
+
+	s.httpServer = httptest.NewServer(s.routes())
+	s.URL = s.httpServer.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.gatewayLock.Lock()
+	for _, connection := range s.connections {
+		connection.close()
+	}
+	s.connections = nil
+	s.gatewayLock.Unlock()
+
+	s.httpServer.Close()
+}
+
+// Snowflake-like ID, unique within the server
+func (s *Server) NewID() string {
+	return strconv.FormatInt(s.nextID.Add(1), 10)
+}
+
+// Adds a guild the bot is in. Channels, roles and members of the guild are
+// added too and sent to the bot when it connects.
+func (s *Server) AddGuild(guild *discordgo.Guild) *discordgo.Guild {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if guild.ID == "" {
+		guild.ID = s.NewID()
+	}
+	if guild.PreferredLocale == "" {
+		guild.PreferredLocale = string(discordgo.EnglishUS)
+	}
+	for _, channel := range guild.Channels {
+		if channel.ID == "" {
+			channel.ID = s.NewID()
+		}
+		channel.GuildID = guild.ID
+		s.channels[channel.ID] = channel
+	}
+	for _, member := range guild.Members {
+		member.GuildID = guild.ID
+	}
+
+	botMember := &discordgo.Member{GuildID: guild.ID, User: s.BotUser, JoinedAt: time.Now()}
+	guild.Members = append(guild.Members, botMember)
+	guild.MemberCount = len(guild.Members)
+
+	s.guilds[guild.ID] = guild
+	return guild
+}
+
+// Adds a text channel to a guild
+func (s *Server) AddChannel(guildID string, name string) *discordgo.Channel {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	channel := &discordgo.Channel{
+		ID:      s.NewID(),
+		GuildID: guildID,
+		Name:    name,
+		Type:    discordgo.ChannelTypeGuildText,
+	}
+	s.channels[channel.ID] = channel
+	if guild := s.guilds[guildID]; guild != nil {
+		guild.Channels = append(guild.Channels, channel)
+	}
+	return channel
+}
+
+func (s *Server) NewUser(username string) *discordgo.User {
+	return &discordgo.User{
+		ID:            s.NewID(),
+		Username:      username,
+		GlobalName:    username,
+		Discriminator: "0",
+	}
+}
+
+func (s *Server) member(guildID string, userID string) *discordgo.Member {
+	guild := s.guilds[guildID]
+	if guild == nil {
+		return nil
+	}
+	for _, member := range guild.Members {
+		if member.User.ID == userID {
+			return member
+		}
+	}
+	return nil
+}
+
+// Copy of a guild member, nil if the user isn't in the guild
+func (s *Server) Member(guildID string, userID string) *discordgo.Member {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	member := s.member(guildID, userID)
+	if member == nil {
+		return nil
+	}
+	copied := *member
+	copied.Roles = append([]string{}, member.Roles...)
+	return &copied
+}
+
+// Messages in a channel, oldest first
+func (s *Server) Messages(channelID string) []*discordgo.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*discordgo.Message{}, s.messages[channelID]...)
+}
+
+// Messages the bot sent to a user's DMs
+func (s *Server) DirectMessages(userID string) []*discordgo.Message {
+	s.lock.Lock()
+	channelID, ok := s.dmChannels[userID]
+	s.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return s.Messages(channelID)
+}
+
+func (s *Server) RoleChanges() []RoleChange {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]RoleChange{}, s.roleChanges...)
+}
+
+func (s *Server) Bans() []Ban {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Ban{}, s.bans...)
+}
+
+// IDs of kicked users
+func (s *Server) Kicks() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.kicks...)
+}
+
+func (s *Server) InteractionResponses() []InteractionResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]InteractionResponse{}, s.interactionResponses...)
+}
+
+// Followup messages sent for an interaction
+func (s *Server) Followups(interactionID string) []*discordgo.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*discordgo.Message{}, s.followups[interactionID]...)
+}
+
+// Global commands as last registered by the bot
+func (s *Server) Commands() []*discordgo.ApplicationCommand {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*discordgo.ApplicationCommand{}, s.commands...)
+}
+
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Polls until the condition holds, the bot handles events asynchronously
+func (s *Server) WaitFor(timeout time.Duration, condition func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition not met within %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
@@ -6,13 +6,13 @@ require (
 	github.com/bwmarrin/discordgo v0.28.1
 	github.com/enescakir/emoji v1.0.0
 	github.com/go-errors/errors v1.5.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/lib/pq v1.10.9
 	github.com/tailscale/hujson v0.0.0-20221223112325-20486734a56a
 )
 
 require (
 	github.com/google/go-cmp v0.5.9 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 )
@@ -0,0 +1,86 @@
+// Liveness and readiness probes
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type HealthConfig struct {
+	// Address to serve /healthz and /readyz on, e.g. ":8080". Can be the
+	// same as Metrics.Listen.
+	Listen string
+	// How long the gateway may stay disconnected before /healthz fails and
+	// the bot gets restarted, defaults to 2m
+	GatewayGracePeriod Duration
+}
+
+func (c *HealthConfig) Validate() error {
+	if c.Listen == "" {
+		return Errorf("Health.Listen is required")
+	}
+	if c.GatewayGracePeriod < 0 {
+		return Errorf("Health.GatewayGracePeriod can't be negative")
+	}
+	return nil
+}
+
+// Alive as long as it can answer and the gateway recovers from disconnects.
+// discordgo gives up reconnecting in some cases, a restart fixes that.
+func (bot *Bot) serveHealthz(w http.ResponseWriter, request *http.Request) {
+	disconnected := bot.Discord.DisconnectedFor()
+	if !bot.stopping.Load() && disconnected > bot.gatewayGracePeriod {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "gateway disconnected for %v\n", disconnected.Round(time.Second))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Ready once the modules are registered and the gateway is connected, not
+// ready while reconnecting, shutting down or a module reports a problem
+func (bot *Bot) serveReadyz(w http.ResponseWriter, request *http.Request) {
+	problems := bot.readinessProblems(request.Context())
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+func (bot *Bot) readinessProblems(ctx context.Context) []string {
+	problems := []string{}
+	if bot.stopping.Load() {
+		problems = append(problems, "shutting down")
+	}
+	if !bot.started.Load() {
+		problems = append(problems, "modules not registered")
+	}
+	if !bot.Discord.Connected() {
+		problems = append(problems, "gateway not connected")
+	}
+	for _, module := range bot.Modules {
+		health, ok := module.(ModuleHealth)
+		if !ok {
+			continue
+		}
+		err := health.Health()
+		if err != nil {
+			problems = append(problems, moduleName(module)+": "+err.Error())
+		}
+	}
+	if bot.DB != nil {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		err := bot.DB.PingContext(ctx)
+		if err != nil {
+			problems = append(problems, "database unreachable: "+err.Error())
+		}
+	}
+	return problems
+}
@@ -0,0 +1,68 @@
+// HTTP listeners for metrics and probes
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTP servers by listen address, so endpoints configured on the same
+// address share one listener
+type HTTPServers struct {
+	servers map[string]*http.Server
+	muxes   map[string]*http.ServeMux
+}
+
+func NewHTTPServers() *HTTPServers {
+	return &HTTPServers{
+		servers: map[string]*http.Server{},
+		muxes:   map[string]*http.ServeMux{},
+	}
+}
+
+// Adds an endpoint, call before Start
+func (s *HTTPServers) Handle(listen string, pattern string, handler http.Handler) {
+	mux, ok := s.muxes[listen]
+	if !ok {
+		mux = http.NewServeMux()
+		s.muxes[listen] = mux
+		s.servers[listen] = &http.Server{
+			Addr:              listen,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	mux.Handle(pattern, handler)
+}
+
+// Starts listening, fails if an address can't be bound
+func (s *HTTPServers) Start() error {
+	for listen, server := range s.servers {
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return WrapError(err)
+		}
+		Log.Info("Serving HTTP", "address", listener.Addr().String())
+
+		go func(server *http.Server) {
+			err := server.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				Log.Error("HTTP server failed", "address", server.Addr, "error", err)
+			}
+		}(server)
+	}
+	return nil
+}
+
+func (s *HTTPServers) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, server := range s.servers {
+		server.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,156 @@
+// Interaction routing shared by all modules
+
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type InteractionHandler func(*discordgo.Interaction) error
+
+// Routes slash commands by name, and message components and modals by the
+// part of their custom ID before the first "|". The rest of the custom ID
+// carries arguments, e.g. "VerificationApproveButton|<user ID>".
+type InteractionRouter struct {
+	commands    map[string]InteractionHandler
+	components  map[string]InteractionHandler
+	modals      map[string]InteractionHandler
+	definitions []*discordgo.ApplicationCommand
+
+	// Answers interactions of modules disabled in the guild
+	OnDisabled InteractionHandler
+}
+
+func NewInteractionRouter() *InteractionRouter {
+	return &InteractionRouter{
+		commands:   map[string]InteractionHandler{},
+		components: map[string]InteractionHandler{},
+		modals:     map[string]InteractionHandler{},
+	}
+}
+
+func (r *InteractionRouter) AddCommand(command *discordgo.ApplicationCommand, handler InteractionHandler) {
+	r.commands[command.Name] = handler
+	r.definitions = append(r.definitions, command)
+}
+
+func (r *InteractionRouter) AddComponent(name string, handler InteractionHandler) {
+	r.components[name] = handler
+}
+
+func (r *InteractionRouter) AddModal(name string, handler InteractionHandler) {
+	r.modals[name] = handler
+}
+
+// Runs the handler of the interaction, errors and panics are logged with the
+// module of the handler and the interaction's guild and user
+func (r *InteractionRouter) OnInteractionCreate(interaction *discordgo.InteractionCreate) {
+	var handler InteractionHandler
+
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand:
+		handler = r.commands[interaction.ApplicationCommandData().Name]
+	case discordgo.InteractionMessageComponent:
+		name, _, _ := strings.Cut(interaction.MessageComponentData().CustomID, "|")
+		handler = r.components[name]
+	case discordgo.InteractionModalSubmit:
+		name, _, _ := strings.Cut(interaction.ModalSubmitData().CustomID, "|")
+		handler = r.modals[name]
+	}
+
+	if handler == nil {
+		return
+	}
+	module := handlerModule(handler)
+	if !ModuleEnabledIn(module, interaction.GuildID) {
+		handler = r.OnDisabled
+		if handler == nil {
+			return
+		}
+	}
+	runHandler(module, InteractionAttrs(interaction.Interaction), func() error {
+		return handler(interaction.Interaction)
+	})
+}
+
+// Replaces the application's global commands with the registered ones
+func (r *InteractionRouter) SyncCommands(discord *Discord, localizer *Localizer) error {
+	for _, command := range r.definitions {
+		localizer.LocalizeCommand(command)
+	}
+
+	_, err := discord.ApplicationCommandBulkOverwrite(discord.State.User.ID, "", r.definitions)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	Log.Info("Registered commands", "count", len(r.definitions))
+	return nil
+}
+
+// Options of a command or subcommand, keyed by name
+func CommandOptions(options []*discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	optionMap := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range options {
+		optionMap[option.Name] = option
+	}
+	return optionMap
+}
+
+// The subcommand that was invoked and its options
+func Subcommand(interaction *discordgo.Interaction) (string, map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	data := interaction.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return "", nil
+	}
+
+	subcommand := data.Options[0]
+	if subcommand.Type == discordgo.ApplicationCommandOptionSubCommandGroup && len(subcommand.Options) > 0 {
+		return subcommand.Name + " " + subcommand.Options[0].Name, CommandOptions(subcommand.Options[0].Options)
+	}
+	return subcommand.Name, CommandOptions(subcommand.Options)
+}
+
+func OptionString(options map[string]*discordgo.ApplicationCommandInteractionDataOption, name string) string {
+	option, ok := options[name]
+	if !ok {
+		return ""
+	}
+	return option.StringValue()
+}
+
+func OptionInt(options map[string]*discordgo.ApplicationCommandInteractionDataOption, name string) int64 {
+	option, ok := options[name]
+	if !ok {
+		return 0
+	}
+	return option.IntValue()
+}
+
+func OptionBool(options map[string]*discordgo.ApplicationCommandInteractionDataOption, name string) bool {
+	option, ok := options[name]
+	if !ok {
+		return false
+	}
+	return option.BoolValue()
+}
+
+// ID of a user, role, channel or mentionable option
+func OptionID(options map[string]*discordgo.ApplicationCommandInteractionDataOption, name string) string {
+	option, ok := options[name]
+	if !ok {
+		return ""
+	}
+	id, _ := option.Value.(string)
+	return id
+}
+
+// User that invoked the interaction, both in guilds and in DMs
+func InteractionUser(interaction *discordgo.Interaction) *discordgo.User {
+	if interaction.Member != nil {
+		return interaction.Member.User
+	}
+	return interaction.User
+}
@@ -0,0 +1,497 @@
+// Durable job queue
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/lib/pq"
+)
+
+// A piece of work to run at a later time, e.g. lifting a temporary ban
+type Job struct {
+	ID      int64
+	GuildID string
+	Name    string
+	Payload json.RawMessage
+	RunAt   time.Time
+	// Including the current run
+	Attempts  int
+	LastError string
+	// Gave up after MaxJobAttempts
+	Failed bool
+	// Cancelled while it was running, it is deleted instead of retried
+	Cancelled bool
+	CreatedAt time.Time
+}
+
+// Decodes the payload given to Enqueue
+func (j *Job) Decode(v any) error {
+	err := json.Unmarshal(j.Payload, v)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+type JobHandler func(job *Job) error
+
+const (
+	// Runs after which a failing job is kept as failed for /jobs retry
+	MaxJobAttempts = 5
+	jobWorkers     = 4
+	jobPollEvery   = 5 * time.Second
+	// A job whose worker stops extending its lock for this long is considered
+	// crashed and runs again, so handlers must be safe to repeat
+	jobLease = 5 * time.Minute
+)
+
+const jobsSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id           BIGSERIAL PRIMARY KEY,
+	guild_id     TEXT NOT NULL DEFAULT '',
+	name         TEXT NOT NULL,
+	payload      JSONB NOT NULL,
+	run_at       TIMESTAMPTZ NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	last_error   TEXT NOT NULL DEFAULT '',
+	failed       BOOLEAN NOT NULL DEFAULT FALSE,
+	locked_until TIMESTAMPTZ,
+	cancelled    BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cancelled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS jobs_due ON jobs (run_at) WHERE NOT failed;
+CREATE INDEX IF NOT EXISTS jobs_guild ON jobs (guild_id, run_at);
+`
+
+const jobColumns = `id, guild_id, name, payload, run_at, attempts, last_error, failed, cancelled, created_at`
+
+var jobsLog = ModuleLogger("jobs")
+
+var jobRuns = NewCounter("fbot_jobs_total",
+	"Job runs by job name and result: done, retry or failed.", "name", "result")
+
+// Jobs stored in Postgres, so they survive restarts. Workers on any number of
+// bot instances claim due jobs with row locks, jobs that came due while the
+// bot was down run right after it starts.
+type JobQueue struct {
+	DB        *sql.DB
+	Discord   DiscordAPI
+	Localizer *Localizer
+
+	handlers map[string]JobHandler
+	wake     chan struct{}
+	stop     chan struct{}
+	running  sync.WaitGroup
+}
+
+func NewJobQueue(db *sql.DB) *JobQueue {
+	return &JobQueue{
+		DB:       db,
+		handlers: map[string]JobHandler{},
+		wake:     make(chan struct{}, jobWorkers),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Creates the table and adds the /jobs command
+func (q *JobQueue) Register(bot *Bot) error {
+	q.Discord = bot.Discord
+	q.Localizer = bot.Localizer
+
+	_, err := q.DB.Exec(jobsSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	idOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "id",
+			Description: "Job number, see /jobs list",
+			Required:    true,
+			MinValue:    Ptr(1.0),
+		}
+	}
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "jobs",
+		Description:              "Show and manage scheduled jobs of this server",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionAdministrator)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List pending jobs",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "failed",
+						Description: "List jobs that failed for good instead",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "retry",
+				Description: "Run a job again right away",
+				Options:     []*discordgo.ApplicationCommandOption{idOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cancel",
+				Description: "Delete a job",
+				Options:     []*discordgo.ApplicationCommandOption{idOption()},
+			},
+		},
+	}, q.JobsCommand)
+	return nil
+}
+
+// Sets the handler of jobs with the name, call from Register. Jobs without a
+// handler, e.g. of disabled modules, stay in the queue.
+func (q *JobQueue) Handle(name string, handler JobHandler) {
+	q.handlers[name] = handler
+}
+
+// Stores a job to run at runAt, the payload is encoded as JSON. GuildID is
+// the guild whose /jobs lists the job, empty for none.
+func (q *JobQueue) Enqueue(guildID string, name string, payload any, runAt time.Time) (int64, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return 0, WrapError(err)
+	}
+
+	var id int64
+	err = q.DB.QueryRow(`INSERT INTO jobs (guild_id, name, payload, run_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		guildID, name, encoded, runAt).Scan(&id)
+	if err != nil {
+		return 0, WrapError(err)
+	}
+	jobsLog.Debug("Job enqueued", "job", id, "name", name, "guild", guildID, "run_at", runAt)
+
+	if !runAt.After(time.Now()) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return id, nil
+}
+
+// Deletes a job. A job that is running right now is only marked as
+// cancelled, the worker deletes it once the run ends instead of retrying it.
+func (q *JobQueue) Cancel(id int64) error {
+	_, _, err := q.cancel(id, "")
+	return err
+}
+
+// Cancels a job of the guild, or of any guild if guildID is empty. Reports
+// whether the job exists and whether it was running.
+func (q *JobQueue) cancel(id int64, guildID string) (found bool, running bool, err error) {
+	result, err := q.DB.Exec(`
+		DELETE FROM jobs
+		WHERE id = $1 AND ($2 = '' OR guild_id = $2) AND (locked_until IS NULL OR locked_until < now())`,
+		id, guildID)
+	if err != nil {
+		return false, false, WrapError(err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		return true, false, nil
+	}
+
+	result, err = q.DB.Exec(`UPDATE jobs SET cancelled = TRUE WHERE id = $1 AND ($2 = '' OR guild_id = $2)`, id, guildID)
+	if err != nil {
+		return false, false, WrapError(err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+func (q *JobQueue) Start() {
+	for i := 0; i < jobWorkers; i++ {
+		q.running.Add(1)
+		go q.work()
+	}
+}
+
+// Waits for running jobs to finish
+func (q *JobQueue) Stop() {
+	close(q.stop)
+	q.running.Wait()
+}
+
+func (q *JobQueue) work() {
+	defer q.running.Done()
+
+	ticker := time.NewTicker(jobPollEvery)
+	defer ticker.Stop()
+
+	for {
+		job, err := q.claim()
+		if err != nil {
+			jobsLog.Error("Claiming job failed", "error", ErrorToStr(err))
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// Locks the next due job with a handler, nil if there is none
+func (q *JobQueue) claim() (*Job, error) {
+	names := make([]string, 0, len(q.handlers))
+	for name := range q.handlers {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	row := q.DB.QueryRow(`
+		UPDATE jobs SET attempts = attempts + 1, locked_until = now() + $1 * interval '1 second'
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE NOT failed AND run_at <= now() AND (locked_until IS NULL OR locked_until < now()) AND name = ANY($2)
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		int(jobLease.Seconds()), pq.Array(names))
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return job, nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	job := &Job{}
+	var payload []byte
+	err := row.Scan(&job.ID, &job.GuildID, &job.Name, &payload, &job.RunAt, &job.Attempts, &job.LastError, &job.Failed, &job.Cancelled, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	return job, nil
+}
+
+// Runs the job, deleting it when it succeeds and scheduling a retry when it
+// doesn't
+func (q *JobQueue) run(job *Job) {
+	handler := q.handlers[job.Name]
+	attrs := []any{"job", job.ID, "name", job.Name, "attempt", job.Attempts}
+	if job.GuildID != "" {
+		attrs = append(attrs, "guild", job.GuildID)
+	}
+	if late := time.Since(job.RunAt); late > time.Minute {
+		attrs = append(attrs, "late", late.Round(time.Second).String())
+	}
+
+	// Cancelled during a run whose worker crashed
+	if job.Cancelled {
+		jobsLog.Info("Deleting cancelled job", attrs...)
+		q.delete(job, attrs)
+		return
+	}
+
+	done := make(chan struct{})
+	go q.extendLease(job, done, attrs)
+
+	err := func() (err error) {
+		defer func() {
+			recovered := recover()
+			if recovered != nil {
+				handlerPanics.Inc(handlerModule(handler))
+				jobsLog.Error("Job panicked", append(attrs, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))...)
+				err = Errorf("panic: %v", recovered)
+			}
+		}()
+		return handler(job)
+	}()
+	close(done)
+
+	if err == nil {
+		jobRuns.Inc(job.Name, "done")
+		jobsLog.Info("Job done", attrs...)
+		q.delete(job, attrs)
+		return
+	}
+
+	handlerErrors.Inc(handlerModule(handler))
+	var result sql.Result
+	if job.Attempts >= MaxJobAttempts {
+		jobRuns.Inc(job.Name, "failed")
+		jobsLog.Error("Job failed for good", append(attrs, "error", ErrorToStr(err))...)
+		result, err = q.DB.Exec(`UPDATE jobs SET failed = TRUE, locked_until = NULL, last_error = $2 WHERE id = $1 AND NOT cancelled`,
+			job.ID, err.Error())
+	} else {
+		retryAt := time.Now().Add(jobBackoff(job.Attempts))
+		jobRuns.Inc(job.Name, "retry")
+		jobsLog.Warn("Job failed, retrying", append(attrs, "retry_at", retryAt, "error", ErrorToStr(err))...)
+		result, err = q.DB.Exec(`UPDATE jobs SET run_at = $2, locked_until = NULL, last_error = $3 WHERE id = $1 AND NOT cancelled`,
+			job.ID, retryAt, err.Error())
+	}
+	if err != nil {
+		jobsLog.Error("Updating failed job failed", append(attrs, "error", ErrorToStr(err))...)
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		jobsLog.Info("Job was cancelled while running, not retrying it", attrs...)
+		q.delete(job, attrs)
+	}
+}
+
+func (q *JobQueue) delete(job *Job, attrs []any) {
+	_, err := q.DB.Exec(`DELETE FROM jobs WHERE id = $1`, job.ID)
+	if err != nil {
+		jobsLog.Error("Deleting job failed", append(attrs, "error", ErrorToStr(err))...)
+	}
+}
+
+// Keeps the job locked while its handler runs, until done is closed
+func (q *JobQueue) extendLease(job *Job, done chan struct{}, attrs []any) {
+	ticker := time.NewTicker(jobLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		_, err := q.DB.Exec(`UPDATE jobs SET locked_until = now() + $2 * interval '1 second' WHERE id = $1 AND locked_until IS NOT NULL`,
+			job.ID, int(jobLease.Seconds()))
+		if err != nil {
+			jobsLog.Warn("Extending job lock failed", append(attrs, "error", ErrorToStr(err))...)
+		}
+	}
+}
+
+// 30s after the first failure, doubling up to an hour
+func jobBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	return min(backoff, time.Hour)
+}
+
+func (q *JobQueue) JobsCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+
+	err := q.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	data := NewTemplateData()
+	data.ID = int(OptionInt(options, "id"))
+
+	switch subcommand {
+	case "list":
+		return q.listJobs(interaction, OptionBool(options, "failed"))
+
+	case "retry":
+		// Running jobs are left alone, they would run twice at once otherwise
+		result, err := q.DB.Exec(`
+			UPDATE jobs SET failed = FALSE, attempts = 0, run_at = now(), locked_until = NULL
+			WHERE id = $1 AND guild_id = $2 AND NOT cancelled AND (locked_until IS NULL OR locked_until < now())`,
+			data.ID, interaction.GuildID)
+		if err != nil {
+			return WrapError(err)
+		}
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			var exists bool
+			err = q.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1 AND guild_id = $2 AND NOT cancelled)`, data.ID, interaction.GuildID).Scan(&exists)
+			if err != nil {
+				return WrapError(err)
+			}
+			if exists {
+				return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.running", data, locales...))
+			}
+			return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.not_found", data, locales...))
+		}
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+		return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.retried", data, locales...))
+
+	case "cancel":
+		found, running, err := q.cancel(int64(data.ID), interaction.GuildID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.not_found", data, locales...))
+		}
+		if running {
+			return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.cancelled_running", data, locales...))
+		}
+		return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.cancelled", data, locales...))
+	}
+	return nil
+}
+
+func (q *JobQueue) listJobs(interaction *discordgo.Interaction, failed bool) error {
+	locales := InteractionLocales(interaction)
+
+	rows, err := q.DB.Query(`SELECT `+jobColumns+` FROM jobs WHERE guild_id = $1 AND failed = $2 AND NOT cancelled ORDER BY run_at LIMIT 25`,
+		interaction.GuildID, failed)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		line := fmt.Sprintf("**%d** `%s` <t:%d:R>", job.ID, job.Name, job.RunAt.Unix())
+		if job.Attempts > 0 {
+			line += fmt.Sprintf(" (%d/%d)", job.Attempts, MaxJobAttempts)
+		}
+		if job.LastError != "" {
+			line += ": " + truncateText(strings.ReplaceAll(job.LastError, "\n", " "), 100)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	if len(lines) == 0 {
+		return q.Discord.FollowupEphemeral(interaction, q.Localizer.Text("jobs.none", nil, locales...))
+	}
+	return q.Discord.FollowupEphemeral(interaction, truncateText(strings.Join(lines, "\n"), 2000))
+}
@@ -0,0 +1,528 @@
+// Localization of bot responses
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type LocalizationConfig struct {
+	// Used when neither the user nor the guild locale has a translation
+	DefaultLocale string
+	// Optional directory with one <locale>.jsonc catalog per locale, e.g. de.jsonc
+	Directory string
+	// Catalogs given inline, keyed by locale and then message key
+	Catalogs map[string]map[string]*MessageTemplate
+}
+
+// Built-in English strings. Catalogs can translate any of these keys, and
+// additionally override configured messages and texts by their config path,
+// e.g. "VerificationSystem.DenyDmMessage". Slash commands are localized with
+// "commands.<command>[.<option>...].name" and ".description" keys.
+var defaultCatalog = map[string]string{
+	"module.disabled": "This feature is disabled on this server.",
+
+	"verification.paused":                "Verification is paused for the moment, please try again later.",
+	"verification.approved":              "Verification approved",
+	"verification.denied":                "Verification denied",
+	"verification.deny_failed":           "The deny message couldn't be created, the user wasn't notified. Please check the bot's logs.",
+	"verification.approved_footer":       "Approved by {{.Staff.Name}} ({{.Staff.ID}})",
+	"verification.denied_footer":         "Denied by {{.Staff.Name}} ({{.Staff.ID}})",
+	"verification.banned_footer":         "Banned by {{.Staff.Name}} ({{.Staff.ID}})",
+	"verification.field.user_id":         "User ID",
+	"verification.field.account_created": "Account created",
+	"verification.deny_modal.title":      "Deny verification",
+	"verification.deny_modal.reason":     "Reason",
+	"verification.ban_confirm":           "Are you sure you want to ban the user?",
+	"verification.ban_confirm.yes":       "Yes, I am sure",
+	"verification.ban_confirm.no":        "No, cancel",
+	"verification.ban_cancelled":         "Ban cancelled",
+	"verification.banned":                "User has been banned",
+
+	"moderation.action.warn":          "Warn",
+	"moderation.action.timeout":       "Timeout",
+	"moderation.action.kick":          "Kick",
+	"moderation.action.ban":           "Ban",
+	"moderation.action.unban":         "Unban",
+	"moderation.past.warn":            "warned",
+	"moderation.past.timeout":         "timed out",
+	"moderation.past.kick":            "kicked",
+	"moderation.past.ban":             "banned",
+	"moderation.past.unban":           "unbanned",
+	"moderation.dm":                   "You have been {{.Action}} in {{.Guild.Name}}{{if .Duration}} for {{.Duration}}{{end}}{{if .Reason}}. Reason: {{.Reason}}{{end}}",
+	"moderation.done":                 "Case #{{.CaseNumber}}: {{.User.Mention}} has been {{.Action}}",
+	"moderation.failed":               "Could not {{lower .Action}} {{.User.Mention}}, check the bot's permissions and role position",
+	"moderation.self":                 "You can't use this on yourself",
+	"moderation.protected":            "The server owner and the bot can't be moderated",
+	"moderation.hierarchy":            "You can't moderate {{.User.Mention}}, their highest role isn't below yours",
+	"moderation.bot_hierarchy":        "I can't moderate {{.User.Mention}}, their highest role isn't below mine",
+	"moderation.invalid_duration":     "Invalid duration, use for example 10m, 2h or 1d",
+	"moderation.duration_too_long":    "Timeouts can be at most 28 days",
+	"moderation.case_not_found":       "Case #{{.CaseNumber}} does not exist",
+	"moderation.case_updated":         "Reason of case #{{.CaseNumber}} updated",
+	"moderation.case_title":           "Case #{{.CaseNumber}} | {{.Action}}",
+	"moderation.field.user":           "User",
+	"moderation.field.moderator":      "Moderator",
+	"moderation.field.reason":         "Reason",
+	"moderation.field.duration":       "Duration",
+	"moderation.no_reason":            "No reason given",
+	"moderation.ban_expired":          "Temporary ban of case #{{.CaseNumber}} expired",
+	"moderation.escalation_reason":    "Automatic escalation after {{.Count}} warnings",
+	"moderation.escalated":            "Escalated to case #{{.CaseNumber}}: {{.User.Mention}} has been {{.Action}}",
+	"moderation.history_title":        "Moderation history of {{.User.Name}}",
+	"moderation.history_empty":        "{{.User.Mention}} has no cases",
+	"moderation.history_entry":        "#{{.CaseNumber}} {{.Action}} | {{date .Now}}",
+	"moderation.history_entry_detail": "{{default \"No reason given\" .Reason}} ({{.Staff.Mention}}{{if .Duration}}, {{.Duration}}{{end}})",
+
+	"automod.reason":        "Automod: {{.Rule}}",
+	"automod.log_title":     "Automod rule {{.Rule}} triggered",
+	"automod.field.channel": "Channel",
+	"automod.field.actions": "Actions",
+	"automod.field.message": "Message",
+
+	"antiraid.trigger.joins":        "{{.Count}} joins within {{.Duration}}",
+	"antiraid.trigger.new_accounts": "{{.Count}} accounts younger than {{.Age}} joined within {{.Duration}}",
+	"antiraid.trigger.cluster":      "{{.Count}} accounts created within {{.Age}} of each other joined within {{.Duration}}",
+	"antiraid.alert_title":          "Raid detected, lockdown enabled",
+	"antiraid.field.trigger":        "Trigger",
+	"antiraid.field.measures":       "Measures",
+	"antiraid.field.recent_joins":   "Recent joins (account age)",
+	"antiraid.measure.pause":        "Verification paused",
+	"antiraid.measure.level":        "Server verification level raised",
+	"antiraid.measure.kick":         "New joins get kicked",
+	"antiraid.measure.auto_lift":    "Lifts automatically in {{.Duration}}",
+	"antiraid.lift_button":          "Lift lockdown",
+	"antiraid.lifted":               "Lockdown lifted",
+	"antiraid.not_active":           "There is no active lockdown",
+	"antiraid.lifted_footer":        "Lifted by {{.Staff.Name}} ({{.Staff.ID}}), {{.Count}} joins kicked",
+	"antiraid.auto_lifted_footer":   "Lifted automatically, {{.Count}} joins kicked",
+	"antiraid.kick_reason":          "Raid lockdown",
+	"antiraid.no_permission":        "You need the Moderate Members permission to do this",
+
+	"lockdown.started":       "Locked {{.Count}} channels",
+	"lockdown.ended":         "Unlocked {{.Count}} channels",
+	"lockdown.none_unlocked": "All of these channels are locked already",
+	"lockdown.none_locked":   "None of these channels are locked",
+	"lockdown.failed":        "{{.Count}} channels could not be changed, check the bot's permissions",
+	"lockdown.start_notice":  ":lock: This channel has been locked by staff{{if .Reason}}: {{.Reason}}{{end}}",
+	"lockdown.end_notice":    ":unlock: This channel has been unlocked",
+
+	"rolemenu.created":            "Role menu {{.ID}} created, add roles with /rolemenu add",
+	"rolemenu.updated":            "Role menu {{.ID}} updated",
+	"rolemenu.deleted":            "Role menu {{.ID}} deleted",
+	"rolemenu.not_found":          "There is no role menu {{.ID}}",
+	"rolemenu.none":               "There are no role menus yet",
+	"rolemenu.invalid_role":       "This role can't be handed out by a role menu",
+	"rolemenu.role_too_high":      "{{.Role}} has to be below your highest role and the bot's",
+	"rolemenu.role_not_found":     "{{.Role}} isn't on role menu {{.ID}}",
+	"rolemenu.full":               "A role menu can't have more than 25 roles",
+	"rolemenu.multi_needs_select": "Multiple choice only works with a select menu",
+	"rolemenu.gone":               "This role menu doesn't exist anymore",
+	"rolemenu.requires_role":      "You need {{.Role}} to use this menu",
+	"rolemenu.max_reached":        "You can only have {{.Count}} roles from this menu",
+	"rolemenu.added":              "Added {{.Role}}",
+	"rolemenu.removed":            "Removed {{.Role}}",
+	"rolemenu.unchanged":          "Your roles didn't change",
+	"rolemenu.unavailable":        "{{.Role}} can't be picked anymore, please tell the staff",
+
+	"messagelog.edit_title":              "Message edited",
+	"messagelog.delete_title":            "Message deleted",
+	"messagelog.bulk_delete_title":       "{{.Count}} messages deleted",
+	"messagelog.bulk_delete_description": "Deleted in {{.Channel}}, the attached file has the messages that were known",
+	"messagelog.unknown_message":         "A message in {{.Channel}} was deleted, but its content isn't known",
+	"messagelog.jump":                    "[Jump to message]({{.URL}})",
+	"messagelog.nickname_title":          "Nickname changed",
+	"messagelog.roles_title":             "Roles changed",
+	"messagelog.join_title":              "Member joined",
+	"messagelog.join_description":        "{{.User.Mention}} joined, account age {{.User.AccountAge}}. Member #{{.Count}}",
+	"messagelog.leave_title":             "Member left",
+	"messagelog.leave_description":       "{{.User.Mention}} left",
+	"messagelog.field.before":            "Before",
+	"messagelog.field.after":             "After",
+	"messagelog.field.changes":           "Changes",
+	"messagelog.field.attachments":       "Attachments",
+	"messagelog.field.roles_added":       "Added",
+	"messagelog.field.roles_removed":     "Removed",
+
+	"welcome.card_member": "Member #{{.Count}}",
+
+	"tickets.panel":              "Need help? Open a ticket and staff will get back to you.",
+	"tickets.panel_posted":       "Ticket panel posted",
+	"tickets.no_permission":      "You need the Manage Server permission to do this",
+	"tickets.unknown_category":   "This kind of ticket doesn't exist anymore",
+	"tickets.too_many":           "You can only have {{.Count}} open tickets at once",
+	"tickets.opened":             "Your ticket is open: {{.Channel}}",
+	"tickets.open_message":       "Thanks for reaching out about **{{.Topic}}**, {{.User.Name}}. Describe your issue and staff will be with you shortly.",
+	"tickets.claim_button":       "Claim",
+	"tickets.close_button":       "Close",
+	"tickets.not_a_ticket":       "This isn't an open ticket",
+	"tickets.staff_only":         "Only staff can do this",
+	"tickets.already_claimed":    "{{.Staff.Mention}} already claimed this ticket",
+	"tickets.claimed":            "{{.Staff.Mention}} is handling this ticket",
+	"tickets.claim_done":         "You claimed this ticket",
+	"tickets.cant_remove_owner":  "The member who opened the ticket can't be removed",
+	"tickets.user_added":         "Added {{.User.Mention}} to the ticket",
+	"tickets.user_removed":       "Removed {{.User.Mention}} from the ticket",
+	"tickets.close_modal.title":  "Close ticket",
+	"tickets.close_modal.reason": "Reason",
+	"tickets.closing":            "Closing the ticket",
+	"tickets.transcript_title":   "Ticket #{{.CaseNumber}} closed",
+	"tickets.field.owner":        "Opened by",
+	"tickets.field.category":     "Category",
+	"tickets.field.claimed_by":   "Claimed by",
+	"tickets.field.closed_by":    "Closed by",
+	"tickets.field.messages":     "Messages",
+
+	"modmail.thread_opened":       "New modmail from {{.User.Mention}} ({{.User.ID}}), account age {{.User.AccountAge}}. Reply with /reply or the reply prefix.",
+	"modmail.from_member":         "Member",
+	"modmail.anonymous_name":      "Staff",
+	"modmail.replied":             "Sent by {{.Staff.Name}}",
+	"modmail.replied_anonymously": "Sent anonymously by {{.Staff.Name}}",
+	"modmail.reply_sent":          "Reply sent",
+	"modmail.reply_failed":        "The reply could not be delivered, the member may have DMs turned off",
+	"modmail.not_a_thread":        "This isn't an open modmail thread",
+	"modmail.closed":              "Modmail closed by {{.Staff.Name}}{{if .Reason}}: {{.Reason}}{{end}}",
+
+	"announcements.scheduled":         "Announcement {{.ID}} scheduled, first post {{.Time}}",
+	"announcements.updated":           "Announcement {{.ID}} updated",
+	"announcements.paused":            "Announcement {{.ID}} paused",
+	"announcements.resumed":           "Announcement {{.ID}} resumed, next post {{.Time}}",
+	"announcements.deleted":           "Announcement {{.ID}} deleted",
+	"announcements.not_found":         "There is no announcement {{.ID}}",
+	"announcements.none":              "There are no announcements yet",
+	"announcements.paused_marker":     "paused",
+	"announcements.invalid_schedule":  "Invalid schedule: {{.Error}}",
+	"announcements.invalid_color":     "Invalid color: {{.Error}}",
+	"announcements.no_channel_access": "You can't post in {{.Channel}} yourself",
+	"announcements.ping_not_allowed":  "You need the Mention Everyone permission to ping {{.Role}}",
+
+	"jobs.none":              "No jobs",
+	"jobs.not_found":         "There is no job {{.ID}}",
+	"jobs.retried":           "Job {{.ID}} runs again",
+	"jobs.running":           "Job {{.ID}} is running right now",
+	"jobs.cancelled":         "Job {{.ID}} deleted",
+	"jobs.cancelled_running": "Job {{.ID}} is running right now, it is deleted once it ends instead of running again",
+
+	"reminders.created":            "Reminder {{.ID}} set, I'll remind you {{.Time}}",
+	"reminders.created_repeating":  "Reminder {{.ID}} set, I'll remind you {{.Time}} and then `{{.Duration}}`",
+	"reminders.deleted":            "Reminder {{.ID}} deleted",
+	"reminders.not_found":          "You have no reminder {{.ID}}",
+	"reminders.none":               "You have no reminders",
+	"reminders.too_many":           "You can only have {{.Count}} reminders at once",
+	"reminders.invalid_duration":   "I don't understand \"{{.Input}}\", try something like \"2h\" or \"30 minutes\". Reminders need to be at least a minute away.",
+	"reminders.invalid_repeat":     "Invalid repeat: {{.Error}}",
+	"reminders.no_send_permission": "You can't send messages in this channel, use the dm option to get the reminder in your DMs",
+	"reminders.reminder":           ":alarm_clock: {{.User.Mention}}, you asked me to remind you: {{.Text}}",
+	"reminders.late":               "_Sorry, this is {{.Duration}} late because I was offline._",
+}
+
+type Localizer struct {
+	defaultLocale discordgo.Locale
+	catalogs      map[discordgo.Locale]map[string]*MessageTemplate
+	// Keys of catalogs, sorted
+	catalogLocales []discordgo.Locale
+	builtin        map[string]*Template
+}
+
+func NewLocalizer(config *LocalizationConfig) (*Localizer, error) {
+	if config == nil {
+		config = &LocalizationConfig{}
+	}
+
+	l := &Localizer{
+		defaultLocale: discordgo.EnglishUS,
+		catalogs:      map[discordgo.Locale]map[string]*MessageTemplate{},
+		builtin:       map[string]*Template{},
+	}
+	if config.DefaultLocale != "" {
+		l.defaultLocale = discordgo.Locale(config.DefaultLocale)
+	}
+
+	for key, source := range defaultCatalog {
+		tmpl, err := NewTemplate(source)
+		if err != nil {
+			return nil, err
+		}
+		l.builtin[key] = tmpl
+	}
+
+	if config.Directory != "" {
+		files, err := filepath.Glob(filepath.Join(config.Directory, "*.jsonc"))
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		for _, file := range files {
+			jsonBytes, err := os.ReadFile(file)
+			if err != nil {
+				return nil, WrapError(err)
+			}
+
+			catalog := map[string]*MessageTemplate{}
+			err = UnmarshalJSONC(jsonBytes, &catalog)
+			if err != nil {
+				return nil, Errorf("locale file %s: %w", file, err)
+			}
+
+			locale := strings.TrimSuffix(filepath.Base(file), ".jsonc")
+			err = l.addCatalog(locale, catalog)
+			if err != nil {
+				return nil, Errorf("locale file %s: %w", file, err)
+			}
+		}
+	}
+
+	// Inline catalogs take precedence over files
+	for locale, catalog := range config.Catalogs {
+		err := l.addCatalog(locale, catalog)
+		if err != nil {
+			return nil, Errorf("Localization.Catalogs.%s: %w", locale, err)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *Localizer) addCatalog(locale string, catalog map[string]*MessageTemplate) error {
+	if _, ok := discordgo.Locales[discordgo.Locale(locale)]; !ok {
+		return Errorf("unknown locale %q", locale)
+	}
+
+	existing, ok := l.catalogs[discordgo.Locale(locale)]
+	if !ok {
+		existing = map[string]*MessageTemplate{}
+		l.catalogs[discordgo.Locale(locale)] = existing
+		l.catalogLocales = append(l.catalogLocales, discordgo.Locale(locale))
+		slices.Sort(l.catalogLocales)
+	}
+
+	for key, messageTemplate := range catalog {
+		if !knownCatalogKey(key) {
+			return Errorf("unknown key %q", key)
+		}
+		err := messageTemplate.Validate(key)
+		if err != nil {
+			return Errorf("%s: %w", key, err)
+		}
+		existing[key] = messageTemplate
+	}
+	return nil
+}
+
+// Config paths of configured plain strings, localized with ConfigText
+var configTexts = []string{
+	"VerificationSystem.VerifyButtonText",
+	"VerificationSystem.FormTitle",
+	"VerificationSystem.ApproveButtonText",
+	"VerificationSystem.DenyButtonText",
+	"VerificationSystem.BanButtonText",
+	"Modmail.AnonymousName",
+}
+
+// Config paths of configured plain strings in lists and maps
+var configTextPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^VerificationSystem\.FormFields\.\d+\.(Label|Placeholder)$`),
+	regexp.MustCompile(`^Tickets\.Categories\..+$`),
+}
+
+// Whether catalogs can translate the key, so that typos don't go unnoticed.
+// Slash command keys are checked against the registered commands later, see
+// CheckCommandKeys.
+func knownCatalogKey(key string) bool {
+	if _, ok := defaultCatalog[key]; ok {
+		return true
+	}
+	if _, ok := templateContexts[key]; ok {
+		return true
+	}
+	if strings.HasPrefix(key, "commands.") || slices.Contains(configTexts, key) {
+		return true
+	}
+	for _, pattern := range configTextPatterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fails on "commands." catalog keys that don't match a command, option or
+// choice of the given commands
+func (l *Localizer) CheckCommandKeys(commands []*discordgo.ApplicationCommand) error {
+	known := map[string]bool{}
+	var addOptions func(key string, options []*discordgo.ApplicationCommandOption)
+	addOptions = func(key string, options []*discordgo.ApplicationCommandOption) {
+		for _, option := range options {
+			optionKey := key + "." + option.Name
+			known[optionKey+".name"] = true
+			known[optionKey+".description"] = true
+			for _, choice := range option.Choices {
+				known[optionKey+".choices."+choice.Name] = true
+			}
+			addOptions(optionKey, option.Options)
+		}
+	}
+	for _, command := range commands {
+		key := "commands." + command.Name
+		known[key+".name"] = true
+		known[key+".description"] = true
+		addOptions(key, command.Options)
+	}
+
+	for _, locale := range l.catalogLocales {
+		keys := []string{}
+		for key := range l.catalogs[locale] {
+			if strings.HasPrefix(key, "commands.") && !known[key] {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			slices.Sort(keys)
+			return Errorf("catalog %s: keys %s don't match a registered command", locale, strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
+
+// Locales to try for an interaction, the user's own locale first
+func InteractionLocales(interaction *discordgo.Interaction) []discordgo.Locale {
+	locales := []discordgo.Locale{interaction.Locale}
+	if interaction.GuildLocale != nil {
+		locales = append(locales, *interaction.GuildLocale)
+	}
+	return locales
+}
+
+func GuildLocales(guild *discordgo.Guild) []discordgo.Locale {
+	if guild == nil {
+		return nil
+	}
+	return []discordgo.Locale{discordgo.Locale(guild.PreferredLocale)}
+}
+
+// Finds the catalog entry for the first locale that has one. Locales are
+// matched exactly first, then by language, so en-GB can use an en-US catalog.
+func (l *Localizer) lookup(key string, locales []discordgo.Locale) *MessageTemplate {
+	locales = append(locales, l.defaultLocale)
+
+	for _, locale := range locales {
+		if messageTemplate, ok := l.catalogs[locale][key]; ok {
+			return messageTemplate
+		}
+
+		// In sorted order so that e.g. pt-BR and pt-PT always resolve the same
+		language, _, _ := strings.Cut(string(locale), "-")
+		for _, catalogLocale := range l.catalogLocales {
+			catalogLanguage, _, _ := strings.Cut(string(catalogLocale), "-")
+			if language == "" || catalogLanguage != language {
+				continue
+			}
+			if messageTemplate, ok := l.catalogs[catalogLocale][key]; ok {
+				return messageTemplate
+			}
+		}
+	}
+
+	return nil
+}
+
+// Localized text for a built-in key. Unknown keys are returned as is.
+func (l *Localizer) Text(key string, data *TemplateData, locales ...discordgo.Locale) string {
+	if data == nil {
+		data = NewTemplateData()
+	}
+
+	var text string
+	var err error
+	if messageTemplate := l.lookup(key, locales); messageTemplate != nil {
+		text, err = messageTemplate.Content.Render(data)
+	} else if tmpl, ok := l.builtin[key]; ok {
+		text, err = tmpl.Render(data)
+	} else {
+		return key
+	}
+
+	if err != nil {
+		Log.Warn("Rendering localized text failed", "key", key, "error", err)
+		return key
+	}
+	return text
+}
+
+// Localized version of a configured plain string such as a button label
+func (l *Localizer) ConfigText(key string, fallback string, locales ...discordgo.Locale) string {
+	messageTemplate := l.lookup(key, locales)
+	if messageTemplate == nil {
+		return fallback
+	}
+
+	text, err := messageTemplate.Content.Render(NewTemplateData())
+	if err != nil {
+		Log.Warn("Rendering localized text failed", "key", key, "error", err)
+		return fallback
+	}
+	return text
+}
+
+// Localized version of a configured message, falling back to the configured
+// template when no catalog overrides it
+func (l *Localizer) Message(key string, fallback *MessageTemplate, data *TemplateData, locales ...discordgo.Locale) (*discordgo.MessageSend, error) {
+	messageTemplate := l.lookup(key, locales)
+	if messageTemplate == nil {
+		messageTemplate = fallback
+	}
+	return messageTemplate.Render(data)
+}
+
+// Fills in name and description localizations of a slash command and its
+// options from the catalogs
+func (l *Localizer) LocalizeCommand(command *discordgo.ApplicationCommand) {
+	key := "commands." + command.Name
+	names := l.localizations(key + ".name")
+	descriptions := l.localizations(key + ".description")
+	if len(names) > 0 {
+		command.NameLocalizations = &names
+	}
+	if len(descriptions) > 0 {
+		command.DescriptionLocalizations = &descriptions
+	}
+
+	l.localizeOptions(key, command.Options)
+}
+
+func (l *Localizer) localizeOptions(key string, options []*discordgo.ApplicationCommandOption) {
+	for _, option := range options {
+		optionKey := key + "." + option.Name
+		option.NameLocalizations = l.localizations(optionKey + ".name")
+		option.DescriptionLocalizations = l.localizations(optionKey + ".description")
+
+		for _, choice := range option.Choices {
+			choice.NameLocalizations = l.localizations(optionKey + ".choices." + choice.Name)
+		}
+
+		l.localizeOptions(optionKey, option.Options)
+	}
+}
+
+func (l *Localizer) localizations(key string) map[discordgo.Locale]string {
+	localizations := map[discordgo.Locale]string{}
+	for locale, catalog := range l.catalogs {
+		messageTemplate, ok := catalog[key]
+		if !ok {
+			continue
+		}
+
+		text, err := messageTemplate.Content.Render(NewTemplateData())
+		if err != nil {
+			Log.Warn("Rendering localized text failed", "key", key, "locale", locale, "error", err)
+			continue
+		}
+		localizations[locale] = text
+	}
+	return localizations
+}
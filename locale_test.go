@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestLocalizerCatalogKeys(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"module.disabled", true},
+		{"module.disabeld", false},
+		{"Welcome.Message", true},
+		{"Welcome.Mesage", false},
+		{"VerificationSystem.VerifyButtonText", true},
+		{"VerificationSystem.FormFields.0.Label", true},
+		{"VerificationSystem.FormFields.first.Label", false},
+		{"Tickets.Categories.support", true},
+		{"commands.remind.description", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			message := &MessageTemplate{}
+			err := json.Unmarshal([]byte(`"text"`), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewLocalizer(&LocalizationConfig{
+				Catalogs: map[string]map[string]*MessageTemplate{"de": {test.key: message}},
+			})
+			if test.valid && err != nil {
+				t.Fatalf("%s was rejected: %v", test.key, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("%s was accepted", test.key)
+			}
+		})
+	}
+}
+
+func TestLocalizerShippedCatalogs(t *testing.T) {
+	_, err := NewLocalizer(&LocalizationConfig{Directory: "locales"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalizerCommandKeys(t *testing.T) {
+	commands := []*discordgo.ApplicationCommand{{
+		Name: "remind",
+		Options: []*discordgo.ApplicationCommandOption{{
+			Name: "when",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "tomorrow", Value: "tomorrow"},
+			},
+		}},
+	}}
+
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"commands.remind.name", true},
+		{"commands.remind.when.description", true},
+		{"commands.remind.when.choices.tomorrow", true},
+		{"commands.remind.what.description", false},
+		{"commands.remindme.name", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			message := &MessageTemplate{}
+			err := json.Unmarshal([]byte(`"text"`), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			localizer, err := NewLocalizer(&LocalizationConfig{
+				Catalogs: map[string]map[string]*MessageTemplate{"de": {test.key: message}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = localizer.CheckCommandKeys(commands)
+			if test.valid && err != nil {
+				t.Fatalf("%s was rejected: %v", test.key, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("%s was accepted", test.key)
+			}
+		})
+	}
+}
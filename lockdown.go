@@ -0,0 +1,412 @@
+// Channel lockdown module
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type LockdownConfig struct {
+	// Posted in every channel that gets locked or unlocked. Reason is the
+	// reason given by staff. Default to the "lockdown.start_notice" and
+	// "lockdown.end_notice" catalog messages.
+	StartNotice *MessageTemplate
+	EndNotice   *MessageTemplate
+	// Never touched when locking a category or the whole server, e.g. staff
+	// channels
+	ExcludedChannels []string
+}
+
+func (c *LockdownConfig) Validate() error {
+	if c.StartNotice != nil {
+		err := c.StartNotice.Validate("Lockdown.StartNotice")
+		if err != nil {
+			return Errorf("Lockdown.StartNotice: %w", err)
+		}
+	}
+	if c.EndNotice != nil {
+		err := c.EndNotice.Validate("Lockdown.EndNotice")
+		if err != nil {
+			return Errorf("Lockdown.EndNotice: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Permissions @everyone loses in a locked channel
+const lockdownDeniedPermissions = discordgo.PermissionSendMessages |
+	discordgo.PermissionSendMessagesInThreads |
+	discordgo.PermissionCreatePublicThreads |
+	discordgo.PermissionCreatePrivateThreads
+
+// @everyone overwrite of a channel as it was before the lockdown
+type lockdownSnapshot struct {
+	ChannelID    string
+	HadOverwrite bool
+	Allow        int64
+	Deny         int64
+}
+
+// Bits of lockdownDeniedPermissions the lockdown took out of allow and put
+// into deny, only these are undone
+func (s lockdownSnapshot) changed() (removedAllow int64, addedDeny int64) {
+	return s.Allow & lockdownDeniedPermissions, lockdownDeniedPermissions &^ s.Deny
+}
+
+var lockdownLog = ModuleLogger("lockdown")
+
+type LockdownModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *LockdownConfig
+}
+
+const lockdownSchema = `
+CREATE TABLE IF NOT EXISTS lockdown_overwrites (
+	channel_id    TEXT PRIMARY KEY,
+	guild_id      TEXT NOT NULL,
+	had_overwrite BOOLEAN NOT NULL,
+	allow         BIGINT NOT NULL,
+	deny          BIGINT NOT NULL,
+	locked_by     TEXT NOT NULL,
+	reason        TEXT NOT NULL DEFAULT '',
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "lockdown", NeedsDB: true}, func(config *Config) *LockdownConfig {
+		return config.Lockdown
+	}, NewLockdownModule)
+}
+
+func NewLockdownModule(config *LockdownConfig) *LockdownModule {
+	return &LockdownModule{
+		Config: config,
+	}
+}
+
+func (m *LockdownModule) Register(bot *Bot) error {
+	lockdownLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(lockdownSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	scopeOptions := func() []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "channel",
+				Description:  "Only this channel",
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews, discordgo.ChannelTypeGuildForum},
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "category",
+				Description:  "Only the channels in this category",
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildCategory},
+			},
+		}
+	}
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "lockdown",
+		Description:              "Stop members from sending messages",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionManageChannels)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "start",
+				Description: "Lock the whole server, a category or a channel",
+				Options: append(scopeOptions(), &discordgo.ApplicationCommandOption{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "reason",
+					Description: "Shown in the locked channels",
+					MaxLength:   1000,
+				}),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "end",
+				Description: "Unlock the whole server, a category or a channel",
+				Options:     scopeOptions(),
+			},
+		},
+	}, m.LockdownCommand)
+
+	return nil
+}
+
+func (m *LockdownModule) LockdownCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	channels, err := m.scopeChannels(interaction.GuildID, OptionID(options, "channel"), OptionID(options, "category"))
+	if err != nil {
+		return err
+	}
+
+	data := NewTemplateData()
+	switch subcommand {
+	case "start":
+		reason := OptionString(options, "reason")
+		lockdownLog.Info("Lockdown started", "guild", interaction.GuildID, "channels", len(channels), "staff", interaction.Member.User.ID)
+
+		locked, failed := m.Lock(interaction.GuildID, channels, interaction.Member, reason)
+		data.Count = locked
+		feedback := m.Localizer.Text("lockdown.started", data, locales...)
+		if locked == 0 && failed == 0 {
+			feedback = m.Localizer.Text("lockdown.none_unlocked", nil, locales...)
+		}
+		if failed > 0 {
+			data.Count = failed
+			feedback += "\n" + m.Localizer.Text("lockdown.failed", data, locales...)
+		}
+		return m.Discord.FollowupEphemeral(interaction, feedback)
+
+	case "end":
+		lockdownLog.Info("Lockdown ended", "guild", interaction.GuildID, "channels", len(channels), "staff", interaction.Member.User.ID)
+
+		unlocked, failed, err := m.Unlock(interaction.GuildID, channels, interaction.Member)
+		if err != nil {
+			return err
+		}
+		data.Count = unlocked
+		feedback := m.Localizer.Text("lockdown.ended", data, locales...)
+		if unlocked == 0 && failed == 0 {
+			feedback = m.Localizer.Text("lockdown.none_locked", nil, locales...)
+		}
+		if failed > 0 {
+			data.Count = failed
+			feedback += "\n" + m.Localizer.Text("lockdown.failed", data, locales...)
+		}
+		return m.Discord.FollowupEphemeral(interaction, feedback)
+	}
+
+	return nil
+}
+
+// Channels a lockdown applies to: the given channel, the channels of the
+// given category, or every channel members can write in
+func (m *LockdownModule) scopeChannels(guildID string, channelID string, categoryID string) ([]*discordgo.Channel, error) {
+	channels, err := m.Discord.GuildChannels(guildID)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	scoped := []*discordgo.Channel{}
+	for _, channel := range channels {
+		switch channel.Type {
+		case discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews, discordgo.ChannelTypeGuildForum:
+		default:
+			continue
+		}
+
+		if channelID != "" {
+			if channel.ID == channelID {
+				scoped = append(scoped, channel)
+			}
+			continue
+		}
+
+		if slices.Contains(m.Config.ExcludedChannels, channel.ID) {
+			continue
+		}
+		if categoryID != "" && channel.ParentID != categoryID {
+			continue
+		}
+		scoped = append(scoped, channel)
+	}
+
+	return scoped, nil
+}
+
+// Snapshots and replaces the @everyone overwrite of every channel that isn't
+// locked yet. Returns the number of channels locked and failed.
+func (m *LockdownModule) Lock(guildID string, channels []*discordgo.Channel, staff *discordgo.Member, reason string) (int, int) {
+	locked, failed := 0, 0
+	auditLogReason := discordgo.WithAuditLogReason(fmt.Sprintf("Lockdown by %v (%v)", staff.DisplayName(), staff.User.ID))
+
+	for _, channel := range channels {
+		// @everyone shares its ID with the guild
+		snapshot := lockdownSnapshot{ChannelID: channel.ID}
+		for _, overwrite := range channel.PermissionOverwrites {
+			if overwrite.ID == guildID && overwrite.Type == discordgo.PermissionOverwriteTypeRole {
+				snapshot.HadOverwrite = true
+				snapshot.Allow = overwrite.Allow
+				snapshot.Deny = overwrite.Deny
+			}
+		}
+
+		// Store the snapshot first so a crash mid-way can still be undone.
+		// Channels that are already locked keep their original snapshot.
+		result, err := m.DB.Exec(`
+			INSERT INTO lockdown_overwrites (channel_id, guild_id, had_overwrite, allow, deny, locked_by, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (channel_id) DO NOTHING`,
+			channel.ID, guildID, snapshot.HadOverwrite, snapshot.Allow, snapshot.Deny, staff.User.ID, reason,
+		)
+		if err != nil {
+			lockdownLog.Warn("Could not store overwrites", "channel", channel.ID, "error", err)
+			failed++
+			continue
+		}
+		if inserted, _ := result.RowsAffected(); inserted == 0 {
+			continue
+		}
+
+		err = m.Discord.ChannelPermissionSet(channel.ID, guildID, discordgo.PermissionOverwriteTypeRole,
+			snapshot.Allow&^lockdownDeniedPermissions, snapshot.Deny|lockdownDeniedPermissions, auditLogReason)
+		if err != nil {
+			lockdownLog.Warn("Could not lock channel", "channel", channel.ID, "error", err)
+			m.forgetSnapshot(channel.ID)
+			failed++
+			continue
+		}
+		locked++
+
+		m.postNotice(guildID, channel, "Lockdown.StartNotice", m.Config.StartNotice, "lockdown.start_notice", staff, reason)
+	}
+
+	return locked, failed
+}
+
+// Undoes the lockdown of the locked channels among the given ones. Only the
+// permissions Lock changed are restored, other changes staff made in the
+// meantime stay. Returns the number of channels unlocked and failed.
+func (m *LockdownModule) Unlock(guildID string, channels []*discordgo.Channel, staff *discordgo.Member) (int, int, error) {
+	rows, err := m.DB.Query(`SELECT channel_id, had_overwrite, allow, deny FROM lockdown_overwrites WHERE guild_id = $1`, guildID)
+	if err != nil {
+		return 0, 0, WrapError(err)
+	}
+	snapshots := map[string]lockdownSnapshot{}
+	for rows.Next() {
+		snapshot := lockdownSnapshot{}
+		err = rows.Scan(&snapshot.ChannelID, &snapshot.HadOverwrite, &snapshot.Allow, &snapshot.Deny)
+		if err != nil {
+			rows.Close()
+			return 0, 0, WrapError(err)
+		}
+		snapshots[snapshot.ChannelID] = snapshot
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, WrapError(err)
+	}
+
+	// Channels deleted during the lockdown have nothing to unlock
+	guildChannels, err := m.Discord.GuildChannels(guildID)
+	if err != nil {
+		return 0, 0, WrapError(err)
+	}
+	for channelID := range snapshots {
+		exists := slices.ContainsFunc(guildChannels, func(channel *discordgo.Channel) bool {
+			return channel.ID == channelID
+		})
+		if !exists {
+			lockdownLog.Info("Forgetting lockdown of deleted channel", "guild", guildID, "channel", channelID)
+			m.forgetSnapshot(channelID)
+		}
+	}
+
+	unlocked, failed := 0, 0
+	auditLogReason := discordgo.WithAuditLogReason(fmt.Sprintf("Lockdown lifted by %v (%v)", staff.DisplayName(), staff.User.ID))
+
+	for _, channel := range channels {
+		snapshot, ok := snapshots[channel.ID]
+		if !ok {
+			continue
+		}
+
+		var current *discordgo.PermissionOverwrite
+		for _, overwrite := range channel.PermissionOverwrites {
+			if overwrite.ID == guildID && overwrite.Type == discordgo.PermissionOverwriteTypeRole {
+				current = overwrite
+			}
+		}
+
+		// Staff removing the overwrite during the lockdown already unlocked
+		// the channel
+		err = nil
+		if current != nil {
+			removedAllow, addedDeny := snapshot.changed()
+			deny := current.Deny &^ addedDeny
+			allow := (current.Allow | removedAllow) &^ deny
+			if allow == 0 && deny == 0 && !snapshot.HadOverwrite {
+				err = m.Discord.ChannelPermissionDelete(channel.ID, guildID, auditLogReason)
+			} else {
+				err = m.Discord.ChannelPermissionSet(channel.ID, guildID, discordgo.PermissionOverwriteTypeRole, allow, deny, auditLogReason)
+			}
+		}
+		if err != nil {
+			lockdownLog.Warn("Could not unlock channel", "channel", channel.ID, "error", err)
+			failed++
+			continue
+		}
+
+		m.forgetSnapshot(channel.ID)
+		unlocked++
+
+		m.postNotice(guildID, channel, "Lockdown.EndNotice", m.Config.EndNotice, "lockdown.end_notice", staff, "")
+	}
+
+	return unlocked, failed, nil
+}
+
+func (m *LockdownModule) forgetSnapshot(channelID string) {
+	_, err := m.DB.Exec(`DELETE FROM lockdown_overwrites WHERE channel_id = $1`, channelID)
+	if err != nil {
+		lockdownLog.Warn("Could not delete overwrite snapshot", "channel", channelID, "error", err)
+	}
+}
+
+func (m *LockdownModule) postNotice(guildID string, channel *discordgo.Channel, configKey string, configured *MessageTemplate, catalogKey string, staff *discordgo.Member, reason string) {
+	// Forum channels can't take messages directly
+	if channel.Type == discordgo.ChannelTypeGuildForum {
+		return
+	}
+
+	guild := m.Discord.CachedGuild(guildID)
+	locales := GuildLocales(guild)
+	data := NewTemplateData()
+	data.Guild = NewTemplateGuild(guild)
+	data.Staff = NewTemplateUser(nil, staff)
+	data.Reason = reason
+
+	var message *discordgo.MessageSend
+	var err error
+	if configured != nil {
+		message, err = m.Localizer.Message(configKey, configured, data, locales...)
+		if err != nil {
+			lockdownLog.Warn("Could not render lockdown notice", "guild", guildID, "error", ErrorToStr(err))
+			return
+		}
+	} else {
+		message = &discordgo.MessageSend{
+			Content: m.Localizer.Text(catalogKey, data, locales...),
+		}
+	}
+
+	_, err = m.Discord.ChannelMessageSendComplex(channel.ID, message)
+	if err != nil {
+		lockdownLog.Warn("Could not post lockdown notice", "channel", channel.ID, "error", err)
+	}
+}
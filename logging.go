@@ -0,0 +1,376 @@
+// Leveled structured logging
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type LoggingConfig struct {
+	// debug, info, warn or error
+	Level string
+	// "console" for key=value lines, "json" for one JSON object per line
+	Format string
+	// Levels of single modules, e.g. { "automod": "debug" }
+	Modules map[string]string
+}
+
+func (c *LoggingConfig) Validate() error {
+	_, err := parseLogLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	if c.Format != "" && c.Format != "console" && c.Format != "json" {
+		return Errorf("Logging.Format must be console or json")
+	}
+	for module, level := range c.Modules {
+		_, err := parseLogLevel(level)
+		if err != nil {
+			return Errorf("Logging.Modules.%v: %v", module, err)
+		}
+	}
+	return nil
+}
+
+func parseLogLevel(text string) (slog.Level, error) {
+	switch strings.ToLower(text) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, Errorf("unknown log level %q", text)
+}
+
+type logSettings struct {
+	output       slog.Handler
+	level        slog.Level
+	moduleLevels map[string]slog.Level
+}
+
+var (
+	logLock sync.RWMutex
+	logging = newLogSettings(os.Stderr, "console", slog.LevelInfo, nil)
+)
+
+func newLogSettings(w io.Writer, format string, level slog.Level, moduleLevels map[string]slog.Level) *logSettings {
+	// The handler filters levels itself
+	options := &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}
+	var output slog.Handler
+	if format == "json" {
+		output = slog.NewJSONHandler(w, options)
+	} else {
+		output = slog.NewTextHandler(w, options)
+	}
+	return &logSettings{output: output, level: level, moduleLevels: moduleLevels}
+}
+
+// Applies the logging config, loggers created before pick it up too
+func SetupLogging(config *LoggingConfig) error {
+	if config == nil {
+		config = &LoggingConfig{}
+	}
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	level, _ := parseLogLevel(config.Level)
+	moduleLevels := map[string]slog.Level{}
+	for module, text := range config.Modules {
+		moduleLevels[module], _ = parseLogLevel(text)
+	}
+
+	logLock.Lock()
+	logging = newLogSettings(os.Stderr, config.Format, level, moduleLevels)
+	logLock.Unlock()
+	return nil
+}
+
+// Hands records to the configured output, filtered by the level of the
+// module. Looks the settings up on every record so that loggers can be
+// created at package level, before the config is loaded.
+type moduleHandler struct {
+	module string
+	// WithAttrs and WithGroup calls, replayed on the output
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) settings() *logSettings {
+	logLock.RLock()
+	defer logLock.RUnlock()
+	return logging
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	settings := h.settings()
+	minimum, ok := settings.moduleLevels[h.module]
+	if !ok {
+		minimum = settings.level
+	}
+	return level >= minimum
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, record slog.Record) error {
+	record = redactRecord(record)
+	output := h.settings().output
+	if h.module != "" {
+		output = output.WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	}
+	for _, wrap := range h.wrap {
+		output = wrap(output)
+	}
+	return output.Handle(ctx, record)
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) *moduleHandler {
+	return &moduleHandler{
+		module: h.module,
+		wrap:   append(append([]func(slog.Handler) slog.Handler{}, h.wrap...), wrap),
+	}
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(output slog.Handler) slog.Handler { return output.WithAttrs(redactAttrs(attrs)) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(output slog.Handler) slog.Handler { return output.WithGroup(name) })
+}
+
+var (
+	secretsLock sync.RWMutex
+	secrets     []string
+)
+
+// Replaces the secret with "[redacted]" in everything logged from now on,
+// e.g. the bot token. Short secrets are redacted too, even if that hits
+// unrelated text.
+func RedactSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	// Longest first, so a secret containing another one is redacted whole
+	index, _ := slices.BinarySearchFunc(secrets, secret, func(existing string, secret string) int {
+		return len(secret) - len(existing)
+	})
+	secrets = slices.Insert(secrets, index, secret)
+}
+
+func redact(text string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, "[redacted]")
+	}
+	return text
+}
+
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return redacted
+}
+
+// Errors and other values are formatted to find secrets in them
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redact(value.String()))
+	case slog.KindGroup:
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redactAttrs(value.Group())...)}
+	case slog.KindAny:
+		text := fmt.Sprint(value.Any())
+		if redacted := redact(text); redacted != text {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+func redactRecord(record slog.Record) slog.Record {
+	redacted := slog.NewRecord(record.Time, record.Level, redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return redacted
+}
+
+// Logger adding a module field, its level can be set in Logging.Modules
+func ModuleLogger(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module})
+}
+
+// Logger for code that doesn't belong to a module
+var Log = ModuleLogger("")
+
+// Messages of discordgo itself, redacted and filtered like the others
+var discordgoLog = ModuleLogger("discordgo")
+
+func init() {
+	discordgo.Logger = func(msgL int, caller int, format string, a ...any) {
+		level := slog.LevelDebug
+		switch msgL {
+		case discordgo.LogError:
+			level = slog.LevelError
+		case discordgo.LogWarning:
+			level = slog.LevelWarn
+		case discordgo.LogInformational:
+			level = slog.LevelInfo
+		}
+		if !discordgoLog.Enabled(context.Background(), level) {
+			return
+		}
+
+		// Attribute the message to the discordgo function that logged it
+		var pcs [1]uintptr
+		runtime.Callers(caller+2, pcs[:])
+		record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, a...), pcs[0])
+		discordgoLog.Handler().Handle(context.Background(), record)
+	}
+}
+
+// Fields identifying an interaction and who used it
+func InteractionAttrs(interaction *discordgo.Interaction) []any {
+	attrs := []any{"interaction", interaction.ID}
+	if interaction.GuildID != "" {
+		attrs = append(attrs, "guild", interaction.GuildID)
+	}
+	if interaction.Member != nil {
+		attrs = append(attrs, "user", interaction.Member.User.ID)
+	} else if interaction.User != nil {
+		attrs = append(attrs, "user", interaction.User.ID)
+	}
+	return attrs
+}
+
+// Guild of a gateway event, empty for DMs and events without a guild
+func eventGuildID(event any) string {
+	switch event := event.(type) {
+	case *discordgo.GuildMemberAdd:
+		return event.GuildID
+	case *discordgo.GuildMemberUpdate:
+		return event.GuildID
+	case *discordgo.GuildMemberRemove:
+		return event.GuildID
+	case *discordgo.MessageCreate:
+		return event.GuildID
+	case *discordgo.MessageUpdate:
+		return event.GuildID
+	case *discordgo.MessageDelete:
+		return event.GuildID
+	case *discordgo.MessageDeleteBulk:
+		return event.GuildID
+	}
+	return ""
+}
+
+// Fields identifying the guild, channel and user of a gateway event
+func eventAttrs(event any) []any {
+	attrs := []any{}
+	add := func(key string, value string) {
+		if value != "" {
+			attrs = append(attrs, key, value)
+		}
+	}
+
+	add("guild", eventGuildID(event))
+	switch event := event.(type) {
+	case *discordgo.GuildMemberAdd:
+		add("user", event.User.ID)
+	case *discordgo.GuildMemberUpdate:
+		add("user", event.User.ID)
+	case *discordgo.GuildMemberRemove:
+		add("user", event.User.ID)
+	case *discordgo.MessageCreate:
+		add("channel", event.ChannelID)
+		if event.Author != nil {
+			add("user", event.Author.ID)
+		}
+	case *discordgo.MessageUpdate:
+		add("channel", event.ChannelID)
+		if event.Author != nil {
+			add("user", event.Author.ID)
+		}
+	case *discordgo.MessageDelete:
+		add("channel", event.ChannelID)
+	case *discordgo.MessageDeleteBulk:
+		add("channel", event.ChannelID)
+	}
+	return attrs
+}
+
+// Module name of a handler method, e.g. "verification" for
+// (*VerificationModule).OnGuildMemberAdd. Empty for other functions.
+func handlerModule(handler any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	start := strings.Index(name, "(*")
+	end := strings.Index(name, "Module)")
+	if start < 0 || end < start {
+		return ""
+	}
+	return strings.ToLower(name[start+2 : end])
+}
+
+// Registers a module's gateway event handler, e.g.
+// HandleEvent(bot.Discord, m.OnGuildMemberAdd). Returned errors are logged
+// with the module and the guild and user of the event. Events of guilds the
+// module is disabled in and events arriving during shutdown are dropped.
+func HandleEvent[T any](discord *Discord, handler func(event T) error) {
+	module := handlerModule(handler)
+	discord.AddHandler(func(_ *discordgo.Session, event T) {
+		if !ModuleEnabledIn(module, eventGuildID(event)) {
+			return
+		}
+		discord.track(func() {
+			attrs := append(eventAttrs(event), "event", fmt.Sprintf("%T", event))
+			runHandler(module, attrs, func() error {
+				return handler(event)
+			})
+		})
+	})
+}
+
+// Runs an event or interaction handler, logging and counting errors and
+// panics. A panic would otherwise take the whole bot down.
+func runHandler(module string, attrs []any, handler func() error) {
+	log := ModuleLogger(module)
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			handlerPanics.Inc(module)
+			log.Error("Handler panicked", append(attrs, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))...)
+		}
+	}()
+
+	err := handler()
+	if err != nil {
+		handlerErrors.Inc(module)
+		log.Error("Handler failed", append(attrs, "error", ErrorToStr(err))...)
+	}
+}
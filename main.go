@@ -3,51 +3,88 @@
 package main
 
 import (
-	"encoding/json"
-	"github.com/tailscale/hujson"
+	"flag"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func main() {
-	config, err := ParseConfig()
+	defaultPath := os.Getenv(envConfigPath)
+	if defaultPath == "" {
+		defaultPath = "config.jsonc"
+	}
+	configPath := flag.String("config", defaultPath, "path of the config file, also settable with "+envConfigPath)
+	flag.Parse()
+
+	config, err := ParseConfig(*configPath)
 	if err != nil {
-		Logf("Error: Parsing config failed: %v", ErrorToStr(err))
+		Log.Error("Parsing config failed", "error", ErrorToStr(err))
+		os.Exit(1)
+	}
+
+	err = SetupLogging(config.Logging)
+	if err != nil {
+		Log.Error("Setting up logging failed", "error", ErrorToStr(err))
 		os.Exit(1)
 	}
 
 	bot, err := NewBot(config)
 	if err != nil {
-		Logf("Error: Bot initialization failed: %v", ErrorToStr(err))
+		Log.Error("Bot initialization failed", "error", ErrorToStr(err))
 		os.Exit(1)
 	}
 
 	err = bot.Run()
 	if err != nil {
-		Logf("Error: Bot run failed: %v", ErrorToStr(err))
+		Log.Error("Bot run failed", "error", ErrorToStr(err))
 		os.Exit(1)
 	}
 }
 
-func ParseConfig() (*Config, error) {
-	jsonBytes, err := os.ReadFile(filepath.FromSlash("./config.jsonc"))
+// Reads the config file and applies FBOT_ environment variables on top
+func ParseConfig(path string) (*Config, error) {
+	jsonBytes, err := os.ReadFile(filepath.FromSlash(path))
 	if err != nil {
 		return nil, WrapError(err)
 	}
 
 	config := &Config{}
+	err = UnmarshalJSONC(jsonBytes, config)
+	if err != nil {
+		return nil, err
+	}
 
-	ast, err := hujson.Parse(jsonBytes)
+	err = ApplyEnvOverrides(config, os.Environ())
 	if err != nil {
-		return nil, WrapError(err)
+		return nil, err
 	}
-	ast.Standardize()
-	jsonBytes = ast.Pack()
+	RedactSecret(config.DiscordToken)
+	RedactSecret(config.DbConnectionString)
+	RedactSecret(dbPassword(config.DbConnectionString))
 
-	err = json.Unmarshal(jsonBytes, config)
+	err = config.Validate()
 	if err != nil {
 		return nil, WrapError(err)
 	}
 
 	return config, nil
 }
+
+// Password of a postgres:// URL or key=value connection string
+func dbPassword(connectionString string) string {
+	parsed, err := url.Parse(connectionString)
+	if err == nil && parsed.User != nil {
+		password, _ := parsed.User.Password()
+		return password
+	}
+
+	for _, field := range strings.Fields(connectionString) {
+		key, value, _ := strings.Cut(field, "=")
+		if key == "password" {
+			return strings.Trim(value, "'")
+		}
+	}
+	return ""
+}
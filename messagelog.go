@@ -0,0 +1,797 @@
+// Message log module
+
+package main
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type MessageLogEvent string
+
+const (
+	MessageLogEdit       MessageLogEvent = "edit"
+	MessageLogDelete     MessageLogEvent = "delete"
+	MessageLogBulkDelete MessageLogEvent = "bulk_delete"
+	MessageLogNickname   MessageLogEvent = "nickname"
+	MessageLogRoles      MessageLogEvent = "roles"
+	MessageLogJoin       MessageLogEvent = "join"
+	MessageLogLeave      MessageLogEvent = "leave"
+)
+
+var messageLogEvents = []MessageLogEvent{
+	MessageLogEdit, MessageLogDelete, MessageLogBulkDelete, MessageLogNickname, MessageLogRoles, MessageLogJoin, MessageLogLeave,
+}
+
+type MessageLogConfig struct {
+	// Where events get logged unless Channels says otherwise
+	LogChannel string
+	// Overrides LogChannel per event, an empty channel turns the event off
+	Channels map[MessageLogEvent]string
+	// Messages in these channels are neither cached nor logged
+	IgnoredChannels []string
+
+	// How many messages are kept in memory to log edits and deletes,
+	// defaults to 10000
+	CacheSize int
+	// Also store messages in the database so older messages and messages
+	// from before a restart can be logged. Requires DbConnectionString.
+	Persist bool
+	// How long stored messages are kept, defaults to 7 days
+	Retention Duration
+	// Also log deletes of messages that aren't known, e.g. from before the
+	// bot started. Their author is unknown, so they may be bot messages.
+	LogUnknownDeletes bool
+}
+
+func (c *MessageLogConfig) Validate() error {
+	for event := range c.Channels {
+		if !slices.Contains(messageLogEvents, event) {
+			return Errorf("MessageLog.Channels: unknown event %q", event)
+		}
+	}
+	if c.CacheSize < 0 {
+		return Errorf("MessageLog.CacheSize must be positive")
+	}
+	if c.Retention < 0 {
+		return Errorf("MessageLog.Retention can't be negative")
+	}
+	return nil
+}
+
+// Returns the channel to log the event to, or "" if it's turned off
+func (c *MessageLogConfig) Channel(event MessageLogEvent) string {
+	channelID, ok := c.Channels[event]
+	if ok {
+		return channelID
+	}
+	return c.LogChannel
+}
+
+type LoggedMessage struct {
+	ID          string
+	GuildID     string
+	ChannelID   string
+	AuthorID    string
+	AuthorName  string
+	Content     string
+	Attachments []string
+	CreatedAt   time.Time
+}
+
+// Least recently used message cache with a fixed capacity
+type messageCache struct {
+	capacity int
+	order    *list.List
+	messages map[string]*list.Element
+	lock     sync.Mutex
+}
+
+func newMessageCache(capacity int) *messageCache {
+	return &messageCache{
+		capacity: capacity,
+		order:    list.New(),
+		messages: map[string]*list.Element{},
+	}
+}
+
+func (c *messageCache) Put(message *LoggedMessage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.messages[message.ID]; ok {
+		element.Value = message
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.messages[message.ID] = c.order.PushFront(message)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.messages, oldest.Value.(*LoggedMessage).ID)
+	}
+}
+
+func (c *messageCache) Get(messageID string) *LoggedMessage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.messages[messageID]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*LoggedMessage)
+}
+
+func (c *messageCache) Remove(messageID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.messages[messageID]
+	if !ok {
+		return
+	}
+	c.order.Remove(element)
+	delete(c.messages, messageID)
+}
+
+var messagelogLog = ModuleLogger("messagelog")
+
+type MessageLogModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *MessageLogConfig
+
+	cache *messageCache
+	// Config.Retention with its default
+	retention time.Duration
+}
+
+const messageLogSchema = `
+CREATE TABLE IF NOT EXISTS message_log (
+	message_id  TEXT PRIMARY KEY,
+	guild_id    TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	author_id   TEXT NOT NULL,
+	author_name TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	attachments TEXT NOT NULL DEFAULT '',
+	created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_log_created_at ON message_log (created_at);
+`
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "messagelog",
+		Intents: discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent | discordgo.IntentsGuildMembers,
+	}, func(config *Config) *MessageLogConfig {
+		return config.MessageLog
+	}, NewMessageLogModule)
+}
+
+func NewMessageLogModule(config *MessageLogConfig) *MessageLogModule {
+	m := &MessageLogModule{
+		Config:    config,
+		retention: time.Duration(config.Retention),
+	}
+
+	cacheSize := config.CacheSize
+	if cacheSize == 0 {
+		cacheSize = 10000
+	}
+	m.cache = newMessageCache(cacheSize)
+	if m.retention == 0 {
+		m.retention = 7 * 24 * time.Hour
+	}
+
+	return m
+}
+
+func (m *MessageLogModule) Register(bot *Bot) error {
+	messagelogLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.Localizer = bot.Localizer
+
+	if m.Config.Persist {
+		if bot.DB == nil {
+			return Errorf("message log persistence requires DbConnectionString")
+		}
+		m.DB = bot.DB
+
+		_, err := m.DB.Exec(messageLogSchema)
+		if err != nil {
+			return WrapError(err)
+		}
+		bot.Scheduler.Every("messagelog.prune", time.Hour, m.pruneStoredMessages)
+	}
+
+	HandleEvent(bot.Discord, m.OnMessageCreate)
+	HandleEvent(bot.Discord, m.OnMessageUpdate)
+	HandleEvent(bot.Discord, m.OnMessageDelete)
+	HandleEvent(bot.Discord, m.OnMessageDeleteBulk)
+	HandleEvent(bot.Discord, m.OnGuildMemberUpdate)
+	HandleEvent(bot.Discord, m.OnGuildMemberAdd)
+	HandleEvent(bot.Discord, m.OnGuildMemberRemove)
+
+	return nil
+}
+
+func (m *MessageLogModule) ignored(message *discordgo.Message) bool {
+	return message.GuildID == "" || slices.Contains(m.Config.IgnoredChannels, message.ChannelID)
+}
+
+func (m *MessageLogModule) OnMessageCreate(event *discordgo.MessageCreate) error {
+	if m.ignored(event.Message) || event.Author == nil || event.Author.Bot {
+		return nil
+	}
+
+	message := &LoggedMessage{
+		ID:         event.ID,
+		GuildID:    event.GuildID,
+		ChannelID:  event.ChannelID,
+		AuthorID:   event.Author.ID,
+		AuthorName: event.Author.Username,
+		Content:    event.Content,
+		CreatedAt:  event.Timestamp,
+	}
+	for _, attachment := range event.Attachments {
+		message.Attachments = append(message.Attachments, attachment.URL)
+	}
+
+	m.cache.Put(message)
+	return m.storeMessage(message)
+}
+
+func (m *MessageLogModule) storeMessage(message *LoggedMessage) error {
+	if m.DB == nil {
+		return nil
+	}
+
+	_, err := m.DB.Exec(`
+		INSERT INTO message_log (message_id, guild_id, channel_id, author_id, author_name, content, attachments, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (message_id) DO UPDATE SET content = $6, attachments = $7`,
+		message.ID, message.GuildID, message.ChannelID, message.AuthorID, message.AuthorName,
+		message.Content, strings.Join(message.Attachments, "\n"), message.CreatedAt)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Looks the message up in memory, then in the database. Returns nil if the
+// message isn't known.
+func (m *MessageLogModule) GetMessage(messageID string) (*LoggedMessage, error) {
+	message := m.cache.Get(messageID)
+	if message != nil || m.DB == nil {
+		return message, nil
+	}
+
+	message = &LoggedMessage{ID: messageID}
+	var attachments string
+	err := m.DB.QueryRow(`
+		SELECT guild_id, channel_id, author_id, author_name, content, attachments, created_at
+		FROM message_log WHERE message_id = $1`,
+		messageID,
+	).Scan(&message.GuildID, &message.ChannelID, &message.AuthorID, &message.AuthorName, &message.Content, &attachments, &message.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if attachments != "" {
+		message.Attachments = strings.Split(attachments, "\n")
+	}
+	return message, nil
+}
+
+func (m *MessageLogModule) forgetMessages(messageIDs ...string) error {
+	for _, messageID := range messageIDs {
+		m.cache.Remove(messageID)
+	}
+	if m.DB == nil {
+		return nil
+	}
+
+	_, err := m.DB.Exec(`DELETE FROM message_log WHERE message_id = ANY(string_to_array($1, ','))`, strings.Join(messageIDs, ","))
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) pruneStoredMessages(now time.Time) error {
+	_, err := m.DB.Exec(`DELETE FROM message_log WHERE created_at < $1`, now.Add(-m.retention))
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnMessageUpdate(event *discordgo.MessageUpdate) error {
+	if m.ignored(event.Message) {
+		return nil
+	}
+
+	before, err := m.GetMessage(event.ID)
+	if err != nil {
+		return err
+	}
+	// Link previews also cause updates, only content changes are interesting
+	if before == nil || before.Content == event.Content {
+		return nil
+	}
+
+	after := *before
+	after.Content = event.Content
+	m.cache.Put(&after)
+	err = m.storeMessage(&after)
+	if err != nil {
+		return err
+	}
+
+	channelID := m.Config.Channel(MessageLogEdit)
+	if channelID == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(event.GuildID))
+	data := NewTemplateData()
+	data.URL = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", event.GuildID, event.ChannelID, event.ID)
+
+	embed := m.messageEmbed(before, locales)
+	embed.Title = m.Localizer.Text("messagelog.edit_title", nil, locales...)
+	embed.Description = m.Localizer.Text("messagelog.jump", data, locales...)
+	embed.Color = ColorBlue
+	embed.Fields = append(embed.Fields,
+		&discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.before", nil, locales...),
+			Value: truncateField(before.Content),
+		},
+		&discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.after", nil, locales...),
+			Value: truncateField(after.Content),
+		},
+	)
+	// Before and after are enough to see what changed in long rewrites
+	diff, ok := WordDiff(before.Content, after.Content)
+	if ok {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.changes", nil, locales...),
+			Value: truncateField(diff),
+		})
+	}
+
+	_, err = m.Discord.ChannelMessageSendEmbed(channelID, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnMessageDelete(event *discordgo.MessageDelete) error {
+	if m.ignored(event.Message) {
+		return nil
+	}
+
+	message, err := m.GetMessage(event.ID)
+	if err != nil {
+		return err
+	}
+	err = m.forgetMessages(event.ID)
+	if err != nil {
+		return err
+	}
+
+	channelID := m.Config.Channel(MessageLogDelete)
+	if channelID == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(event.GuildID))
+	embed := &discordgo.MessageEmbed{
+		Type:      discordgo.EmbedTypeRich,
+		Title:     m.Localizer.Text("messagelog.delete_title", nil, locales...),
+		Color:     ColorRed,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if message == nil {
+		// Too old, sent before the bot started, or sent by a bot as those
+		// aren't stored
+		if !m.Config.LogUnknownDeletes || (event.BeforeDelete != nil && event.BeforeDelete.Author != nil && event.BeforeDelete.Author.Bot) {
+			return nil
+		}
+		data := NewTemplateData()
+		data.Channel = "<#" + event.ChannelID + ">"
+		embed.Description = m.Localizer.Text("messagelog.unknown_message", data, locales...)
+	} else {
+		embed = m.messageEmbed(message, locales)
+		embed.Title = m.Localizer.Text("messagelog.delete_title", nil, locales...)
+		embed.Color = ColorRed
+		embed.Description = truncateField(message.Content)
+		if len(message.Attachments) > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:  m.Localizer.Text("messagelog.field.attachments", nil, locales...),
+				Value: truncateField(strings.Join(message.Attachments, "\n")),
+			})
+		}
+	}
+
+	_, err = m.Discord.ChannelMessageSendEmbed(channelID, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnMessageDeleteBulk(event *discordgo.MessageDeleteBulk) error {
+	if event.GuildID == "" || slices.Contains(m.Config.IgnoredChannels, event.ChannelID) {
+		return nil
+	}
+
+	// Oldest first, like the channel showed them
+	transcript := []string{}
+	for i := len(event.Messages) - 1; i >= 0; i-- {
+		message, err := m.GetMessage(event.Messages[i])
+		if err != nil {
+			return err
+		}
+		if message == nil {
+			transcript = append(transcript, fmt.Sprintf("[unknown message %s]", event.Messages[i]))
+			continue
+		}
+
+		line := fmt.Sprintf("[%s] %s (%s): %s", message.CreatedAt.UTC().Format(time.DateTime), message.AuthorName, message.AuthorID, message.Content)
+		for _, attachment := range message.Attachments {
+			line += "\n    " + attachment
+		}
+		transcript = append(transcript, line)
+	}
+
+	err := m.forgetMessages(event.Messages...)
+	if err != nil {
+		return err
+	}
+
+	channelID := m.Config.Channel(MessageLogBulkDelete)
+	if channelID == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(event.GuildID))
+	data := NewTemplateData()
+	data.Count = len(event.Messages)
+	data.Channel = "<#" + event.ChannelID + ">"
+
+	_, err = m.Discord.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Type:        discordgo.EmbedTypeRich,
+				Title:       m.Localizer.Text("messagelog.bulk_delete_title", data, locales...),
+				Description: m.Localizer.Text("messagelog.bulk_delete_description", data, locales...),
+				Color:       ColorRed,
+				Timestamp:   time.Now().Format(time.RFC3339),
+			},
+		},
+		Files: []*discordgo.File{
+			{
+				Name:        "deleted-messages-" + event.ChannelID + ".txt",
+				ContentType: "text/plain",
+				Reader:      strings.NewReader(strings.Join(transcript, "\n")),
+			},
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnGuildMemberUpdate(event *discordgo.GuildMemberUpdate) error {
+	// Without the previous state there is nothing to compare with
+	if event.BeforeUpdate == nil {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(event.GuildID))
+	before, after := event.BeforeUpdate, event.Member
+
+	nicknameChannel := m.Config.Channel(MessageLogNickname)
+	if nicknameChannel != "" && before.Nick != after.Nick {
+		embed := m.memberEmbed(after, locales)
+		embed.Title = m.Localizer.Text("messagelog.nickname_title", nil, locales...)
+		embed.Color = ColorBlue
+		embed.Fields = append(embed.Fields,
+			&discordgo.MessageEmbedField{
+				Name:   m.Localizer.Text("messagelog.field.before", nil, locales...),
+				Value:  nicknameOrNone(before.Nick),
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   m.Localizer.Text("messagelog.field.after", nil, locales...),
+				Value:  nicknameOrNone(after.Nick),
+				Inline: true,
+			},
+		)
+
+		_, err := m.Discord.ChannelMessageSendEmbed(nicknameChannel, embed)
+		if err != nil {
+			return WrapError(err)
+		}
+	}
+
+	rolesChannel := m.Config.Channel(MessageLogRoles)
+	if rolesChannel == "" {
+		return nil
+	}
+
+	added, removed := []string{}, []string{}
+	for _, roleID := range after.Roles {
+		if !slices.Contains(before.Roles, roleID) {
+			added = append(added, "<@&"+roleID+">")
+		}
+	}
+	for _, roleID := range before.Roles {
+		if !slices.Contains(after.Roles, roleID) {
+			removed = append(removed, "<@&"+roleID+">")
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	embed := m.memberEmbed(after, locales)
+	embed.Title = m.Localizer.Text("messagelog.roles_title", nil, locales...)
+	embed.Color = ColorBlue
+	if len(added) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.roles_added", nil, locales...),
+			Value: truncateField(strings.Join(added, " ")),
+		})
+	}
+	if len(removed) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.roles_removed", nil, locales...),
+			Value: truncateField(strings.Join(removed, " ")),
+		})
+	}
+
+	_, err := m.Discord.ChannelMessageSendEmbed(rolesChannel, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnGuildMemberAdd(event *discordgo.GuildMemberAdd) error {
+	channelID := m.Config.Channel(MessageLogJoin)
+	if channelID == "" {
+		return nil
+	}
+
+	guild := m.Discord.CachedGuild(event.GuildID)
+	locales := GuildLocales(guild)
+
+	data := NewTemplateData()
+	data.User = NewTemplateUser(event.User, event.Member)
+	if guild != nil {
+		data.Count = guild.MemberCount
+	}
+
+	embed := m.memberEmbed(event.Member, locales)
+	embed.Title = m.Localizer.Text("messagelog.join_title", nil, locales...)
+	embed.Description = m.Localizer.Text("messagelog.join_description", data, locales...)
+	embed.Color = ColorGreen
+
+	_, err := m.Discord.ChannelMessageSendEmbed(channelID, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) OnGuildMemberRemove(event *discordgo.GuildMemberRemove) error {
+	channelID := m.Config.Channel(MessageLogLeave)
+	if channelID == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(event.GuildID))
+	data := NewTemplateData()
+	data.User = NewTemplateUser(event.User, event.Member)
+
+	embed := m.memberEmbed(event.Member, locales)
+	embed.Title = m.Localizer.Text("messagelog.leave_title", nil, locales...)
+	embed.Description = m.Localizer.Text("messagelog.leave_description", data, locales...)
+	embed.Color = ColorDarkOrange
+
+	_, err := m.Discord.ChannelMessageSendEmbed(channelID, embed)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *MessageLogModule) messageEmbed(message *LoggedMessage, locales []discordgo.Locale) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Type: discordgo.EmbedTypeRich,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: message.AuthorName,
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   m.Localizer.Text("moderation.field.user", nil, locales...),
+				Value:  fmt.Sprintf("<@%s> (%s)", message.AuthorID, message.AuthorID),
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("automod.field.channel", nil, locales...),
+				Value:  "<#" + message.ChannelID + ">",
+				Inline: true,
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
+
+func (m *MessageLogModule) memberEmbed(member *discordgo.Member, locales []discordgo.Locale) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Type: discordgo.EmbedTypeRich,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    member.User.Username,
+			IconURL: member.User.AvatarURL(""),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  m.Localizer.Text("moderation.field.user", nil, locales...),
+				Value: fmt.Sprintf("<@%s> (%s)", member.User.ID, member.User.ID),
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
+
+func nicknameOrNone(nick string) string {
+	if nick == "" {
+		return "-"
+	}
+	return nick
+}
+
+// Embed field values are limited to 1024 characters and can't be empty
+func truncateField(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return truncateText(text, 1000)
+}
+
+// Words WordDiff compares at most per message once the unchanged start and
+// end are cut off. The comparison takes memory for the product of both.
+const wordDiffMaxWords = 300
+
+var diffWordPattern = regexp.MustCompile(`(\s*)(\S+)`)
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "|", `\|`, "`", "\\`")
+
+type diffWord struct {
+	// Whitespace before the word, so line breaks stay
+	space string
+	text  string
+}
+
+func splitDiffWords(text string) []diffWord {
+	words := []diffWord{}
+	for _, match := range diffWordPattern.FindAllStringSubmatch(strings.TrimSpace(text), -1) {
+		words = append(words, diffWord{space: match[1], text: match[2]})
+	}
+	return words
+}
+
+// Marks removed words as ~~struck through~~ and added words as __underlined__.
+// Returns false if too many words changed to compare them.
+func WordDiff(before string, after string) (string, bool) {
+	a, b := splitDiffWords(before), splitDiffWords(after)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix].text == b[prefix].text {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix].text == b[len(b)-1-suffix].text {
+		suffix++
+	}
+	changedA, changedB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(changedA) > wordDiffMaxWords || len(changedB) > wordDiffMaxWords {
+		return "", false
+	}
+
+	// Longest common subsequence table of the changed part
+	lengths := make([][]int, len(changedA)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(changedB)+1)
+	}
+	for i := len(changedA) - 1; i >= 0; i-- {
+		for j := len(changedB) - 1; j >= 0; j-- {
+			if changedA[i].text == changedB[j].text {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	diff := &strings.Builder{}
+	// The space before the first word stays outside the markers, Discord
+	// doesn't format "~~ word~~"
+	write := func(space string, words []diffWord, marker string) {
+		if space == "" && diff.Len() > 0 {
+			space = " "
+		}
+		diff.WriteString(space)
+		diff.WriteString(marker)
+		for i, word := range words {
+			if i > 0 {
+				diff.WriteString(word.space)
+			}
+			diff.WriteString(markdownEscaper.Replace(word.text))
+		}
+		diff.WriteString(marker)
+	}
+
+	removed, added := []diffWord{}, []diffWord{}
+	flush := func() {
+		if len(removed) > 0 {
+			write(removed[0].space, removed, "~~")
+		}
+		if len(added) > 0 {
+			space := added[0].space
+			if len(removed) > 0 {
+				space = " "
+			}
+			write(space, added, "__")
+		}
+		removed, added = removed[:0], added[:0]
+	}
+
+	for _, word := range b[:prefix] {
+		write(word.space, []diffWord{word}, "")
+	}
+	i, j := 0, 0
+	for i < len(changedA) || j < len(changedB) {
+		switch {
+		case i < len(changedA) && j < len(changedB) && changedA[i].text == changedB[j].text:
+			flush()
+			write(changedB[j].space, []diffWord{changedB[j]}, "")
+			i++
+			j++
+		case j == len(changedB) || (i < len(changedA) && lengths[i+1][j] >= lengths[i][j+1]):
+			removed = append(removed, changedA[i])
+			i++
+		default:
+			added = append(added, changedB[j])
+			j++
+		}
+	}
+	flush()
+	for _, word := range b[len(b)-suffix:] {
+		write(word.space, []diffWord{word}, "")
+	}
+
+	return diff.String(), true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{"unchanged", "hello there", "hello there", "hello there"},
+		{"replaced word", "the quick fox", "the slow fox", "the ~~quick~~ __slow__ fox"},
+		{"added at the end", "hello", "hello world", "hello __world__"},
+		{"removed at the start", "well hello", "hello", "~~well~~ hello"},
+		{"several words", "a b c d", "a x y d", "a ~~b c~~ __x y__ d"},
+		{"line breaks stay", "first line\nsecond line", "first line\nthird line", "first line\n~~second~~ __third__ line"},
+		{"line break inside a change", "one\ntwo", "uno\ndos", "~~one\ntwo~~ __uno\ndos__"},
+		{"markdown is escaped", "~~old~~ text", "__new__ *text*", "~~\\~\\~old\\~\\~ text~~ __\\_\\_new\\_\\_ \\*text\\*__"},
+		{"from empty", "", "new", "__new__"},
+		{"to empty", "old", "", "~~old~~"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff, ok := WordDiff(test.before, test.after)
+			if !ok {
+				t.Fatal("diff was refused")
+			}
+			if diff != test.want {
+				t.Fatalf("WordDiff(%q, %q) = %q, want %q", test.before, test.after, diff, test.want)
+			}
+		})
+	}
+}
+
+func TestWordDiffLongMessages(t *testing.T) {
+	words := func(word string, count int) string {
+		return strings.TrimSpace(strings.Repeat(word+" ", count))
+	}
+
+	// Only the changed middle counts towards the limit
+	before := words("same", 1000) + " old " + words("same", 1000)
+	after := words("same", 1000) + " new " + words("same", 1000)
+	diff, ok := WordDiff(before, after)
+	if !ok {
+		t.Fatal("a one word change in a long message was refused")
+	}
+	if !strings.Contains(diff, "same ~~old~~ __new__ same") {
+		t.Fatalf("unexpected diff %q", diff)
+	}
+
+	_, ok = WordDiff(words("a", 2000), words("b", 2000))
+	if ok {
+		t.Fatal("a rewrite of 2000 words was compared")
+	}
+}
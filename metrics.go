@@ -0,0 +1,278 @@
+// Prometheus metrics
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type MetricsConfig struct {
+	// Address to serve metrics on, e.g. ":9100"
+	Listen string
+	// Defaults to /metrics
+	Path string
+}
+
+func (c *MetricsConfig) Validate() error {
+	if c.Listen == "" {
+		return Errorf("Metrics.Listen is required")
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return Errorf("Metrics.Path must start with /")
+	}
+	return nil
+}
+
+// Metrics in the Prometheus text format. Collected all the time, the
+// endpoint just exposes them.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+type MetricsRegistry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+var Metrics = &MetricsRegistry{}
+
+// Adds a metric, replacing one with the same name
+func (r *MetricsRegistry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, existing := range r.metrics {
+		if existing.name() == m.name() {
+			r.metrics[i] = m
+			return
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *MetricsRegistry) Write(w io.Writer) {
+	r.lock.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.lock.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+type metricDesc struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (d *metricDesc) name() string {
+	return d.metricName
+}
+
+func (d *metricDesc) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, strings.ReplaceAll(d.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, metricType)
+}
+
+// Joins label values into a map key
+func labelKey(d *metricDesc, values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %v takes labels %v, got %v", d.metricName, d.labels, values))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Formats {a="1",b="2"}, with extra label pairs appended
+func labelString(d *metricDesc, key string, extra ...string) string {
+	pairs := []string{}
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+"="+quoteLabel(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type CounterVec struct {
+	metricDesc
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func NewCounter(name string, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{
+		metricDesc: metricDesc{metricName: name, help: help, labels: labels},
+		values:     map[string]float64{},
+	}
+	Metrics.register(counter)
+	return counter
+}
+
+// Label values in the order the labels were declared
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := labelKey(&c.metricDesc, labelValues)
+	c.lock.Lock()
+	c.values[key] += value
+	c.lock.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.writeHeader(w, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metricName)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(&c.metricDesc, key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge read when metrics are scraped
+type GaugeFunc struct {
+	metricDesc
+	value func() float64
+}
+
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{metricDesc: metricDesc{metricName: name, help: help}, value: value}
+	Metrics.register(gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.value()))
+}
+
+type histogramValue struct {
+	// Not cumulative, summed up when written
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	metricDesc
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogramValue
+}
+
+// Buckets are upper bounds in ascending order
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	histogram := &HistogramVec{
+		metricDesc: metricDesc{metricName: name, help: help, labels: labels},
+		buckets:    buckets,
+		values:     map[string]*histogramValue{},
+	}
+	Metrics.register(histogram)
+	return histogram
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(&h.metricDesc, labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+			break
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(&h.metricDesc, key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(&h.metricDesc, key, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(&h.metricDesc, key), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(&h.metricDesc, key), v.count)
+	}
+}
+
+// Bucket bounds in seconds for API calls and handlers
+var latencyBuckets = []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	gatewayEvents = NewCounter("fbot_gateway_events_total",
+		"Gateway events received, by event type.", "type")
+	gatewayReconnects = NewCounter("fbot_gateway_reconnects_total",
+		"Gateway reconnects and resumes.")
+	handlerErrors = NewCounter("fbot_handler_errors_total",
+		"Event and interaction handlers that returned an error, by module.", "module")
+	handlerPanics = NewCounter("fbot_handler_panics_total",
+		"Event and interaction handlers that panicked, by module.", "module")
+	restRequests = NewCounter("fbot_rest_requests_total",
+		"Discord REST API requests, by method, route and status code.", "method", "route", "status")
+	restDuration = NewHistogram("fbot_rest_request_duration_seconds",
+		"Latency of Discord REST API requests, by method and route.", latencyBuckets, "method", "route")
+	restRateLimits = NewCounter("fbot_rest_rate_limits_total",
+		"Discord REST API requests that hit a rate limit, by route.", "route")
+)
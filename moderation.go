@@ -0,0 +1,1010 @@
+// Moderation module
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type ModerationConfig struct {
+	// Channel where every case gets logged
+	LogChannel string
+	// DM sent to the target of a warn, timeout, kick or ban. Defaults to the
+	// "moderation.dm" catalog message.
+	DmMessage *MessageTemplate
+	// Punishments applied automatically once a member collects warnings
+	Escalation *EscalationConfig
+}
+
+type EscalationConfig struct {
+	// Warnings older than this no longer count towards any rule. Zero keeps
+	// warnings forever.
+	WarningDecay Duration
+	Rules        []EscalationRule
+}
+
+// Action is applied when a member's active warnings within Within reach
+// Warnings. Further warnings don't apply it again, only reaching the
+// threshold of another rule does.
+type EscalationRule struct {
+	Warnings int
+	// Defaults to WarningDecay, and can't be longer
+	Within Duration
+	// timeout, kick or ban
+	Action ModerationActionType
+	// Required for timeouts, bans with a Duration are lifted after it
+	Duration Duration
+}
+
+func (c *ModerationConfig) Validate() error {
+	if c.DmMessage != nil {
+		err := c.DmMessage.Validate("Moderation.DmMessage")
+		if err != nil {
+			return Errorf("Moderation.DmMessage: %w", err)
+		}
+	}
+
+	if c.Escalation != nil {
+		for i, rule := range c.Escalation.Rules {
+			if rule.Warnings < 1 {
+				return Errorf("Moderation.Escalation.Rules.%d: Warnings must be at least 1", i)
+			}
+			switch rule.Action {
+			case ActionTimeout:
+				if rule.Duration <= 0 || time.Duration(rule.Duration) > MaxTimeout {
+					return Errorf("Moderation.Escalation.Rules.%d: Duration must be between 1s and 28d", i)
+				}
+			case ActionKick:
+				if rule.Duration != 0 {
+					return Errorf("Moderation.Escalation.Rules.%d: kicks don't take a Duration", i)
+				}
+			case ActionBan:
+				if rule.Duration < 0 {
+					return Errorf("Moderation.Escalation.Rules.%d: Duration can't be negative", i)
+				}
+			default:
+				return Errorf("Moderation.Escalation.Rules.%d: unknown action %q", i, rule.Action)
+			}
+		}
+	}
+
+	return nil
+}
+
+type ModerationActionType string
+
+const (
+	ActionWarn    ModerationActionType = "warn"
+	ActionTimeout ModerationActionType = "timeout"
+	ActionKick    ModerationActionType = "kick"
+	ActionBan     ModerationActionType = "ban"
+	ActionUnban   ModerationActionType = "unban"
+)
+
+// Longest timeout Discord allows
+const MaxTimeout = 28 * 24 * time.Hour
+
+type ModerationAction struct {
+	GuildID     string
+	Type        ModerationActionType
+	TargetID    string
+	ModeratorID string
+	Reason      string
+	// Timeouts, and bans that are lifted after it
+	Duration time.Duration
+	// Bans only
+	DeleteMessageDays int
+}
+
+type ModerationCase struct {
+	GuildID      string
+	Number       int
+	Action       ModerationActionType
+	TargetID     string
+	ModeratorID  string
+	Reason       string
+	Duration     time.Duration
+	LogMessageID string
+	CreatedAt    time.Time
+
+	// Automatic follow-up action, if the case triggered an escalation rule
+	Escalation *ModerationCase
+}
+
+var moderationLog = ModuleLogger("moderation")
+
+type ModerationModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Jobs      *JobQueue
+	Localizer *Localizer
+	Config    *ModerationConfig
+
+	// Escalation rules with the highest thresholds first
+	escalationRules []EscalationRule
+}
+
+// Payload of the job lifting a temporary ban
+type banExpiry struct {
+	GuildID    string
+	UserID     string
+	CaseNumber int
+}
+
+const moderationSchema = `
+CREATE TABLE IF NOT EXISTS moderation_case_counters (
+	guild_id  TEXT PRIMARY KEY,
+	last_case INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS moderation_cases (
+	guild_id         TEXT NOT NULL,
+	case_number      INTEGER NOT NULL,
+	action           TEXT NOT NULL,
+	target_id        TEXT NOT NULL,
+	moderator_id     TEXT NOT NULL,
+	reason           TEXT NOT NULL DEFAULT '',
+	duration_seconds BIGINT NOT NULL DEFAULT 0,
+	log_message_id   TEXT NOT NULL DEFAULT '',
+	-- Job lifting a temporary ban, until it runs or is cancelled
+	unban_job_id     BIGINT,
+	created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (guild_id, case_number)
+);
+
+ALTER TABLE moderation_cases ADD COLUMN IF NOT EXISTS unban_job_id BIGINT;
+
+CREATE INDEX IF NOT EXISTS moderation_cases_target ON moderation_cases (guild_id, target_id, created_at);
+`
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "moderation", NeedsDB: true}, func(config *Config) *ModerationConfig {
+		return config.Moderation
+	}, NewModerationModule)
+}
+
+func NewModerationModule(config *ModerationConfig) *ModerationModule {
+	m := &ModerationModule{
+		Config: config,
+	}
+
+	// Highest thresholds first, the first matching rule wins. The config
+	// itself keeps its order.
+	if config.Escalation != nil {
+		m.escalationRules = slices.Clone(config.Escalation.Rules)
+		sort.SliceStable(m.escalationRules, func(i, j int) bool {
+			return m.escalationRules[i].Warnings > m.escalationRules[j].Warnings
+		})
+	}
+
+	return m
+}
+
+func (m *ModerationModule) Register(bot *Bot) error {
+	moderationLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Jobs = bot.Jobs
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(moderationSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	// Options are built fresh for every command as localization fills them in
+	// per command
+	userOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "Member to act on",
+			Required:    true,
+		}
+	}
+	reasonOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "reason",
+			Description: "Reason, shown to the member",
+			MaxLength:   1000,
+		}
+	}
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "warn",
+		Description:              "Warn a member",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionModerateMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			userOption(),
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "reason",
+				Description: "Reason, shown to the member",
+				Required:    true,
+				MaxLength:   1000,
+			},
+		},
+	}, m.actionCommandHandler(ActionWarn))
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "timeout",
+		Description:              "Time out a member",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionModerateMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			userOption(),
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "duration",
+				Description: "For how long, e.g. 10m, 2h or 1d",
+				Required:    true,
+			},
+			reasonOption(),
+		},
+	}, m.actionCommandHandler(ActionTimeout))
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "kick",
+		Description:              "Kick a member",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionKickMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			userOption(),
+			reasonOption(),
+		},
+	}, m.actionCommandHandler(ActionKick))
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "ban",
+		Description:              "Ban a user",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionBanMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			userOption(),
+			reasonOption(),
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "delete_messages",
+				Description: "Delete messages of the last days",
+				MinValue:    Ptr(0.0),
+				MaxValue:    7,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "duration",
+				Description: `Lift the ban after this time, like "7d"`,
+			},
+		},
+	}, m.actionCommandHandler(ActionBan))
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "unban",
+		Description:              "Unban a user",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionBanMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			userOption(),
+			reasonOption(),
+		},
+	}, m.actionCommandHandler(ActionUnban))
+
+	caseNumberOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "number",
+			Description: "Case number",
+			Required:    true,
+			MinValue:    Ptr(1.0),
+		}
+	}
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "case",
+		Description:              "View or edit a moderation case",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionModerateMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "view",
+				Description: "Show a case",
+				Options:     []*discordgo.ApplicationCommandOption{caseNumberOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reason",
+				Description: "Change the reason of a case",
+				Options: []*discordgo.ApplicationCommandOption{
+					caseNumberOption(),
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "reason",
+						Description: "New reason",
+						Required:    true,
+						MaxLength:   1000,
+					},
+				},
+			},
+		},
+	}, m.CaseCommand)
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "modlogs",
+		Description:              "Show the moderation history of a user",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionModerateMembers)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "User to show the history of",
+				Required:    true,
+			},
+		},
+	}, m.ModlogsCommand)
+
+	bot.Jobs.Handle("moderation.unban", m.ExpireBan)
+	return nil
+}
+
+func (m *ModerationModule) actionCommandHandler(action ModerationActionType) InteractionHandler {
+	return func(interaction *discordgo.Interaction) error {
+		return m.ActionCommand(interaction, action)
+	}
+}
+
+func (m *ModerationModule) ActionCommand(interaction *discordgo.Interaction, actionType ModerationActionType) error {
+	options := CommandOptions(interaction.ApplicationCommandData().Options)
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	action := &ModerationAction{
+		GuildID:           interaction.GuildID,
+		Type:              actionType,
+		TargetID:          OptionID(options, "user"),
+		ModeratorID:       interaction.Member.User.ID,
+		Reason:            OptionString(options, "reason"),
+		DeleteMessageDays: int(OptionInt(options, "delete_messages")),
+	}
+
+	if action.TargetID == action.ModeratorID {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.self", nil, locales...))
+	}
+	if actionType != ActionUnban {
+		problem, err := m.checkHierarchy(interaction, action.TargetID, locales)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			return m.Discord.FollowupEphemeral(interaction, problem)
+		}
+	}
+
+	if actionType == ActionTimeout {
+		action.Duration, err = ParseDuration(OptionString(options, "duration"))
+		if err != nil || action.Duration <= 0 {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.invalid_duration", nil, locales...))
+		}
+		if action.Duration > MaxTimeout {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.duration_too_long", nil, locales...))
+		}
+	}
+	if actionType == ActionBan && OptionString(options, "duration") != "" {
+		action.Duration, err = ParseDuration(OptionString(options, "duration"))
+		if err != nil || action.Duration <= 0 {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.invalid_duration", nil, locales...))
+		}
+	}
+
+	moderationLog.Info("Moderation action", "action", actionType, "guild", interaction.GuildID, "user", action.TargetID, "staff", interaction.Member.User.ID)
+
+	moderationCase, err := m.Apply(action)
+	if err != nil {
+		moderationLog.Warn("Moderation action failed", "action", actionType, "guild", interaction.GuildID, "user", action.TargetID, "error", ErrorToStr(err))
+
+		data := NewTemplateData()
+		data.User = NewTemplateUserFromID(action.TargetID)
+		data.Action = m.Localizer.Text("moderation.action."+string(actionType), nil, locales...)
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.failed", data, locales...))
+	}
+
+	data := m.caseTemplateData(moderationCase, locales)
+	feedback := m.Localizer.Text("moderation.done", data, locales...)
+	if moderationCase.Escalation != nil {
+		data = m.caseTemplateData(moderationCase.Escalation, locales)
+		feedback += "\n" + m.Localizer.Text("moderation.escalated", data, locales...)
+	}
+	return m.Discord.FollowupEphemeral(interaction, feedback)
+}
+
+// Refuses actions against the owner, the bot and members whose highest role
+// isn't below both the moderator's and the bot's, as the bot would act with
+// its own role. Returns the response for refused actions, empty if allowed.
+func (m *ModerationModule) checkHierarchy(interaction *discordgo.Interaction, targetID string, locales []discordgo.Locale) (string, error) {
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	if guild == nil {
+		return "", Errorf("guild %v not found", interaction.GuildID)
+	}
+
+	data := NewTemplateData()
+	data.User = NewTemplateUserFromID(targetID)
+	if targetID == guild.OwnerID || targetID == m.Discord.BotUserID() {
+		return m.Localizer.Text("moderation.protected", data, locales...), nil
+	}
+
+	target, err := m.Discord.GuildMember(guild.ID, targetID)
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMember {
+		// Not a member, e.g. banning someone who already left
+		return "", nil
+	}
+	if err != nil {
+		return "", WrapError(err)
+	}
+	targetPosition := HighestRolePosition(guild, target.Roles)
+
+	if interaction.Member.User.ID != guild.OwnerID && HighestRolePosition(guild, interaction.Member.Roles) <= targetPosition {
+		return m.Localizer.Text("moderation.hierarchy", data, locales...), nil
+	}
+	bot, err := m.Discord.GuildMember(guild.ID, m.Discord.BotUserID())
+	if err != nil {
+		return "", WrapError(err)
+	}
+	if HighestRolePosition(guild, bot.Roles) <= targetPosition {
+		return m.Localizer.Text("moderation.bot_hierarchy", data, locales...), nil
+	}
+	return "", nil
+}
+
+// Carries out a moderation action on Discord, notifies the target and records
+// the action as a new case
+func (m *ModerationModule) Apply(action *ModerationAction) (*ModerationCase, error) {
+	var err error
+	auditLogReason := discordgo.WithAuditLogReason(action.Reason)
+
+	notify := func() {
+		err := m.NotifyTarget(action)
+		if err != nil {
+			moderationLog.Warn("Could not DM user", "action", action.Type, "guild", action.GuildID, "user", action.TargetID, "error", err)
+		}
+	}
+
+	// DM before kicking or banning, afterwards there may be no shared guild
+	// left. Warnings and timeouts are only announced once they succeeded.
+	if action.Type == ActionKick || action.Type == ActionBan {
+		notify()
+	}
+
+	switch action.Type {
+	case ActionWarn:
+		// Only recorded
+	case ActionTimeout:
+		until := time.Now().Add(action.Duration)
+		err = m.Discord.GuildMemberTimeout(action.GuildID, action.TargetID, &until, auditLogReason)
+	case ActionKick:
+		err = m.Discord.GuildMemberDeleteWithReason(action.GuildID, action.TargetID, action.Reason)
+	case ActionBan:
+		err = m.Discord.GuildBanCreateWithReason(action.GuildID, action.TargetID, action.Reason, action.DeleteMessageDays)
+	case ActionUnban:
+		err = m.Discord.GuildBanDelete(action.GuildID, action.TargetID, auditLogReason)
+	default:
+		err = Errorf("unknown moderation action %q", action.Type)
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if action.Type == ActionWarn || action.Type == ActionTimeout {
+		notify()
+	}
+
+	moderationCase, err := m.CreateCase(action)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.LogCase(moderationCase)
+	if err != nil {
+		moderationLog.Warn("Could not log case", "case", moderationCase.Number, "guild", moderationCase.GuildID, "error", ErrorToStr(err))
+	}
+
+	// A new ban replaces the end of an earlier temporary ban, an unban makes
+	// it pointless
+	if action.Type == ActionBan || action.Type == ActionUnban {
+		err = m.cancelBanExpiries(action.GuildID, action.TargetID)
+		if err != nil {
+			moderationLog.Error("Could not cancel the end of a temporary ban", "guild", action.GuildID, "user", action.TargetID, "error", ErrorToStr(err))
+		}
+	}
+	if action.Type == ActionBan && action.Duration > 0 {
+		err = m.scheduleBanExpiry(moderationCase, time.Now().Add(action.Duration))
+		if err != nil {
+			moderationLog.Error("Could not schedule the end of a temporary ban", "case", moderationCase.Number, "guild", action.GuildID, "user", action.TargetID, "error", ErrorToStr(err))
+		}
+	}
+
+	if action.Type == ActionWarn {
+		moderationCase.Escalation, err = m.Escalate(action)
+		if err != nil {
+			moderationLog.Warn("Escalation failed", "guild", action.GuildID, "user", action.TargetID, "error", ErrorToStr(err))
+		}
+	}
+
+	return moderationCase, nil
+}
+
+// Applies the escalation rule matching the target's active warnings, if any
+func (m *ModerationModule) Escalate(warning *ModerationAction) (*ModerationCase, error) {
+	if m.Config.Escalation == nil {
+		return nil, nil
+	}
+
+	decay := m.Config.Escalation.WarningDecay
+	for _, rule := range m.escalationRules {
+		within := rule.Within
+		if within == 0 || (decay > 0 && within > decay) {
+			within = decay
+		}
+
+		warnings, err := m.CountWarnings(warning.GuildID, warning.TargetID, time.Duration(within))
+		if err != nil {
+			return nil, err
+		}
+		// Only when the threshold is reached, not again on every later warning
+		if warnings != rule.Warnings {
+			continue
+		}
+
+		moderationLog.Info("Escalating warnings", "guild", warning.GuildID, "user", warning.TargetID, "warnings", warnings, "action", rule.Action)
+
+		locales := GuildLocales(m.Discord.CachedGuild(warning.GuildID))
+		data := NewTemplateData()
+		data.Count = warnings
+
+		return m.Apply(&ModerationAction{
+			GuildID:     warning.GuildID,
+			Type:        rule.Action,
+			TargetID:    warning.TargetID,
+			ModeratorID: m.Discord.BotUserID(),
+			Reason:      m.Localizer.Text("moderation.escalation_reason", data, locales...),
+			Duration:    time.Duration(rule.Duration),
+		})
+	}
+
+	return nil, nil
+}
+
+// Enqueues the unban job of a temporary ban and stores it on the case
+func (m *ModerationModule) scheduleBanExpiry(moderationCase *ModerationCase, at time.Time) error {
+	jobID, err := m.Jobs.Enqueue(moderationCase.GuildID, "moderation.unban", &banExpiry{
+		GuildID:    moderationCase.GuildID,
+		UserID:     moderationCase.TargetID,
+		CaseNumber: moderationCase.Number,
+	}, at)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.Exec(`UPDATE moderation_cases SET unban_job_id = $1 WHERE guild_id = $2 AND case_number = $3`,
+		jobID, moderationCase.GuildID, moderationCase.Number)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Cancels the pending unban jobs of earlier temporary bans of the user. A
+// case only forgets its job once the job is cancelled, so a failure leaves it
+// to the next ban or unban.
+func (m *ModerationModule) cancelBanExpiries(guildID string, userID string) error {
+	rows, err := m.DB.Query(`
+		SELECT unban_job_id FROM moderation_cases
+		WHERE guild_id = $1 AND target_id = $2 AND unban_job_id IS NOT NULL`,
+		guildID, userID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	jobIDs := []int64{}
+	for rows.Next() {
+		var jobID int64
+		err := rows.Scan(&jobID)
+		if err != nil {
+			rows.Close()
+			return WrapError(err)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	for _, jobID := range jobIDs {
+		err := m.Jobs.Cancel(jobID)
+		if err != nil {
+			return err
+		}
+		_, err = m.DB.Exec(`UPDATE moderation_cases SET unban_job_id = NULL WHERE guild_id = $1 AND unban_job_id = $2`, guildID, jobID)
+		if err != nil {
+			return WrapError(err)
+		}
+		moderationLog.Debug("Cancelled the end of a temporary ban", "job", jobID, "guild", guildID, "user", userID)
+	}
+	return nil
+}
+
+// Lifts a temporary ban, recorded as its own case. The unban cancels the
+// job itself, which is fine since it's deleted after succeeding anyway.
+func (m *ModerationModule) ExpireBan(job *Job) error {
+	expiry := &banExpiry{}
+	err := job.Decode(expiry)
+	if err != nil {
+		return err
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(expiry.GuildID))
+	data := NewTemplateData()
+	data.CaseNumber = expiry.CaseNumber
+
+	_, err = m.Apply(&ModerationAction{
+		GuildID:     expiry.GuildID,
+		Type:        ActionUnban,
+		TargetID:    expiry.UserID,
+		ModeratorID: m.Discord.BotUserID(),
+		Reason:      m.Localizer.Text("moderation.ban_expired", data, locales...),
+	})
+	// Unbanned by hand in the meantime
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownBan {
+		moderationLog.Info("Temporary ban was already lifted", "case", expiry.CaseNumber, "guild", expiry.GuildID, "user", expiry.UserID)
+		return nil
+	}
+	return err
+}
+
+// Warnings of a user issued within the given time, zero counts all of them
+func (m *ModerationModule) CountWarnings(guildID string, userID string, within time.Duration) (int, error) {
+	since := time.Time{}
+	if within > 0 {
+		since = time.Now().Add(-within)
+	}
+
+	var count int
+	err := m.DB.QueryRow(`
+		SELECT count(*) FROM moderation_cases
+		WHERE guild_id = $1 AND target_id = $2 AND action = $3 AND created_at > $4`,
+		guildID, userID, ActionWarn, since,
+	).Scan(&count)
+	if err != nil {
+		return 0, WrapError(err)
+	}
+	return count, nil
+}
+
+func (m *ModerationModule) NotifyTarget(action *ModerationAction) error {
+	guild := m.Discord.CachedGuild(action.GuildID)
+	locales := GuildLocales(guild)
+
+	data := NewTemplateData()
+	data.Guild = NewTemplateGuild(guild)
+	data.User = NewTemplateUserFromID(action.TargetID)
+	data.Staff = NewTemplateUserFromID(action.ModeratorID)
+	data.Reason = action.Reason
+	data.Action = m.Localizer.Text("moderation.past."+string(action.Type), nil, locales...)
+	if action.Duration > 0 {
+		data.Duration = FormatDuration(action.Duration)
+	}
+
+	var message *discordgo.MessageSend
+	var err error
+	if m.Config.DmMessage != nil {
+		message, err = m.Localizer.Message("Moderation.DmMessage", m.Config.DmMessage, data, locales...)
+		if err != nil {
+			return err
+		}
+	} else {
+		message = &discordgo.MessageSend{
+			Content: m.Localizer.Text("moderation.dm", data, locales...),
+		}
+	}
+
+	return m.Discord.SendDM(action.TargetID, message)
+}
+
+// Stores the action under the next case number of the guild
+func (m *ModerationModule) CreateCase(action *ModerationAction) (*ModerationCase, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	defer tx.Rollback()
+
+	moderationCase := &ModerationCase{
+		GuildID:     action.GuildID,
+		Action:      action.Type,
+		TargetID:    action.TargetID,
+		ModeratorID: action.ModeratorID,
+		Reason:      action.Reason,
+		Duration:    action.Duration,
+	}
+
+	// The counter row lock serializes concurrent actions in the same guild
+	err = tx.QueryRow(`
+		INSERT INTO moderation_case_counters (guild_id, last_case) VALUES ($1, 1)
+		ON CONFLICT (guild_id) DO UPDATE SET last_case = moderation_case_counters.last_case + 1
+		RETURNING last_case`,
+		action.GuildID,
+	).Scan(&moderationCase.Number)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO moderation_cases (guild_id, case_number, action, target_id, moderator_id, reason, duration_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`,
+		moderationCase.GuildID, moderationCase.Number, moderationCase.Action, moderationCase.TargetID,
+		moderationCase.ModeratorID, moderationCase.Reason, int64(moderationCase.Duration.Seconds()),
+	).Scan(&moderationCase.CreatedAt)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	return moderationCase, nil
+}
+
+const moderationCaseColumns = `guild_id, case_number, action, target_id, moderator_id, reason, duration_seconds, log_message_id, created_at`
+
+func scanModerationCase(row interface{ Scan(...any) error }) (*ModerationCase, error) {
+	moderationCase := &ModerationCase{}
+	var durationSeconds int64
+	err := row.Scan(
+		&moderationCase.GuildID, &moderationCase.Number, &moderationCase.Action, &moderationCase.TargetID,
+		&moderationCase.ModeratorID, &moderationCase.Reason, &durationSeconds, &moderationCase.LogMessageID,
+		&moderationCase.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	moderationCase.Duration = time.Duration(durationSeconds) * time.Second
+	return moderationCase, nil
+}
+
+// Returns nil if the case doesn't exist
+func (m *ModerationModule) GetCase(guildID string, number int) (*ModerationCase, error) {
+	row := m.DB.QueryRow(`SELECT `+moderationCaseColumns+` FROM moderation_cases WHERE guild_id = $1 AND case_number = $2`, guildID, number)
+	moderationCase, err := scanModerationCase(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return moderationCase, nil
+}
+
+// Most recent cases of a user first
+func (m *ModerationModule) UserCases(guildID string, userID string, limit int) ([]*ModerationCase, error) {
+	rows, err := m.DB.Query(`
+		SELECT `+moderationCaseColumns+` FROM moderation_cases
+		WHERE guild_id = $1 AND target_id = $2
+		ORDER BY case_number DESC
+		LIMIT $3`,
+		guildID, userID, limit,
+	)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	defer rows.Close()
+
+	cases := []*ModerationCase{}
+	for rows.Next() {
+		moderationCase, err := scanModerationCase(rows)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		cases = append(cases, moderationCase)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, WrapError(err)
+	}
+	return cases, nil
+}
+
+// Posts the case to the log channel and remembers the message so the case
+// can be updated later
+func (m *ModerationModule) LogCase(moderationCase *ModerationCase) error {
+	if m.Config.LogChannel == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(moderationCase.GuildID))
+	message, err := m.Discord.ChannelMessageSendEmbed(m.Config.LogChannel, m.CaseEmbed(moderationCase, locales))
+	if err != nil {
+		return WrapError(err)
+	}
+
+	moderationCase.LogMessageID = message.ID
+	_, err = m.DB.Exec(`UPDATE moderation_cases SET log_message_id = $1 WHERE guild_id = $2 AND case_number = $3`,
+		message.ID, moderationCase.GuildID, moderationCase.Number)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *ModerationModule) caseTemplateData(moderationCase *ModerationCase, locales []discordgo.Locale) *TemplateData {
+	data := NewTemplateData()
+	if moderationCase == nil {
+		return data
+	}
+
+	data.User = NewTemplateUserFromID(moderationCase.TargetID)
+	data.Staff = NewTemplateUserFromID(moderationCase.ModeratorID)
+	data.Reason = moderationCase.Reason
+	data.CaseNumber = moderationCase.Number
+	data.Action = m.Localizer.Text("moderation.past."+string(moderationCase.Action), nil, locales...)
+	if moderationCase.Duration > 0 {
+		data.Duration = FormatDuration(moderationCase.Duration)
+	}
+	if !moderationCase.CreatedAt.IsZero() {
+		data.Now = moderationCase.CreatedAt
+	}
+	return data
+}
+
+func (m *ModerationModule) CaseEmbed(moderationCase *ModerationCase, locales []discordgo.Locale) *discordgo.MessageEmbed {
+	data := m.caseTemplateData(moderationCase, locales)
+	data.Action = m.Localizer.Text("moderation.action."+string(moderationCase.Action), nil, locales...)
+
+	color := ColorDarkOrange
+	switch moderationCase.Action {
+	case ActionKick, ActionBan:
+		color = ColorRed
+	case ActionUnban:
+		color = ColorGreen
+	}
+
+	reason := moderationCase.Reason
+	if reason == "" {
+		reason = m.Localizer.Text("moderation.no_reason", nil, locales...)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Title: m.Localizer.Text("moderation.case_title", data, locales...),
+		Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   m.Localizer.Text("moderation.field.user", nil, locales...),
+				Value:  fmt.Sprintf("<@%s> (%s)", moderationCase.TargetID, moderationCase.TargetID),
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("moderation.field.moderator", nil, locales...),
+				Value:  fmt.Sprintf("<@%s>", moderationCase.ModeratorID),
+				Inline: true,
+			},
+		},
+		Timestamp: moderationCase.CreatedAt.Format(time.RFC3339),
+	}
+	if moderationCase.Duration > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   m.Localizer.Text("moderation.field.duration", nil, locales...),
+			Value:  FormatDuration(moderationCase.Duration),
+			Inline: true,
+		})
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  m.Localizer.Text("moderation.field.reason", nil, locales...),
+		Value: reason,
+	})
+
+	return embed
+}
+
+func (m *ModerationModule) CaseCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+	number := int(OptionInt(options, "number"))
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	moderationCase, err := m.GetCase(interaction.GuildID, number)
+	if err != nil {
+		return err
+	}
+	if moderationCase == nil {
+		data := NewTemplateData()
+		data.CaseNumber = number
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.case_not_found", data, locales...))
+	}
+
+	switch subcommand {
+	case "view":
+		return m.Discord.FollowupEphemeral(interaction, "", m.CaseEmbed(moderationCase, locales))
+	case "reason":
+		moderationCase.Reason = OptionString(options, "reason")
+		_, err = m.DB.Exec(`UPDATE moderation_cases SET reason = $1 WHERE guild_id = $2 AND case_number = $3`,
+			moderationCase.Reason, moderationCase.GuildID, moderationCase.Number)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		moderationLog.Info("Case reason updated", "case", number, "guild", interaction.GuildID, "staff", interaction.Member.User.ID)
+
+		if moderationCase.LogMessageID != "" {
+			guildLocales := GuildLocales(m.Discord.CachedGuild(interaction.GuildID))
+			_, err = m.Discord.ChannelMessageEditEmbed(m.Config.LogChannel, moderationCase.LogMessageID, m.CaseEmbed(moderationCase, guildLocales))
+			if err != nil {
+				moderationLog.Warn("Could not update log message", "case", number, "guild", interaction.GuildID, "error", err)
+			}
+		}
+
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.case_updated", m.caseTemplateData(moderationCase, locales), locales...))
+	}
+
+	return nil
+}
+
+func (m *ModerationModule) ModlogsCommand(interaction *discordgo.Interaction) error {
+	options := CommandOptions(interaction.ApplicationCommandData().Options)
+	locales := InteractionLocales(interaction)
+	userID := OptionID(options, "user")
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	// An embed fits at most 25 fields
+	cases, err := m.UserCases(interaction.GuildID, userID, 25)
+	if err != nil {
+		return err
+	}
+
+	data := NewTemplateData()
+	data.User = NewTemplateUserFromID(userID)
+	if resolved := interaction.ApplicationCommandData().Resolved; resolved != nil && resolved.Users[userID] != nil {
+		data.User = NewTemplateUser(resolved.Users[userID], nil)
+	}
+	if len(cases) == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("moderation.history_empty", data, locales...))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Title: m.Localizer.Text("moderation.history_title", data, locales...),
+	}
+	for _, moderationCase := range cases {
+		caseData := m.caseTemplateData(moderationCase, locales)
+		caseData.Action = m.Localizer.Text("moderation.action."+string(moderationCase.Action), nil, locales...)
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("moderation.history_entry", caseData, locales...),
+			Value: m.Localizer.Text("moderation.history_entry_detail", caseData, locales...),
+		})
+	}
+
+	return m.Discord.FollowupEphemeral(interaction, "", embed)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Postgres from TEST_FBOT_DB, e.g. "postgres://fbot@localhost/fbot_test?sslmode=disable".
+// Tests needing a database are skipped without it.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	connectionString := os.Getenv("TEST_FBOT_DB")
+	if connectionString == "" {
+		t.Skip("TEST_FBOT_DB is not set")
+	}
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = db.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Moderation module on a test database with its own guild, so tests don't
+// see each other's cases and jobs
+func newRecordedModeration(t *testing.T) (*ModerationModule, *RecordingDiscord, string) {
+	db := testDB(t)
+	localizer, err := NewLocalizer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discord := NewRecordingDiscord()
+
+	for _, schema := range []string{jobsSchema, moderationSchema} {
+		_, err = db.Exec(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs := NewJobQueue(db)
+	jobs.Discord = discord
+	jobs.Localizer = localizer
+
+	module := NewModerationModule(&ModerationConfig{})
+	module.Discord = discord
+	module.DB = db
+	module.Jobs = jobs
+	module.Localizer = localizer
+	jobs.Handle("moderation.unban", module.ExpireBan)
+
+	guildID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM jobs WHERE guild_id = $1`, guildID)
+		db.Exec(`DELETE FROM moderation_cases WHERE guild_id = $1`, guildID)
+		db.Exec(`DELETE FROM moderation_case_counters WHERE guild_id = $1`, guildID)
+	})
+	return module, discord, guildID
+}
+
+func pendingJobs(t *testing.T, db *sql.DB, guildID string) int {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow(`SELECT count(*) FROM jobs WHERE guild_id = $1 AND NOT cancelled`, guildID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// A permanent ban after a temporary one must not be lifted by the job of the
+// temporary ban
+func TestModerationPermanentBanCancelsTemporaryBan(t *testing.T) {
+	module, discord, guildID := newRecordedModeration(t)
+
+	_, err := module.Apply(&ModerationAction{
+		GuildID:     guildID,
+		Type:        ActionBan,
+		TargetID:    "100",
+		ModeratorID: "200",
+		Reason:      "Cool down",
+		Duration:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := pendingJobs(t, module.DB, guildID); pending != 1 {
+		t.Fatalf("temporary ban scheduled %v unban jobs, want 1", pending)
+	}
+
+	_, err = module.Apply(&ModerationAction{
+		GuildID:     guildID,
+		Type:        ActionBan,
+		TargetID:    "100",
+		ModeratorID: "200",
+		Reason:      "For good",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := pendingJobs(t, module.DB, guildID); pending != 0 {
+		t.Fatalf("%v unban jobs left after the permanent ban, want 0", pending)
+	}
+
+	var withJob int
+	err = module.DB.QueryRow(`SELECT count(*) FROM moderation_cases WHERE guild_id = $1 AND unban_job_id IS NOT NULL`, guildID).Scan(&withJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withJob != 0 {
+		t.Fatalf("%v cases still point at an unban job", withJob)
+	}
+	if slices.Contains(discord.Calls, "GuildBanDelete") {
+		t.Fatalf("the user was unbanned: %v", discord.Calls)
+	}
+}
+
+// The unban job cancels itself while it runs, which must not stop the unban
+func TestModerationTemporaryBanExpires(t *testing.T) {
+	module, discord, guildID := newRecordedModeration(t)
+
+	_, err := module.Apply(&ModerationAction{
+		GuildID:     guildID,
+		Type:        ActionBan,
+		TargetID:    "100",
+		ModeratorID: "200",
+		Reason:      "Cool down",
+		Duration:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := module.DB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE guild_id = $1`, guildID)
+	job, err := scanJob(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = module.DB.Exec(`UPDATE jobs SET locked_until = now() + interval '1 minute', attempts = 1 WHERE id = $1`, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Attempts = 1
+	module.Jobs.run(job)
+
+	if len(discord.Bans) != 0 {
+		t.Fatalf("the ban wasn't lifted: %+v", discord.Bans)
+	}
+	var left int
+	err = module.DB.QueryRow(`SELECT count(*) FROM jobs WHERE id = $1`, job.ID).Scan(&left)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatal("the finished unban job wasn't deleted")
+	}
+}
@@ -0,0 +1,615 @@
+// Modmail module
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type ModmailConfig struct {
+	// The server modmail is for, DMs don't say which server they are about
+	GuildID string
+	// Forum channel that gets a post per member
+	ForumChannel string
+	// Only members with these roles can reply. Administrators can also use
+	// /reply.
+	StaffRoles []string
+
+	// Messages in a modmail thread starting with these are sent to the
+	// member. Default to "!r " and "!ar ".
+	ReplyPrefix          string
+	AnonymousReplyPrefix string
+	// Shown instead of the staff member's name in anonymous replies,
+	// defaults to the "modmail.anonymous_name" catalog text
+	AnonymousName string
+
+	// Sent to the member when their first message opened a thread
+	OpenMessage *MessageTemplate
+	// Sent to the member when staff close the thread. Reason is the reason
+	// given by staff.
+	CloseMessage *MessageTemplate
+}
+
+func (c *ModmailConfig) Validate() error {
+	if c.GuildID == "" || c.ForumChannel == "" {
+		return Errorf("Modmail.GuildID and Modmail.ForumChannel are required")
+	}
+
+	if c.OpenMessage != nil {
+		err := c.OpenMessage.Validate("Modmail.OpenMessage")
+		if err != nil {
+			return Errorf("Modmail.OpenMessage: %w", err)
+		}
+	}
+	if c.CloseMessage != nil {
+		err := c.CloseMessage.Validate("Modmail.CloseMessage")
+		if err != nil {
+			return Errorf("Modmail.CloseMessage: %w", err)
+		}
+	}
+	return nil
+}
+
+type ModmailThread struct {
+	ID       int
+	UserID   string
+	ThreadID string
+}
+
+var modmailLog = ModuleLogger("modmail")
+
+type ModmailModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *ModmailConfig
+
+	// Quick messages in a row shouldn't open two threads. Keyed by user ID,
+	// so members don't wait for each other.
+	userLocks     map[string]*modmailUserLock
+	userLocksLock sync.Mutex
+
+	// Config.ReplyPrefix and Config.AnonymousReplyPrefix with their defaults
+	replyPrefix          string
+	anonymousReplyPrefix string
+}
+
+type modmailUserLock struct {
+	sync.Mutex
+	// Holders and waiters, the lock is dropped when none are left
+	users int
+}
+
+const modmailSchema = `
+CREATE TABLE IF NOT EXISTS modmail_threads (
+	id         SERIAL PRIMARY KEY,
+	guild_id   TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	thread_id  TEXT NOT NULL,
+	open       BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	closed_at  TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS modmail_threads_open_user ON modmail_threads (guild_id, user_id) WHERE open;
+CREATE INDEX IF NOT EXISTS modmail_threads_thread ON modmail_threads (thread_id);
+`
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "modmail",
+		NeedsDB: true,
+		Intents: discordgo.IntentsDirectMessages | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent,
+	}, func(config *Config) *ModmailConfig {
+		return config.Modmail
+	}, NewModmailModule)
+}
+
+func NewModmailModule(config *ModmailConfig) *ModmailModule {
+	m := &ModmailModule{
+		Config:               config,
+		userLocks:            map[string]*modmailUserLock{},
+		replyPrefix:          config.ReplyPrefix,
+		anonymousReplyPrefix: config.AnonymousReplyPrefix,
+	}
+	if m.replyPrefix == "" {
+		m.replyPrefix = "!r "
+	}
+	if m.anonymousReplyPrefix == "" {
+		m.anonymousReplyPrefix = "!ar "
+	}
+	return m
+}
+
+func (m *ModmailModule) Register(bot *Bot) error {
+	modmailLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(modmailSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	HandleEvent(bot.Discord, m.OnMessageCreate)
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "reply",
+		Description:              "Reply to the member of this modmail thread",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionManageMessages)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "message",
+				Description: "What to send",
+				Required:    true,
+				MaxLength:   4000,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "anonymous",
+				Description: "Hide your name from the member",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "attachment",
+				Description: "File to send along",
+			},
+		},
+	}, m.ReplyCommand)
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "modmail",
+		Description:              "Manage modmail threads",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionManageMessages)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "close",
+				Description: "Close this modmail thread",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "reason",
+						Description: "Sent to the member",
+						MaxLength:   1000,
+					},
+				},
+			},
+		},
+	}, m.ModmailCommand)
+
+	return nil
+}
+
+func (m *ModmailModule) isStaff(member *discordgo.Member) bool {
+	if member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	for _, roleID := range member.Roles {
+		if slices.Contains(m.Config.StaffRoles, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ModmailModule) OnMessageCreate(message *discordgo.MessageCreate) error {
+	if message.Author == nil || message.Author.Bot {
+		return nil
+	}
+
+	if message.GuildID == "" {
+		return m.relayToStaff(message.Message)
+	}
+
+	reply, anonymous := "", false
+	if strings.HasPrefix(message.Content, m.anonymousReplyPrefix) {
+		reply, anonymous = strings.TrimPrefix(message.Content, m.anonymousReplyPrefix), true
+	} else if strings.HasPrefix(message.Content, m.replyPrefix) {
+		reply = strings.TrimPrefix(message.Content, m.replyPrefix)
+	} else {
+		return nil
+	}
+
+	thread, err := m.threadByChannel(message.ChannelID)
+	if err != nil || thread == nil {
+		return err
+	}
+	if message.Member == nil {
+		return nil
+	}
+	message.Member.User = message.Author
+	if !m.isStaff(message.Member) {
+		return nil
+	}
+
+	err = m.relayToMember(thread, message.Member, reply, anonymous, DownloadAttachments(message.Attachments))
+	if err != nil {
+		_, sendErr := m.Discord.ChannelMessageSend(message.ChannelID, m.Localizer.Text("modmail.reply_failed", nil, m.guildLocales()...))
+		if sendErr != nil {
+			modmailLog.Warn("Could not report failed reply", "error", sendErr)
+		}
+		return err
+	}
+
+	// The relayed copy replaces the command message
+	err = m.Discord.ChannelMessageDelete(message.ChannelID, message.ID)
+	if err != nil {
+		modmailLog.Warn("Could not delete reply message", "message", message.ID, "error", err)
+	}
+	return nil
+}
+
+func (m *ModmailModule) guildLocales() []discordgo.Locale {
+	return GuildLocales(m.Discord.CachedGuild(m.Config.GuildID))
+}
+
+// Returns nil if the member has no open thread
+func (m *ModmailModule) threadByUser(userID string) (*ModmailThread, error) {
+	thread := &ModmailThread{}
+	err := m.DB.QueryRow(`SELECT id, user_id, thread_id FROM modmail_threads WHERE guild_id = $1 AND user_id = $2 AND open`,
+		m.Config.GuildID, userID).Scan(&thread.ID, &thread.UserID, &thread.ThreadID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return thread, nil
+}
+
+// Returns nil if the channel isn't an open modmail thread
+func (m *ModmailModule) threadByChannel(channelID string) (*ModmailThread, error) {
+	thread := &ModmailThread{}
+	err := m.DB.QueryRow(`SELECT id, user_id, thread_id FROM modmail_threads WHERE thread_id = $1 AND open`,
+		channelID).Scan(&thread.ID, &thread.UserID, &thread.ThreadID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return thread, nil
+}
+
+// Locks the member's thread, returns the function unlocking it
+func (m *ModmailModule) lockUser(userID string) func() {
+	m.userLocksLock.Lock()
+	lock, ok := m.userLocks[userID]
+	if !ok {
+		lock = &modmailUserLock{}
+		m.userLocks[userID] = lock
+	}
+	lock.users++
+	m.userLocksLock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		m.userLocksLock.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(m.userLocks, userID)
+		}
+		m.userLocksLock.Unlock()
+	}
+}
+
+func (m *ModmailModule) relayToStaff(message *discordgo.Message) error {
+	locales := m.guildLocales()
+
+	// Also keeps the member's messages in order
+	unlock := m.lockUser(message.Author.ID)
+	defer unlock()
+
+	thread, err := m.threadByUser(message.Author.ID)
+	if err == nil && thread == nil {
+		thread, err = m.openThread(message.Author)
+	}
+	if err != nil {
+		return err
+	}
+
+	content := message.Content
+	if content == "" {
+		content = "-"
+	}
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Description: content,
+		Color:       ColorBlue,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    message.Author.Username,
+			IconURL: message.Author.AvatarURL(""),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: m.Localizer.Text("modmail.from_member", nil, locales...),
+		},
+		Timestamp: message.Timestamp.Format(time.RFC3339),
+	}
+
+	_, err = m.Discord.ChannelMessageSendComplex(thread.ThreadID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Files:  DownloadAttachments(message.Attachments),
+	})
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownChannel {
+		// Staff deleted the post, the member gets a new one
+		modmailLog.Info("Thread was deleted, opening a new one", "thread", thread.ID, "user", thread.UserID)
+		_, err = m.DB.Exec(`UPDATE modmail_threads SET open = FALSE, closed_at = now() WHERE id = $1`, thread.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+		thread, err = m.openThread(message.Author)
+		if err != nil {
+			return err
+		}
+		_, err = m.Discord.ChannelMessageSendComplex(thread.ThreadID, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Files:  DownloadAttachments(message.Attachments),
+		})
+	}
+	if err != nil {
+		return WrapError(err)
+	}
+
+	// Let the member know it went through
+	err = m.Discord.MessageReactionAdd(message.ChannelID, message.ID, "✅")
+	if err != nil {
+		modmailLog.Warn("Could not react to message", "message", message.ID, "error", err)
+	}
+	return nil
+}
+
+func (m *ModmailModule) openThread(user *discordgo.User) (*ModmailThread, error) {
+	guild := m.Discord.CachedGuild(m.Config.GuildID)
+	locales := GuildLocales(guild)
+
+	data := NewTemplateData()
+	member, err := m.Discord.GuildMember(m.Config.GuildID, user.ID)
+	if err != nil {
+		// Members who left or were banned can still write in
+		member = nil
+	}
+	data.User = NewTemplateUser(user, member)
+	if guild != nil {
+		data.Guild = NewTemplateGuild(guild)
+	}
+
+	forumThread, err := m.Discord.ForumThreadStartComplex(m.Config.ForumChannel, &discordgo.ThreadStart{
+		Name:                fmt.Sprintf("%s (%s)", user.Username, user.ID),
+		AutoArchiveDuration: 7 * 24 * 60,
+	}, &discordgo.MessageSend{
+		Content: m.Localizer.Text("modmail.thread_opened", data, locales...),
+	})
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	thread := &ModmailThread{
+		UserID:   user.ID,
+		ThreadID: forumThread.ID,
+	}
+	err = m.DB.QueryRow(`INSERT INTO modmail_threads (guild_id, user_id, thread_id) VALUES ($1, $2, $3) RETURNING id`,
+		m.Config.GuildID, thread.UserID, thread.ThreadID).Scan(&thread.ID)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	modmailLog.Info("Thread opened", "thread", thread.ID, "user", user.ID)
+
+	if m.Config.OpenMessage != nil {
+		openMessage, err := m.Localizer.Message("Modmail.OpenMessage", m.Config.OpenMessage, data, locales...)
+		if err != nil {
+			return nil, err
+		}
+		err = m.Discord.SendDM(user.ID, openMessage)
+		if err != nil {
+			modmailLog.Warn("Could not send open message", "user", user.ID, "error", ErrorToStr(err))
+		}
+	}
+
+	return thread, nil
+}
+
+// Sends a staff reply to the member and posts a copy in the thread
+func (m *ModmailModule) relayToMember(thread *ModmailThread, staff *discordgo.Member, content string, anonymous bool, files []*discordgo.File) error {
+	guild := m.Discord.CachedGuild(m.Config.GuildID)
+	locales := GuildLocales(guild)
+
+	author := &discordgo.MessageEmbedAuthor{
+		Name:    staff.DisplayName(),
+		IconURL: staff.AvatarURL(""),
+	}
+	if anonymous {
+		author.Name = m.Localizer.ConfigText("Modmail.AnonymousName", m.Config.AnonymousName, locales...)
+		if author.Name == "" {
+			author.Name = m.Localizer.Text("modmail.anonymous_name", nil, locales...)
+		}
+		author.IconURL = ""
+		if guild != nil {
+			author.IconURL = guild.IconURL("")
+		}
+	}
+
+	footer := ""
+	if guild != nil {
+		footer = guild.Name
+	}
+	if content == "" {
+		content = "-"
+	}
+
+	// Files are read once, the copy in the thread lists their names instead
+	fileNames := []string{}
+	for _, file := range files {
+		fileNames = append(fileNames, file.Name)
+	}
+
+	err := m.Discord.SendDM(thread.UserID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Type:        discordgo.EmbedTypeRich,
+				Description: content,
+				Color:       ColorGreen,
+				Author:      author,
+				Footer:      &discordgo.MessageEmbedFooter{Text: footer},
+				Timestamp:   time.Now().Format(time.RFC3339),
+			},
+		},
+		Files: files,
+	})
+	if err != nil {
+		return err
+	}
+
+	data := NewTemplateData()
+	data.Staff = NewTemplateUser(staff.User, staff)
+	key := "modmail.replied"
+	if anonymous {
+		key = "modmail.replied_anonymously"
+	}
+	copyEmbed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Description: content,
+		Color:       ColorGreen,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    staff.DisplayName(),
+			IconURL: staff.AvatarURL(""),
+		},
+		Footer:    &discordgo.MessageEmbedFooter{Text: m.Localizer.Text(key, data, locales...)},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if len(fileNames) > 0 {
+		copyEmbed.Fields = append(copyEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  m.Localizer.Text("messagelog.field.attachments", nil, locales...),
+			Value: truncateField(strings.Join(fileNames, "\n")),
+		})
+	}
+
+	_, err = m.Discord.ChannelMessageSendEmbed(thread.ThreadID, copyEmbed)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	modmailLog.Info("Reply sent", "thread", thread.ID, "user", thread.UserID, "staff", staff.User.ID)
+	return nil
+}
+
+func (m *ModmailModule) ReplyCommand(interaction *discordgo.Interaction) error {
+	options := CommandOptions(interaction.ApplicationCommandData().Options)
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	thread, err := m.threadByChannel(interaction.ChannelID)
+	if err != nil {
+		return err
+	}
+	if thread == nil {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("modmail.not_a_thread", nil, locales...))
+	}
+	if !m.isStaff(interaction.Member) {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.staff_only", nil, locales...))
+	}
+
+	attachments := []*discordgo.MessageAttachment{}
+	if attachmentID := OptionID(options, "attachment"); attachmentID != "" {
+		attachment := interaction.ApplicationCommandData().Resolved.Attachments[attachmentID]
+		if attachment != nil {
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	err = m.relayToMember(thread, interaction.Member, OptionString(options, "message"), OptionBool(options, "anonymous"), DownloadAttachments(attachments))
+	if err != nil {
+		modmailLog.Warn("Could not send reply", "thread", thread.ID, "user", thread.UserID, "error", ErrorToStr(err))
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("modmail.reply_failed", nil, locales...))
+	}
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("modmail.reply_sent", nil, locales...))
+}
+
+func (m *ModmailModule) ModmailCommand(interaction *discordgo.Interaction) error {
+	_, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+	staff := interaction.Member
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	thread, err := m.threadByChannel(interaction.ChannelID)
+	if err != nil {
+		return err
+	}
+	if thread == nil {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("modmail.not_a_thread", nil, locales...))
+	}
+	if !m.isStaff(staff) {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.staff_only", nil, locales...))
+	}
+
+	_, err = m.DB.Exec(`UPDATE modmail_threads SET open = FALSE, closed_at = now() WHERE id = $1`, thread.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	guild := m.Discord.CachedGuild(m.Config.GuildID)
+	guildLocales := GuildLocales(guild)
+	data := NewTemplateData()
+	data.User = NewTemplateUserFromID(thread.UserID)
+	data.Staff = NewTemplateUser(staff.User, staff)
+	data.Reason = OptionString(options, "reason")
+	if guild != nil {
+		data.Guild = NewTemplateGuild(guild)
+	}
+
+	if m.Config.CloseMessage != nil {
+		closeMessage, err := m.Localizer.Message("Modmail.CloseMessage", m.Config.CloseMessage, data, guildLocales...)
+		if err != nil {
+			return err
+		}
+		err = m.Discord.SendDM(thread.UserID, closeMessage)
+		if err != nil {
+			modmailLog.Warn("Could not send close message", "thread", thread.ID, "user", thread.UserID, "error", ErrorToStr(err))
+		}
+	}
+
+	err = m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("modmail.closed", data, locales...))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Discord.ChannelMessageSend(thread.ThreadID, m.Localizer.Text("modmail.closed", data, guildLocales...))
+	if err != nil {
+		return WrapError(err)
+	}
+	_, err = m.Discord.ChannelEdit(thread.ThreadID, &discordgo.ChannelEdit{
+		Archived: Ptr(true),
+		Locked:   Ptr(true),
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+
+	modmailLog.Info("Thread closed", "thread", thread.ID, "user", thread.UserID, "staff", staff.User.ID)
+	return nil
+}
@@ -0,0 +1,260 @@
+// Module registry and lifecycle
+
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Modules register their handlers, commands and scheduled tasks in Register.
+// The interfaces below are optional lifecycle hooks.
+type Module interface {
+	Register(*Bot) error
+}
+
+// Called once the gateway is connected and commands are synced
+type ModuleStarter interface {
+	Start() error
+}
+
+// Called on shutdown after event handlers and scheduled tasks have finished,
+// in reverse order of registration
+type ModuleStopper interface {
+	Stop() error
+}
+
+// Reports problems that should fail the readiness probe
+type ModuleHealth interface {
+	Health() error
+}
+
+type ModuleInfo struct {
+	// Used in the Modules config and as the module field of logs and metrics,
+	// e.g. "verification" for VerificationModule
+	Name string
+	// Fails at startup without DbConnectionString. Bot.DB and Bot.Jobs are
+	// set for such modules.
+	NeedsDB bool
+	// Modules that have to be enabled as well
+	Requires []string
+	// Gateway intents the module's event handlers need. Interactions don't
+	// need any.
+	Intents discordgo.Intent
+}
+
+type moduleEntry struct {
+	ModuleInfo
+	configured func(config *Config) bool
+	validate   func(config *Config) error
+	create     func(config *Config) Module
+}
+
+// In order of registration, which is the order of the source files
+var registeredModules []*moduleEntry
+
+// Makes a module available, called from init in the module's file. Section
+// returns the module's config section, the module is enabled by default if
+// the section is present.
+func RegisterModule[C any, M Module](info ModuleInfo, section func(config *Config) *C, create func(config *C) M) {
+	registeredModules = append(registeredModules, &moduleEntry{
+		ModuleInfo: info,
+		configured: func(config *Config) bool {
+			return section(config) != nil
+		},
+		validate: func(config *Config) error {
+			moduleConfig := section(config)
+			if moduleConfig == nil {
+				return nil
+			}
+			if validator, ok := any(moduleConfig).(interface{ Validate() error }); ok {
+				return validator.Validate()
+			}
+			return nil
+		},
+		create: func(config *Config) Module {
+			return create(section(config))
+		},
+	})
+}
+
+func findModuleEntry(name string) *moduleEntry {
+	for _, entry := range registeredModules {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+type GuildModulesConfig struct {
+	// Only these modules handle events and interactions of the guild
+	Enabled []string
+	// Or: all globally enabled modules except these
+	Disabled []string
+}
+
+type ModulesConfig struct {
+	// Modules to run. Defaults to every module with a config section.
+	Enabled []string
+	// Modules not to run even if they have a config section
+	Disabled []string
+	// Per guild restrictions, keyed by guild ID
+	Guilds map[string]*GuildModulesConfig
+}
+
+func (c *ModulesConfig) Validate() error {
+	if len(c.Enabled) > 0 && len(c.Disabled) > 0 {
+		return Errorf("Modules: set either Enabled or Disabled")
+	}
+	err := validateModuleNames("Modules", c.Enabled, c.Disabled)
+	if err != nil {
+		return err
+	}
+	for guildID, guild := range c.Guilds {
+		if len(guild.Enabled) > 0 && len(guild.Disabled) > 0 {
+			return Errorf("Modules.Guilds.%v: set either Enabled or Disabled", guildID)
+		}
+		err := validateModuleNames("Modules.Guilds."+guildID, guild.Enabled, guild.Disabled)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateModuleNames(path string, lists ...[]string) error {
+	for _, names := range lists {
+		for _, name := range names {
+			if findModuleEntry(name) == nil {
+				return Errorf("%v: unknown module %q", path, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the module runs at all, guild settings aside
+func (c *ModulesConfig) enabled(entry *moduleEntry, config *Config) bool {
+	if c != nil && len(c.Enabled) > 0 {
+		return slices.Contains(c.Enabled, entry.Name)
+	}
+	if c != nil && slices.Contains(c.Disabled, entry.Name) {
+		return false
+	}
+	return entry.configured(config)
+}
+
+// Validates the modules' config sections and the Modules section
+func validateModules(config *Config) error {
+	if config.Modules != nil {
+		err := config.Modules.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range registeredModules {
+		err := entry.validate(config)
+		if err != nil {
+			return err
+		}
+		if !config.Modules.enabled(entry, config) {
+			continue
+		}
+		if !entry.configured(config) {
+			return Errorf("module %v is enabled but its config section is missing", entry.Name)
+		}
+		for _, required := range entry.Requires {
+			requiredEntry := findModuleEntry(required)
+			if requiredEntry == nil || !config.Modules.enabled(requiredEntry, config) {
+				return Errorf("module %v requires the %v module", entry.Name, required)
+			}
+		}
+	}
+	return nil
+}
+
+// Creates the enabled modules, checking their dependencies. Also returns the
+// intents of the modules, keyed by module name.
+func createModules(config *Config, hasDB bool) ([]Module, map[string]discordgo.Intent, error) {
+	modules := []Module{}
+	intents := map[string]discordgo.Intent{}
+	for _, entry := range registeredModules {
+		if !config.Modules.enabled(entry, config) {
+			continue
+		}
+		if entry.NeedsDB && !hasDB {
+			return nil, nil, Errorf("%v module requires DbConnectionString", entry.Name)
+		}
+		modules = append(modules, entry.create(config))
+		intents[entry.Name] = entry.Intents
+	}
+	return modules, intents, nil
+}
+
+// Name of a module, e.g. "verification" for *VerificationModule
+func moduleName(module Module) string {
+	moduleType := reflect.TypeOf(module)
+	if moduleType.Kind() == reflect.Pointer {
+		moduleType = moduleType.Elem()
+	}
+	return strings.ToLower(strings.TrimSuffix(moduleType.Name(), "Module"))
+}
+
+// Per guild module settings, applied to events and interactions. Kept
+// globally like the logging settings so that HandleEvent can check them.
+var (
+	guildModulesLock sync.RWMutex
+	guildModules     map[string]*GuildModulesConfig
+)
+
+func setGuildModules(config *ModulesConfig) {
+	guildModulesLock.Lock()
+	defer guildModulesLock.Unlock()
+
+	guildModules = nil
+	if config != nil {
+		guildModules = config.Guilds
+	}
+}
+
+// Whether the module handles events and interactions of the guild. Always
+// true outside of guilds and for code not belonging to a module.
+func ModuleEnabledIn(module string, guildID string) bool {
+	if module == "" || guildID == "" {
+		return true
+	}
+
+	guildModulesLock.RLock()
+	defer guildModulesLock.RUnlock()
+
+	guild, ok := guildModules[guildID]
+	if !ok {
+		return true
+	}
+	if len(guild.Enabled) > 0 {
+		return slices.Contains(guild.Enabled, module)
+	}
+	return !slices.Contains(guild.Disabled, module)
+}
+
+// Answers interactions with commands and components of modules disabled in
+// the guild
+func (bot *Bot) respondModuleDisabled(interaction *discordgo.Interaction) error {
+	err := bot.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: bot.Localizer.Text("module.disabled", nil, InteractionLocales(interaction)...),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
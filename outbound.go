@@ -0,0 +1,531 @@
+// Outbound REST queue
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type OutboundConfig struct {
+	// REST requests running at once, defaults to 10
+	Concurrency int
+	// Requests per second other than interaction responses, which Discord
+	// doesn't count. Defaults to 40, Discord's global limit is 50.
+	RequestsPerSecond float64
+	// Retries of other requests after network errors and 500, 503 or 504
+	// responses, defaults to 3. -1 turns retries off. Only requests that are
+	// safe to repeat are retried: reads, edits, deletes and message sends.
+	Retries int
+	// Announcements to the same channel within this time are combined into as
+	// few messages as possible, defaults to 2s
+	AnnounceDelay Duration
+}
+
+func (c *OutboundConfig) Validate() error {
+	if c.Concurrency < 0 {
+		return Errorf("Outbound.Concurrency can't be negative")
+	}
+	if c.RequestsPerSecond < 0 {
+		return Errorf("Outbound.RequestsPerSecond can't be negative")
+	}
+	if c.Retries < -1 {
+		return Errorf("Outbound.Retries must be -1 or more")
+	}
+	if c.AnnounceDelay < 0 {
+		return Errorf("Outbound.AnnounceDelay can't be negative")
+	}
+	return nil
+}
+
+// Discord's limits for a single message
+const (
+	maxMessageLength     = 2000
+	maxMessageEmbeds     = 10
+	maxMessageEmbedsSize = 6000
+)
+
+var outboundLog = ModuleLogger("outbound")
+
+var (
+	outboundWait = NewHistogram("fbot_rest_queue_seconds",
+		"Time REST requests waited for their turn by priority: interaction or normal.", latencyBuckets, "priority")
+	outboundRetries = NewCounter("fbot_rest_retries_total",
+		"REST requests retried after transient failures by method and route.", "method", "route")
+	announcementsCoalesced = NewCounter("fbot_announcements_coalesced_total",
+		"Announcements sent as part of an earlier message to the same channel.")
+)
+
+// Queues REST requests: interaction responses first, as they have to arrive
+// within 3 seconds, everything else paced below the global rate limit and
+// retried on transient failures. Discordgo's per-route rate limiting and 429
+// handling happen before a request gets here.
+type outboundTransport struct {
+	next    http.RoundTripper
+	slots   *prioritySlots
+	pacer   *pacer
+	retries int
+}
+
+func newOutboundTransport(config *OutboundConfig, next http.RoundTripper) *outboundTransport {
+	concurrency := 10
+	requestsPerSecond := 40.0
+	retries := 3
+	if config != nil {
+		if config.Concurrency > 0 {
+			concurrency = config.Concurrency
+		}
+		if config.RequestsPerSecond > 0 {
+			requestsPerSecond = config.RequestsPerSecond
+		}
+		if config.Retries != 0 {
+			retries = max(config.Retries, 0)
+		}
+	}
+
+	return &outboundTransport{
+		next:    next,
+		slots:   &prioritySlots{free: concurrency},
+		pacer:   &pacer{interval: time.Duration(float64(time.Second) / requestsPerSecond)},
+		retries: retries,
+	}
+}
+
+// Interaction callbacks and followups, which use the interaction token
+func isInteractionRoute(route string) bool {
+	return strings.HasPrefix(route, "/interactions/") || strings.HasPrefix(route, "/webhooks/:id/:token")
+}
+
+func (t *outboundTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	route := apiRoute(request.URL.Path)
+	interactive := isInteractionRoute(route)
+	priority := "normal"
+	if interactive {
+		priority = "interaction"
+	}
+
+	retries := t.retries
+	if !retryable(request.Method) {
+		nonced, err := withNonce(request, route)
+		if err != nil {
+			return nil, err
+		}
+		if nonced != nil {
+			request = nonced
+		} else {
+			retries = 0
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		if !interactive {
+			err := sleepContext(request.Context(), t.pacer.reserve())
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := t.slots.acquire(request.Context(), interactive)
+		if err != nil {
+			return nil, err
+		}
+		waited := time.Since(start)
+		outboundWait.Observe(waited.Seconds(), priority)
+		if interactive && waited > time.Second {
+			outboundLog.Warn("Interaction response was delayed", "route", route, "waited", waited.Round(time.Millisecond).String())
+		}
+
+		response, err := t.next.RoundTrip(request)
+		t.slots.release()
+
+		// Interactions can only be answered once, a retry after a timeout
+		// would fail anyway
+		if interactive || attempt >= retries || !transientFailure(request, response, err) {
+			return response, err
+		}
+		// Bodies discordgo creates can always be rewound
+		retry := request.Clone(request.Context())
+		if request.Body != nil && request.Body != http.NoBody {
+			if request.GetBody == nil {
+				return response, err
+			}
+			retry.Body, err = request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		backoff := retryBackoff(attempt, response)
+		attrs := []any{"method", request.Method, "route", route, "attempt", attempt + 1, "retry_in", backoff.String()}
+		if response != nil {
+			attrs = append(attrs, "status", response.StatusCode)
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		} else {
+			attrs = append(attrs, "error", err.Error())
+		}
+		outboundLog.Info("Retrying request", attrs...)
+		outboundRetries.Inc(request.Method, route)
+
+		err = sleepContext(request.Context(), backoff)
+		if err != nil {
+			return nil, err
+		}
+		request = retry
+	}
+}
+
+// Whether sending the request twice has the same effect as sending it once.
+// Edits count, they set the same fields again.
+func retryable(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Makes a message send safe to retry by adding a nonce Discord enforces, a
+// retry of a message that went through returns the message instead of
+// posting it again. Returns nil for every other non-idempotent request, like
+// creating channels and threads, and for sends with attachments.
+func withNonce(request *http.Request, route string) (*http.Request, error) {
+	if request.Method != http.MethodPost || route != "/channels/:id/messages" ||
+		request.Header.Get("Content-Type") != "application/json" || request.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]json.RawMessage
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		// Sent as it is, without retries
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		return nil, nil
+	}
+	payload["nonce"], _ = json.Marshal(strconv.FormatInt(rand.Int63(), 36))
+	payload["enforce_nonce"] = json.RawMessage("true")
+	body, err = json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	nonced := request.Clone(request.Context())
+	nonced.ContentLength = int64(len(body))
+	nonced.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	nonced.Body, _ = nonced.GetBody()
+	return nonced, nil
+}
+
+// Network errors and server errors that usually go away. Discordgo retries
+// 502 itself.
+func transientFailure(request *http.Request, response *http.Response, err error) bool {
+	if err != nil {
+		return request.Context().Err() == nil
+	}
+	switch response.StatusCode {
+	case http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Retry-After if the response has one, else 500ms doubling up to 10s
+func retryBackoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		seconds, err := strconv.ParseFloat(response.Header.Get("Retry-After"), 64)
+		if err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return min(500*time.Millisecond<<attempt, 10*time.Second)
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Limits running requests, waiting interaction responses get the next free
+// slot before anything else
+type prioritySlots struct {
+	lock        sync.Mutex
+	free        int
+	interactive []chan struct{}
+	other       []chan struct{}
+}
+
+func (s *prioritySlots) acquire(ctx context.Context, interactive bool) error {
+	s.lock.Lock()
+	if s.free > 0 {
+		s.free--
+		s.lock.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if interactive {
+		s.interactive = append(s.interactive, ready)
+	} else {
+		s.other = append(s.other, ready)
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if !s.dequeue(&s.interactive, ready) && !s.dequeue(&s.other, ready) {
+			// Got the slot in the meantime
+			s.releaseLocked()
+		}
+		return ctx.Err()
+	}
+}
+
+func (s *prioritySlots) dequeue(queue *[]chan struct{}, ready chan struct{}) bool {
+	i := slices.Index(*queue, ready)
+	if i < 0 {
+		return false
+	}
+	*queue = slices.Delete(*queue, i, i+1)
+	return true
+}
+
+func (s *prioritySlots) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.releaseLocked()
+}
+
+func (s *prioritySlots) releaseLocked() {
+	for _, queue := range []*[]chan struct{}{&s.interactive, &s.other} {
+		if len(*queue) > 0 {
+			close((*queue)[0])
+			*queue = (*queue)[1:]
+			return
+		}
+	}
+	s.free++
+}
+
+// Spaces requests evenly
+type pacer struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Takes the next free time, returns how long to wait for it
+func (p *pacer) reserve() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	wait := p.next.Sub(now)
+	p.next = p.next.Add(p.interval)
+	return wait
+}
+
+// Collects announcements per channel and sends them after a delay
+type announcer struct {
+	discord *Discord
+	delay   time.Duration
+
+	lock    sync.Mutex
+	pending map[string][]*discordgo.MessageSend
+	timers  map[string]*time.Timer
+	sending sync.WaitGroup
+}
+
+func newAnnouncer(discord *Discord, config *OutboundConfig) *announcer {
+	delay := 2 * time.Second
+	if config != nil && config.AnnounceDelay > 0 {
+		delay = time.Duration(config.AnnounceDelay)
+	}
+	return &announcer{
+		discord: discord,
+		delay:   delay,
+		pending: map[string][]*discordgo.MessageSend{},
+		timers:  map[string]*time.Timer{},
+	}
+}
+
+// Sends a message nobody waits for in the background, e.g. a welcome
+// message. Messages to the same channel within Outbound.AnnounceDelay are
+// combined where Discord's limits allow. Failures are logged. The message
+// must not be changed afterwards.
+func (d *Discord) Announce(channelID string, message *discordgo.MessageSend) {
+	a := d.announcer
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.timers[channelID]; !ok {
+		a.sending.Add(1)
+		a.timers[channelID] = time.AfterFunc(a.delay, func() {
+			a.send(channelID)
+		})
+	}
+	a.pending[channelID] = append(a.pending[channelID], message)
+}
+
+// Sends pending announcements right away and waits until they are sent
+func (d *Discord) FlushAnnouncements() {
+	a := d.announcer
+	a.lock.Lock()
+	for channelID, timer := range a.timers {
+		// Already sending otherwise
+		if timer.Stop() {
+			go a.send(channelID)
+		}
+	}
+	a.lock.Unlock()
+	a.sending.Wait()
+}
+
+func (a *announcer) send(channelID string) {
+	defer a.sending.Done()
+
+	a.lock.Lock()
+	messages := a.pending[channelID]
+	delete(a.pending, channelID)
+	delete(a.timers, channelID)
+	a.lock.Unlock()
+
+	combined := coalesceMessages(messages)
+	if len(combined) < len(messages) {
+		announcementsCoalesced.Add(float64(len(messages) - len(combined)))
+		outboundLog.Debug("Combined announcements", "channel", channelID, "announcements", len(messages), "messages", len(combined))
+	}
+	for _, message := range combined {
+		_, err := a.discord.ChannelMessageSendComplex(channelID, message)
+		if err != nil {
+			outboundLog.Error("Could not send announcement", "channel", channelID, "error", ErrorToStr(WrapError(err)))
+		}
+	}
+}
+
+// Appends messages to the previous one while the result stays within
+// Discord's limits. Messages with files, components, stickers or replies are
+// sent as they are.
+func coalesceMessages(messages []*discordgo.MessageSend) []*discordgo.MessageSend {
+	combined := []*discordgo.MessageSend{}
+	for _, message := range messages {
+		if len(combined) > 0 && mergeMessage(combined[len(combined)-1], message) {
+			continue
+		}
+		// Copied as merging changes it
+		copied := *message
+		copied.Embeds = slices.Clip(copied.Embeds)
+		combined = append(combined, &copied)
+	}
+	return combined
+}
+
+func plainMessage(message *discordgo.MessageSend) bool {
+	return !message.TTS && len(message.Components) == 0 && len(message.Files) == 0 && message.File == nil &&
+		message.Embed == nil && message.Reference == nil && len(message.StickerIDs) == 0
+}
+
+// Appends source to target if possible
+func mergeMessage(target *discordgo.MessageSend, source *discordgo.MessageSend) bool {
+	if !plainMessage(target) || !plainMessage(source) || target.Flags != source.Flags {
+		return false
+	}
+
+	content := target.Content
+	if content != "" && source.Content != "" {
+		content += "\n"
+	}
+	content += source.Content
+	if utf8.RuneCountInString(content) > maxMessageLength {
+		return false
+	}
+
+	embeds := append(target.Embeds, source.Embeds...)
+	size := 0
+	for _, embed := range embeds {
+		size += embedSize(embed)
+	}
+	if len(embeds) > maxMessageEmbeds || size > maxMessageEmbedsSize {
+		return false
+	}
+
+	// Mentions apply to the whole message, so a mention one message allows
+	// would also ping in the other
+	if (target.AllowedMentions == nil) != (source.AllowedMentions == nil) {
+		return false
+	}
+	if target.AllowedMentions != nil {
+		targetMentions, sourceMentions := target.AllowedMentions, source.AllowedMentions
+		if !sameElements(targetMentions.Parse, sourceMentions.Parse) || !sameElements(targetMentions.Roles, sourceMentions.Roles) ||
+			!sameElements(targetMentions.Users, sourceMentions.Users) || targetMentions.RepliedUser != sourceMentions.RepliedUser {
+			return false
+		}
+	}
+
+	target.Content = content
+	target.Embeds = embeds
+	return true
+}
+
+// Whether both hold the same elements in any order, ignoring duplicates
+func sameElements[T comparable](a []T, b []T) bool {
+	for _, element := range a {
+		if !slices.Contains(b, element) {
+			return false
+		}
+	}
+	for _, element := range b {
+		if !slices.Contains(a, element) {
+			return false
+		}
+	}
+	return true
+}
+
+// Characters counting towards the limit of all embeds of a message
+func embedSize(embed *discordgo.MessageEmbed) int {
+	size := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	for _, field := range embed.Fields {
+		size += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	if embed.Footer != nil {
+		size += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	if embed.Author != nil {
+		size += utf8.RuneCountInString(embed.Author.Name)
+	}
+	return size
+}
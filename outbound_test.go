@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCoalesceMessages(t *testing.T) {
+	text := func(content string) *discordgo.MessageSend {
+		return &discordgo.MessageSend{Content: content}
+	}
+	embed := func(description string) *discordgo.MessageSend {
+		return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{{Description: description}}}
+	}
+	mentioning := func(content string, users ...string) *discordgo.MessageSend {
+		return &discordgo.MessageSend{Content: content, AllowedMentions: &discordgo.MessageAllowedMentions{Users: users}}
+	}
+	embeds := func(count int) *discordgo.MessageSend {
+		message := &discordgo.MessageSend{}
+		for i := 0; i < count; i++ {
+			message.Embeds = append(message.Embeds, &discordgo.MessageEmbed{Title: "x"})
+		}
+		return message
+	}
+
+	tests := []struct {
+		name     string
+		messages []*discordgo.MessageSend
+		// Content and number of embeds of each sent message
+		want []string
+	}{
+		{"single", []*discordgo.MessageSend{text("a")}, []string{"a/0"}},
+		{"texts", []*discordgo.MessageSend{text("a"), text("b"), text("c")}, []string{"a\nb\nc/0"}},
+		{"embeds", []*discordgo.MessageSend{embed("a"), text("b"), embed("c")}, []string{"b/2"}},
+		{"too long", []*discordgo.MessageSend{text(strings.Repeat("a", 1500)), text(strings.Repeat("b", 500)), text("c")}, []string{strings.Repeat("a", 1500) + "/0", strings.Repeat("b", 500) + "\nc/0"}},
+		{"exactly the limit", []*discordgo.MessageSend{text(strings.Repeat("a", 1000)), text(strings.Repeat("b", 999))}, []string{strings.Repeat("a", 1000) + "\n" + strings.Repeat("b", 999) + "/0"}},
+		{"too many embeds", []*discordgo.MessageSend{embeds(6), embeds(4), embeds(1)}, []string{"/10", "/1"}},
+		{"embeds too big", []*discordgo.MessageSend{embed(strings.Repeat("a", 4000)), embed(strings.Repeat("b", 2001))}, []string{"/1", "/1"}},
+		{"files stay alone", []*discordgo.MessageSend{text("a"), {Content: "b", Files: []*discordgo.File{{Name: "f"}}}, text("c")}, []string{"a/0", "b/0", "c/0"}},
+		{"components stay alone", []*discordgo.MessageSend{text("a"), {Content: "b", Components: []discordgo.MessageComponent{discordgo.ActionsRow{}}}}, []string{"a/0", "b/0"}},
+		{"replies stay alone", []*discordgo.MessageSend{text("a"), {Content: "b", Reference: &discordgo.MessageReference{MessageID: "1"}}}, []string{"a/0", "b/0"}},
+		{"different flags", []*discordgo.MessageSend{text("a"), {Content: "b", Flags: discordgo.MessageFlagsSuppressEmbeds}}, []string{"a/0", "b/0"}},
+		{"same mentions", []*discordgo.MessageSend{mentioning("a", "1", "2"), mentioning("b", "2", "1")}, []string{"a\nb/0"}},
+		{"different mentions", []*discordgo.MessageSend{mentioning("a", "1"), mentioning("b", "2")}, []string{"a/0", "b/0"}},
+		{"default and restricted mentions", []*discordgo.MessageSend{text("a"), mentioning("b")}, []string{"a/0", "b/0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, message := range coalesceMessages(test.messages) {
+				got = append(got, message.Content+"/"+strconv.Itoa(len(message.Embeds)))
+			}
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("sent %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Merging must not change the queued messages, they are logged when sending
+// fails
+func TestCoalesceMessagesCopies(t *testing.T) {
+	first := &discordgo.MessageSend{Content: "a", Embeds: make([]*discordgo.MessageEmbed, 1, 10)}
+	first.Embeds[0] = &discordgo.MessageEmbed{Title: "first"}
+	second := &discordgo.MessageSend{Content: "b", Embeds: []*discordgo.MessageEmbed{{Title: "second"}}}
+
+	coalesceMessages([]*discordgo.MessageSend{first, second})
+	if first.Content != "a" || len(first.Embeds) != 1 || first.Embeds[:2][1] != nil {
+		t.Fatalf("the first message was changed: %+v", first)
+	}
+}
+
+func TestWithNonce(t *testing.T) {
+	newRequest := func(method string, contentType string, body string) *http.Request {
+		request, err := http.NewRequest(method, "https://discord.com/api/v9/channels/1/messages", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", contentType)
+		return request
+	}
+
+	for _, test := range []struct {
+		name    string
+		request *http.Request
+		route   string
+	}{
+		{"other method", newRequest(http.MethodPatch, "application/json", `{"content":"a"}`), "/channels/:id/messages"},
+		{"other route", newRequest(http.MethodPost, "application/json", `{"name":"a"}`), "/guilds/:id/channels"},
+		{"attachments", newRequest(http.MethodPost, "multipart/form-data; boundary=x", "--x--"), "/channels/:id/messages"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nonced, err := withNonce(test.request, test.route)
+			if err != nil || nonced != nil {
+				t.Fatalf("got %v, %v, want no retryable request", nonced, err)
+			}
+		})
+	}
+
+	t.Run("invalid body is kept", func(t *testing.T) {
+		request := newRequest(http.MethodPost, "application/json", "not json")
+		nonced, err := withNonce(request, "/channels/:id/messages")
+		if err != nil || nonced != nil {
+			t.Fatalf("got %v, %v, want no retryable request", nonced, err)
+		}
+		body, _ := io.ReadAll(request.Body)
+		if string(body) != "not json" {
+			t.Fatalf("body became %q", body)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		nonced, err := withNonce(newRequest(http.MethodPost, "application/json", `{"content":"hello","tts":false}`), "/channels/:id/messages")
+		if err != nil || nonced == nil {
+			t.Fatalf("got %v, %v, want a retryable request", nonced, err)
+		}
+
+		nonces := []string{}
+		for i := 0; i < 2; i++ {
+			body, err := nonced.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload struct {
+				Content      string
+				Nonce        string
+				EnforceNonce bool `json:"enforce_nonce"`
+			}
+			err = json.NewDecoder(body).Decode(&payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload.Content != "hello" || payload.Nonce == "" || !payload.EnforceNonce {
+				t.Fatalf("unexpected payload %+v", payload)
+			}
+			nonces = append(nonces, payload.Nonce)
+		}
+		if nonces[0] != nonces[1] {
+			t.Fatalf("retries use different nonces %v", nonces)
+		}
+		if len(nonces[0]) > 25 {
+			t.Fatalf("nonce %q is longer than Discord allows", nonces[0])
+		}
+	})
+}
@@ -0,0 +1,830 @@
+// In-memory Discord API for tests
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type RecordedRoleChange struct {
+	GuildID string
+	UserID  string
+	RoleID  string
+	// False if the role was removed
+	Added bool
+}
+
+type RecordedBan struct {
+	GuildID    string
+	UserID     string
+	Reason     string
+	DeleteDays int
+}
+
+type RecordedKick struct {
+	GuildID string
+	UserID  string
+	Reason  string
+}
+
+type RecordedTimeout struct {
+	GuildID string
+	UserID  string
+	// Nil if the timeout was removed
+	Until *time.Time
+}
+
+type RecordedInteractionResponse struct {
+	InteractionID string
+	Response      *discordgo.InteractionResponse
+}
+
+// DiscordAPI that keeps everything in memory and records what modules do.
+// Guilds, members and channels can be set up beforehand, unknown ones are
+// created on first use so tests only need to set up what they check. Lock
+// Mutex when reading the records while modules may still be running.
+type RecordingDiscord struct {
+	sync.Mutex
+
+	UserID   string
+	Guilds   map[string]*discordgo.Guild
+	Channels map[string]*discordgo.Channel
+	// Keyed by guild ID, then user ID
+	Members map[string]map[string]*discordgo.Member
+
+	// Messages by channel ID, oldest first. DMs are in the DM channels,
+	// see DirectMessages.
+	Messages             map[string][]*discordgo.Message
+	RoleChanges          []RecordedRoleChange
+	Bans                 []RecordedBan
+	Kicks                []RecordedKick
+	Timeouts             []RecordedTimeout
+	InteractionResponses []RecordedInteractionResponse
+	// Followup messages by interaction ID
+	Followups map[string][]*discordgo.Message
+	// Names of the called methods, in order
+	Calls []string
+
+	// Returned by the method of that name instead of doing anything, e.g.
+	// "GuildMemberRoleAdd" to simulate missing permissions
+	Errors map[string]error
+
+	dmChannels map[string]string
+	nextID     int64
+}
+
+var _ DiscordAPI = (*RecordingDiscord)(nil)
+
+func NewRecordingDiscord() *RecordingDiscord {
+	return &RecordingDiscord{
+		UserID:     "1000000000000000001",
+		Guilds:     map[string]*discordgo.Guild{},
+		Channels:   map[string]*discordgo.Channel{},
+		Members:    map[string]map[string]*discordgo.Member{},
+		Messages:   map[string][]*discordgo.Message{},
+		Followups:  map[string][]*discordgo.Message{},
+		Errors:     map[string]error{},
+		dmChannels: map[string]string{},
+		nextID:     1000000000000000001,
+	}
+}
+
+func (d *RecordingDiscord) newID() string {
+	d.nextID++
+	return strconv.FormatInt(d.nextID, 10)
+}
+
+// Records the call and returns the error set up for it. The lock is held
+// by the caller.
+func (d *RecordingDiscord) call(method string) error {
+	d.Calls = append(d.Calls, method)
+	return d.Errors[method]
+}
+
+func (d *RecordingDiscord) guild(guildID string) *discordgo.Guild {
+	guild, ok := d.Guilds[guildID]
+	if !ok {
+		guild = &discordgo.Guild{ID: guildID, PreferredLocale: string(discordgo.EnglishUS)}
+		d.Guilds[guildID] = guild
+	}
+	return guild
+}
+
+func (d *RecordingDiscord) member(guildID string, userID string) *discordgo.Member {
+	members, ok := d.Members[guildID]
+	if !ok {
+		members = map[string]*discordgo.Member{}
+		d.Members[guildID] = members
+	}
+	member, ok := members[userID]
+	if !ok {
+		member = &discordgo.Member{GuildID: guildID, User: &discordgo.User{ID: userID}, Roles: []string{}}
+		members[userID] = member
+	}
+	return member
+}
+
+func (d *RecordingDiscord) channel(channelID string) *discordgo.Channel {
+	channel, ok := d.Channels[channelID]
+	if !ok {
+		channel = &discordgo.Channel{ID: channelID, Type: discordgo.ChannelTypeGuildText}
+		d.Channels[channelID] = channel
+	}
+	return channel
+}
+
+// Adds a member so GuildMember finds them and interactions can refer to them
+func (d *RecordingDiscord) AddMember(guildID string, user *discordgo.User, roles ...string) *discordgo.Member {
+	d.Lock()
+	defer d.Unlock()
+
+	member := d.member(guildID, user.ID)
+	member.User = user
+	member.Roles = roles
+	member.JoinedAt = time.Now()
+	return member
+}
+
+// Messages sent to a user's DMs
+func (d *RecordingDiscord) DirectMessages(userID string) []*discordgo.Message {
+	d.Lock()
+	defer d.Unlock()
+	return d.Messages[d.dmChannels[userID]]
+}
+
+func (d *RecordingDiscord) BotUserID() string {
+	return d.UserID
+}
+
+func (d *RecordingDiscord) CachedGuild(guildID string) *discordgo.Guild {
+	d.Lock()
+	defer d.Unlock()
+
+	guild := d.guild(guildID)
+	guild.MemberCount = len(d.Members[guildID])
+	return guild
+}
+
+func (d *RecordingDiscord) GuildWithCounts(guildID string, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildWithCounts")
+	if err != nil {
+		return nil, err
+	}
+	guild := d.guild(guildID)
+	guild.ApproximateMemberCount = len(d.Members[guildID])
+	return guild, nil
+}
+
+func (d *RecordingDiscord) GuildEdit(guildID string, params *discordgo.GuildParams, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildEdit")
+	if err != nil {
+		return nil, err
+	}
+	guild := d.guild(guildID)
+	if params.Name != "" {
+		guild.Name = params.Name
+	}
+	if params.VerificationLevel != nil {
+		guild.VerificationLevel = *params.VerificationLevel
+	}
+	return guild, nil
+}
+
+func (d *RecordingDiscord) GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildChannels")
+	if err != nil {
+		return nil, err
+	}
+	channels := []*discordgo.Channel{}
+	for _, channel := range d.Channels {
+		if channel.GuildID == guildID {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+func (d *RecordingDiscord) GuildMember(guildID string, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildMember")
+	if err != nil {
+		return nil, err
+	}
+	member, ok := d.Members[guildID][userID]
+	if !ok {
+		return nil, fmt.Errorf("unknown member %v", userID)
+	}
+	return member, nil
+}
+
+// Computed from the guild's roles and the channel's overwrites. Channels
+// without a guild allow everything.
+func (d *RecordingDiscord) UserChannelPermissions(userID string, channelID string, options ...discordgo.RequestOption) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("UserChannelPermissions")
+	if err != nil {
+		return 0, err
+	}
+	channel := d.channel(channelID)
+	if channel.GuildID == "" {
+		return discordgo.PermissionAll, nil
+	}
+	guild := d.guild(channel.GuildID)
+	if userID == guild.OwnerID {
+		return discordgo.PermissionAll, nil
+	}
+	member := d.member(guild.ID, userID)
+
+	var permissions int64
+	for _, role := range guild.Roles {
+		if role.ID == guild.ID || slices.Contains(member.Roles, role.ID) {
+			permissions |= role.Permissions
+		}
+	}
+	if permissions&discordgo.PermissionAdministrator != 0 {
+		return discordgo.PermissionAll, nil
+	}
+
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.ID == guild.ID {
+			permissions = permissions&^overwrite.Deny | overwrite.Allow
+		}
+	}
+	var allow, deny int64
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeRole && slices.Contains(member.Roles, overwrite.ID) {
+			allow |= overwrite.Allow
+			deny |= overwrite.Deny
+		}
+	}
+	permissions = permissions&^deny | allow
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeMember && overwrite.ID == userID {
+			permissions = permissions&^overwrite.Deny | overwrite.Allow
+		}
+	}
+	return permissions, nil
+}
+
+func (d *RecordingDiscord) changeRole(method string, guildID string, userID string, roleID string, added bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call(method)
+	if err != nil {
+		return err
+	}
+
+	member := d.member(guildID, userID)
+	roles := []string{}
+	for _, id := range member.Roles {
+		if id != roleID {
+			roles = append(roles, id)
+		}
+	}
+	if added {
+		roles = append(roles, roleID)
+	}
+	member.Roles = roles
+
+	d.RoleChanges = append(d.RoleChanges, RecordedRoleChange{GuildID: guildID, UserID: userID, RoleID: roleID, Added: added})
+	return nil
+}
+
+func (d *RecordingDiscord) GuildMemberRoleAdd(guildID string, userID string, roleID string, options ...discordgo.RequestOption) error {
+	return d.changeRole("GuildMemberRoleAdd", guildID, userID, roleID, true)
+}
+
+func (d *RecordingDiscord) GuildMemberRoleRemove(guildID string, userID string, roleID string, options ...discordgo.RequestOption) error {
+	return d.changeRole("GuildMemberRoleRemove", guildID, userID, roleID, false)
+}
+
+func (d *RecordingDiscord) GuildMemberTimeout(guildID string, userID string, until *time.Time, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildMemberTimeout")
+	if err != nil {
+		return err
+	}
+	d.member(guildID, userID).CommunicationDisabledUntil = until
+	d.Timeouts = append(d.Timeouts, RecordedTimeout{GuildID: guildID, UserID: userID, Until: until})
+	return nil
+}
+
+func (d *RecordingDiscord) GuildMemberDeleteWithReason(guildID string, userID string, reason string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildMemberDeleteWithReason")
+	if err != nil {
+		return err
+	}
+	delete(d.Members[guildID], userID)
+	d.Kicks = append(d.Kicks, RecordedKick{GuildID: guildID, UserID: userID, Reason: reason})
+	return nil
+}
+
+func (d *RecordingDiscord) GuildBanCreateWithReason(guildID string, userID string, reason string, days int, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildBanCreateWithReason")
+	if err != nil {
+		return err
+	}
+	delete(d.Members[guildID], userID)
+	d.Bans = append(d.Bans, RecordedBan{GuildID: guildID, UserID: userID, Reason: reason, DeleteDays: days})
+	return nil
+}
+
+func (d *RecordingDiscord) GuildBanDelete(guildID string, userID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildBanDelete")
+	if err != nil {
+		return err
+	}
+	for i, ban := range d.Bans {
+		if ban.GuildID == guildID && ban.UserID == userID {
+			d.Bans = append(d.Bans[:i], d.Bans[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ban %v", userID)
+}
+
+func (d *RecordingDiscord) GuildChannelCreateComplex(guildID string, data discordgo.GuildChannelCreateData, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("GuildChannelCreateComplex")
+	if err != nil {
+		return nil, err
+	}
+	channel := &discordgo.Channel{
+		ID:                   d.newID(),
+		GuildID:              guildID,
+		Name:                 data.Name,
+		Type:                 data.Type,
+		Topic:                data.Topic,
+		ParentID:             data.ParentID,
+		PermissionOverwrites: data.PermissionOverwrites,
+	}
+	d.Channels[channel.ID] = channel
+	return channel, nil
+}
+
+func (d *RecordingDiscord) ChannelEdit(channelID string, data *discordgo.ChannelEdit, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelEdit")
+	if err != nil {
+		return nil, err
+	}
+	channel := d.channel(channelID)
+	if data.Name != "" {
+		channel.Name = data.Name
+	}
+	if data.Topic != "" {
+		channel.Topic = data.Topic
+	}
+	if data.PermissionOverwrites != nil {
+		channel.PermissionOverwrites = data.PermissionOverwrites
+	}
+	if channel.ThreadMetadata != nil {
+		if data.Archived != nil {
+			channel.ThreadMetadata.Archived = *data.Archived
+		}
+		if data.Locked != nil {
+			channel.ThreadMetadata.Locked = *data.Locked
+		}
+	}
+	return channel, nil
+}
+
+func (d *RecordingDiscord) ChannelDelete(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelDelete")
+	if err != nil {
+		return nil, err
+	}
+	channel := d.channel(channelID)
+	delete(d.Channels, channelID)
+	return channel, nil
+}
+
+func (d *RecordingDiscord) ChannelPermissionSet(channelID string, targetID string, targetType discordgo.PermissionOverwriteType, allow int64, deny int64, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelPermissionSet")
+	if err != nil {
+		return err
+	}
+	channel := d.channel(channelID)
+	overwrites := []*discordgo.PermissionOverwrite{}
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.ID != targetID {
+			overwrites = append(overwrites, overwrite)
+		}
+	}
+	channel.PermissionOverwrites = append(overwrites, &discordgo.PermissionOverwrite{
+		ID:    targetID,
+		Type:  targetType,
+		Allow: allow,
+		Deny:  deny,
+	})
+	return nil
+}
+
+func (d *RecordingDiscord) ChannelPermissionDelete(channelID string, targetID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelPermissionDelete")
+	if err != nil {
+		return err
+	}
+	channel := d.channel(channelID)
+	overwrites := []*discordgo.PermissionOverwrite{}
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.ID != targetID {
+			overwrites = append(overwrites, overwrite)
+		}
+	}
+	channel.PermissionOverwrites = overwrites
+	return nil
+}
+
+func (d *RecordingDiscord) startThread(parentID string, data *discordgo.ThreadStart) *discordgo.Channel {
+	parent := d.channel(parentID)
+	thread := &discordgo.Channel{
+		ID:             d.newID(),
+		GuildID:        parent.GuildID,
+		ParentID:       parentID,
+		Name:           data.Name,
+		Type:           data.Type,
+		ThreadMetadata: &discordgo.ThreadMetadata{AutoArchiveDuration: data.AutoArchiveDuration},
+	}
+	if thread.Type == 0 {
+		thread.Type = discordgo.ChannelTypeGuildPublicThread
+	}
+	d.Channels[thread.ID] = thread
+	return thread
+}
+
+func (d *RecordingDiscord) ThreadStartComplex(channelID string, data *discordgo.ThreadStart, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ThreadStartComplex")
+	if err != nil {
+		return nil, err
+	}
+	return d.startThread(channelID, data), nil
+}
+
+func (d *RecordingDiscord) ForumThreadStartComplex(channelID string, threadData *discordgo.ThreadStart, messageData *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ForumThreadStartComplex")
+	if err != nil {
+		return nil, err
+	}
+	thread := d.startThread(channelID, threadData)
+	d.storeMessage(thread.ID, messageData)
+	return thread, nil
+}
+
+func (d *RecordingDiscord) ThreadMemberAdd(threadID string, memberID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.call("ThreadMemberAdd")
+}
+
+func (d *RecordingDiscord) ThreadMemberRemove(threadID string, memberID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.call("ThreadMemberRemove")
+}
+
+func (d *RecordingDiscord) findMessage(channelID string, messageID string) (*discordgo.Message, int) {
+	for i, message := range d.Messages[channelID] {
+		if message.ID == messageID {
+			return message, i
+		}
+	}
+	return nil, -1
+}
+
+func (d *RecordingDiscord) ChannelMessage(channelID string, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelMessage")
+	if err != nil {
+		return nil, err
+	}
+	message, _ := d.findMessage(channelID, messageID)
+	if message == nil {
+		return nil, fmt.Errorf("unknown message %v", messageID)
+	}
+	return message, nil
+}
+
+// Newest first like the API. Only limit and beforeID are supported.
+func (d *RecordingDiscord) ChannelMessages(channelID string, limit int, beforeID string, afterID string, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelMessages")
+	if err != nil {
+		return nil, err
+	}
+	stored := d.Messages[channelID]
+	if beforeID != "" {
+		_, i := d.findMessage(channelID, beforeID)
+		if i >= 0 {
+			stored = stored[:i]
+		}
+	}
+	messages := []*discordgo.Message{}
+	for i := len(stored) - 1; i >= 0 && len(messages) < limit; i-- {
+		messages = append(messages, stored[i])
+	}
+	return messages, nil
+}
+
+func (d *RecordingDiscord) storeMessage(channelID string, data *discordgo.MessageSend) *discordgo.Message {
+	message := &discordgo.Message{
+		ID:         d.newID(),
+		ChannelID:  channelID,
+		GuildID:    d.channel(channelID).GuildID,
+		Content:    data.Content,
+		Embeds:     data.Embeds,
+		Components: data.Components,
+		Author:     &discordgo.User{ID: d.UserID, Bot: true},
+		Timestamp:  time.Now(),
+	}
+	for _, file := range data.Files {
+		message.Attachments = append(message.Attachments, &discordgo.MessageAttachment{
+			ID:          d.newID(),
+			Filename:    file.Name,
+			ContentType: file.ContentType,
+		})
+	}
+	d.Messages[channelID] = append(d.Messages[channelID], message)
+	return message
+}
+
+func (d *RecordingDiscord) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.sendMessage("ChannelMessageSend", channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (d *RecordingDiscord) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.sendMessage("ChannelMessageSendEmbed", channelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}})
+}
+
+func (d *RecordingDiscord) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.sendMessage("ChannelMessageSendComplex", channelID, data)
+}
+
+// Sends right away, failures only show in Calls and Messages
+func (d *RecordingDiscord) Announce(channelID string, message *discordgo.MessageSend) {
+	d.sendMessage("Announce", channelID, message)
+}
+
+func (d *RecordingDiscord) sendMessage(method string, channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call(method)
+	if err != nil {
+		return nil, err
+	}
+	return d.storeMessage(channelID, data), nil
+}
+
+func (d *RecordingDiscord) editMessage(method string, edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call(method)
+	if err != nil {
+		return nil, err
+	}
+	message, _ := d.findMessage(edit.Channel, edit.ID)
+	if message == nil {
+		return nil, fmt.Errorf("unknown message %v", edit.ID)
+	}
+	if edit.Content != nil {
+		message.Content = *edit.Content
+	}
+	if edit.Embeds != nil {
+		message.Embeds = *edit.Embeds
+	}
+	if edit.Components != nil {
+		message.Components = *edit.Components
+	}
+	now := time.Now()
+	message.EditedTimestamp = &now
+	return message, nil
+}
+
+func (d *RecordingDiscord) ChannelMessageEditComplex(edit *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.editMessage("ChannelMessageEditComplex", edit)
+}
+
+func (d *RecordingDiscord) ChannelMessageEditEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.editMessage("ChannelMessageEditEmbed", discordgo.NewMessageEdit(channelID, messageID).SetEmbed(embed))
+}
+
+func (d *RecordingDiscord) ChannelMessageDelete(channelID string, messageID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelMessageDelete")
+	if err != nil {
+		return err
+	}
+	_, i := d.findMessage(channelID, messageID)
+	if i < 0 {
+		return fmt.Errorf("unknown message %v", messageID)
+	}
+	d.Messages[channelID] = append(d.Messages[channelID][:i], d.Messages[channelID][i+1:]...)
+	return nil
+}
+
+func (d *RecordingDiscord) ChannelMessagesBulkDelete(channelID string, messages []string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("ChannelMessagesBulkDelete")
+	if err != nil {
+		return err
+	}
+	if len(messages) < 2 || len(messages) > 100 {
+		return fmt.Errorf("bulk delete of %v messages", len(messages))
+	}
+	for _, messageID := range messages {
+		_, i := d.findMessage(channelID, messageID)
+		if i >= 0 {
+			d.Messages[channelID] = append(d.Messages[channelID][:i], d.Messages[channelID][i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (d *RecordingDiscord) MessageReactionAdd(channelID string, messageID string, emojiID string, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("MessageReactionAdd")
+	if err != nil {
+		return err
+	}
+	message, _ := d.findMessage(channelID, messageID)
+	if message != nil {
+		message.Reactions = append(message.Reactions, &discordgo.MessageReactions{
+			Count: 1,
+			Me:    true,
+			Emoji: &discordgo.Emoji{Name: emojiID},
+		})
+	}
+	return nil
+}
+
+func (d *RecordingDiscord) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("UserChannelCreate")
+	if err != nil {
+		return nil, err
+	}
+	channelID, ok := d.dmChannels[recipientID]
+	if !ok {
+		channelID = d.newID()
+		d.dmChannels[recipientID] = channelID
+		d.Channels[channelID] = &discordgo.Channel{
+			ID:         channelID,
+			Type:       discordgo.ChannelTypeDM,
+			Recipients: []*discordgo.User{{ID: recipientID}},
+		}
+	}
+	return d.Channels[channelID], nil
+}
+
+func (d *RecordingDiscord) SendDM(userID string, message *discordgo.MessageSend) error {
+	dmChannel, err := d.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = d.ChannelMessageSendComplex(dmChannel.ID, message)
+	return err
+}
+
+func (d *RecordingDiscord) InteractionRespond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("InteractionRespond")
+	if err != nil {
+		return err
+	}
+	d.InteractionResponses = append(d.InteractionResponses, RecordedInteractionResponse{
+		InteractionID: interaction.ID,
+		Response:      response,
+	})
+	return nil
+}
+
+func (d *RecordingDiscord) DeferEphemeral(interaction *discordgo.Interaction) error {
+	return d.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+func (d *RecordingDiscord) FollowupEphemeral(interaction *discordgo.Interaction, content string, embeds ...*discordgo.MessageEmbed) error {
+	_, err := d.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
+		Content: content,
+		Embeds:  embeds,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	return err
+}
+
+func (d *RecordingDiscord) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("FollowupMessageCreate")
+	if err != nil {
+		return nil, err
+	}
+	message := &discordgo.Message{
+		ID:         d.newID(),
+		ChannelID:  interaction.ChannelID,
+		Content:    data.Content,
+		Embeds:     data.Embeds,
+		Components: data.Components,
+		Flags:      data.Flags,
+		Author:     &discordgo.User{ID: d.UserID, Bot: true},
+		Timestamp:  time.Now(),
+	}
+	d.Followups[interaction.ID] = append(d.Followups[interaction.ID], message)
+	return message, nil
+}
+
+func (d *RecordingDiscord) FollowupMessageEdit(interaction *discordgo.Interaction, messageID string, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := d.call("FollowupMessageEdit")
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range d.Followups[interaction.ID] {
+		if message.ID == messageID {
+			if data.Content != nil {
+				message.Content = *data.Content
+			}
+			if data.Embeds != nil {
+				message.Embeds = *data.Embeds
+			}
+			if data.Components != nil {
+				message.Components = *data.Components
+			}
+			return message, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown followup %v", messageID)
+}
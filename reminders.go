@@ -0,0 +1,507 @@
+// Reminders module
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type RemindersConfig struct {
+	// Pending reminders a member can have, defaults to 25
+	MaxPerUser int
+	// Time zone of cron schedules of recurring reminders, defaults to the
+	// time zone of the system
+	Timezone string
+}
+
+func (c *RemindersConfig) Validate() error {
+	if c.MaxPerUser < 0 {
+		return Errorf("Reminders.MaxPerUser can't be negative")
+	}
+	_, err := LoadTimezone(c.Timezone)
+	if err != nil {
+		return Errorf("Reminders.Timezone: %w", err)
+	}
+	return nil
+}
+
+type Reminder struct {
+	ID        int
+	GuildID   string
+	ChannelID string
+	UserID    string
+	Content   string
+	RemindAt  time.Time
+	// Schedule of recurring reminders, empty for one-off reminders
+	Repeat    string
+	DM        bool
+	CreatedAt time.Time
+}
+
+// Reminders this late get a note saying so
+const reminderLateAfter = time.Minute
+
+// Recurring reminders can't repeat more often than this
+const reminderMinRepeat = time.Hour
+
+// Reminders further away are refused
+const reminderMaxYears = 100
+
+var remindersLog = ModuleLogger("reminders")
+
+type RemindersModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *RemindersConfig
+
+	// Config.MaxPerUser and Config.Timezone with their defaults
+	maxPerUser int
+	location   *time.Location
+}
+
+const remindersSchema = `
+CREATE TABLE IF NOT EXISTS reminders (
+	id         SERIAL PRIMARY KEY,
+	guild_id   TEXT NOT NULL DEFAULT '',
+	channel_id TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	remind_at  TIMESTAMPTZ NOT NULL,
+	repeat     TEXT NOT NULL DEFAULT '',
+	dm         BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS reminders_due ON reminders (remind_at);
+CREATE INDEX IF NOT EXISTS reminders_user ON reminders (user_id);
+`
+
+const reminderColumns = `id, guild_id, channel_id, user_id, content, remind_at, repeat, dm, created_at`
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "reminders", NeedsDB: true}, func(config *Config) *RemindersConfig {
+		return config.Reminders
+	}, NewRemindersModule)
+}
+
+func NewRemindersModule(config *RemindersConfig) *RemindersModule {
+	m := &RemindersModule{
+		Config:     config,
+		maxPerUser: config.MaxPerUser,
+	}
+	if m.maxPerUser == 0 {
+		m.maxPerUser = 25
+	}
+	// Validate already loaded it once
+	m.location, _ = LoadTimezone(config.Timezone)
+	return m
+}
+
+func (m *RemindersModule) Register(bot *Bot) error {
+	remindersLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(remindersSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:        "remind",
+		Description: "Get reminded of something later",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "me",
+				Description: "Set a reminder",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "in",
+						Description: `When, like "2h", "30 minutes" or "a day and 3 hours"`,
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "what",
+						Description: "What to remind you of",
+						Required:    true,
+						MaxLength:   1500,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "repeat",
+						Description: `Repeat the reminder, like "every day" or "0 9 * * mon"`,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "dm",
+						Description: "Send the reminder to your DMs instead of this channel",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List your reminders",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "delete",
+				Description: "Delete one of your reminders",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "id",
+						Description: "Reminder number, see /remind list",
+						Required:    true,
+						MinValue:    Ptr(1.0),
+					},
+				},
+			},
+		},
+	}, m.RemindCommand)
+
+	bot.Scheduler.Every("reminders", 15*time.Second, m.SendDueReminders)
+	return nil
+}
+
+// "an hour" is one hour
+var reminderArticlePattern = regexp.MustCompile(`\ban?\s+([a-z])`)
+
+var reminderYearsPattern = regexp.MustCompile(`(\d+)\s*(years?|y)\b`)
+
+// Parses when a reminder is due. On top of ParseDuration it understands the
+// way people phrase reminders, like "in a week", "tomorrow" or "1 year".
+func parseReminderDelay(text string) (time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	text = strings.TrimPrefix(text, "in ")
+	if text == "tomorrow" {
+		return 24 * time.Hour, nil
+	}
+	text = reminderArticlePattern.ReplaceAllString(text, "1 $1")
+
+	var total time.Duration
+	years := 0
+	for _, match := range reminderYearsPattern.FindAllStringSubmatch(text, -1) {
+		count, err := strconv.Atoi(match[1])
+		if err != nil {
+			return 0, Errorf("invalid duration %q", text)
+		}
+		years += count
+		// Far beyond any reminder, and far below an overflow
+		if count > reminderMaxYears || years > reminderMaxYears {
+			return 0, Errorf("duration %q is too long", text)
+		}
+		total += time.Duration(count) * 365 * 24 * time.Hour
+	}
+	rest := strings.TrimSpace(reminderYearsPattern.ReplaceAllString(text, ""))
+	if total > 0 && rest == "" {
+		return total, nil
+	}
+
+	duration, err := ParseDuration(rest)
+	if err != nil {
+		return 0, err
+	}
+	if total+duration < total {
+		return 0, Errorf("duration %q is too long", text)
+	}
+	return total + duration, nil
+}
+
+// Accepts schedules with or without "every", so "1 day" repeats daily
+func (m *RemindersModule) parseRepeat(text string) (Schedule, error) {
+	schedule, err := ParseSchedule(text, m.location)
+	if err != nil {
+		var retryErr error
+		schedule, retryErr = ParseSchedule("every "+text, m.location)
+		if retryErr != nil {
+			return nil, err
+		}
+	}
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		return nil, Errorf("%q never happens", text)
+	}
+	// Cron expressions can run at uneven gaps, so check a day's worth of runs
+	for i := 0; i < 24; i++ {
+		following := schedule.Next(next)
+		if following.IsZero() {
+			break
+		}
+		if following.Sub(next) < reminderMinRepeat {
+			return nil, Errorf("%q repeats more often than every %s", text, FormatDuration(reminderMinRepeat))
+		}
+		next = following
+	}
+	return schedule, nil
+}
+
+func scanReminder(row interface{ Scan(...any) error }) (*Reminder, error) {
+	reminder := &Reminder{}
+	err := row.Scan(&reminder.ID, &reminder.GuildID, &reminder.ChannelID, &reminder.UserID, &reminder.Content,
+		&reminder.RemindAt, &reminder.Repeat, &reminder.DM, &reminder.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return reminder, nil
+}
+
+func (m *RemindersModule) RemindCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+	user := InteractionUser(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	switch subcommand {
+	case "me":
+		return m.createReminder(interaction, options)
+
+	case "list":
+		return m.listReminders(interaction)
+
+	case "delete":
+		data := NewTemplateData()
+		data.ID = int(OptionInt(options, "id"))
+
+		result, err := m.DB.Exec(`DELETE FROM reminders WHERE id = $1 AND user_id = $2`, data.ID, user.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return WrapError(err)
+		}
+		if deleted == 0 {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.not_found", data, locales...))
+		}
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.deleted", data, locales...))
+	}
+	return nil
+}
+
+func (m *RemindersModule) createReminder(interaction *discordgo.Interaction, options map[string]*discordgo.ApplicationCommandInteractionDataOption) error {
+	locales := InteractionLocales(interaction)
+	user := InteractionUser(interaction)
+	data := NewTemplateData()
+
+	delay, err := parseReminderDelay(OptionString(options, "in"))
+	if err != nil || delay < time.Minute {
+		data.Input = OptionString(options, "in")
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.invalid_duration", data, locales...))
+	}
+
+	reminder := &Reminder{
+		GuildID:   interaction.GuildID,
+		ChannelID: interaction.ChannelID,
+		UserID:    user.ID,
+		Content:   OptionString(options, "what"),
+		RemindAt:  time.Now().Add(delay),
+		// Outside of servers the channel is the DM channel anyway
+		DM: OptionBool(options, "dm") || interaction.GuildID == "",
+	}
+
+	// Reminders are posted for the member, so they have to be allowed to
+	// post in the channel themselves
+	sendPermissions := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages)
+	if !reminder.DM && interaction.Member.Permissions&sendPermissions != sendPermissions {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.no_send_permission", data, locales...))
+	}
+
+	if repeat := OptionString(options, "repeat"); repeat != "" {
+		schedule, err := m.parseRepeat(repeat)
+		if err != nil {
+			data.Error = err.Error()
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.invalid_repeat", data, locales...))
+		}
+		reminder.Repeat = schedule.String()
+	}
+
+	var pending int
+	err = m.DB.QueryRow(`SELECT count(*) FROM reminders WHERE user_id = $1`, user.ID).Scan(&pending)
+	if err != nil {
+		return WrapError(err)
+	}
+	if pending >= m.maxPerUser {
+		data.Count = m.maxPerUser
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.too_many", data, locales...))
+	}
+
+	err = m.DB.QueryRow(`
+		INSERT INTO reminders (guild_id, channel_id, user_id, content, remind_at, repeat, dm)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		reminder.GuildID, reminder.ChannelID, reminder.UserID, reminder.Content, reminder.RemindAt, reminder.Repeat, reminder.DM,
+	).Scan(&reminder.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	data.ID = reminder.ID
+	data.Time = fmt.Sprintf("<t:%d:R>", reminder.RemindAt.Unix())
+	key := "reminders.created"
+	if reminder.Repeat != "" {
+		data.Duration = reminder.Repeat
+		key = "reminders.created_repeating"
+	}
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text(key, data, locales...))
+}
+
+func (m *RemindersModule) listReminders(interaction *discordgo.Interaction) error {
+	locales := InteractionLocales(interaction)
+	user := InteractionUser(interaction)
+
+	rows, err := m.DB.Query(`SELECT `+reminderColumns+` FROM reminders WHERE user_id = $1 ORDER BY remind_at`, user.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		line := fmt.Sprintf("**%d** <t:%d:R> %s", reminder.ID, reminder.RemindAt.Unix(), truncateText(reminder.Content, 80))
+		if reminder.Repeat != "" {
+			line += fmt.Sprintf(" (`%s`)", reminder.Repeat)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	if len(lines) == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("reminders.none", nil, locales...))
+	}
+	return m.Discord.FollowupEphemeral(interaction, truncateText(strings.Join(lines, "\n"), 2000))
+}
+
+func (m *RemindersModule) SendDueReminders(now time.Time) error {
+	rows, err := m.DB.Query(`SELECT `+reminderColumns+` FROM reminders WHERE remind_at <= $1 ORDER BY remind_at`, now)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	due := []*Reminder{}
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			rows.Close()
+			return WrapError(err)
+		}
+		due = append(due, reminder)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	for _, reminder := range due {
+		err = m.send(reminder, now)
+		if err != nil && !permanentSendFailure(err) {
+			// Stays due and goes out late once Discord works again
+			remindersLog.Warn("Could not send reminder, retrying", "reminder", reminder.ID, "user", reminder.UserID, "error", ErrorToStr(err))
+			continue
+		}
+		if err != nil {
+			remindersLog.Warn("Could not send reminder", "reminder", reminder.ID, "user", reminder.UserID, "error", ErrorToStr(err))
+		}
+
+		err = m.reschedule(reminder, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether sending failed in a way retrying won't fix, like a deleted channel
+// or closed DMs
+func permanentSendFailure(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+	switch restErr.Message.Code {
+	case discordgo.ErrCodeUnknownChannel, discordgo.ErrCodeUnknownGuild, discordgo.ErrCodeUnknownUser,
+		discordgo.ErrCodeMissingAccess, discordgo.ErrCodeMissingPermissions, discordgo.ErrCodeCannotSendMessagesToThisUser:
+		return true
+	}
+	return false
+}
+
+// Moves recurring reminders to their next time and deletes the others
+func (m *RemindersModule) reschedule(reminder *Reminder, now time.Time) error {
+	if reminder.Repeat != "" {
+		schedule, err := m.parseRepeat(reminder.Repeat)
+		if err == nil {
+			// Times missed while the bot was down are skipped
+			_, err = m.DB.Exec(`UPDATE reminders SET remind_at = $1 WHERE id = $2`, schedule.Next(now), reminder.ID)
+			if err != nil {
+				return WrapError(err)
+			}
+			return nil
+		}
+		remindersLog.Warn("Reminder stops repeating", "reminder", reminder.ID, "user", reminder.UserID, "error", err)
+	}
+
+	_, err := m.DB.Exec(`DELETE FROM reminders WHERE id = $1`, reminder.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *RemindersModule) send(reminder *Reminder, now time.Time) error {
+	var locales []discordgo.Locale
+	if reminder.GuildID != "" {
+		locales = GuildLocales(m.Discord.CachedGuild(reminder.GuildID))
+	}
+
+	data := NewTemplateData()
+	data.User = NewTemplateUserFromID(reminder.UserID)
+	data.Text = reminder.Content
+
+	lines := []string{m.Localizer.Text("reminders.reminder", data, locales...)}
+	if late := now.Sub(reminder.RemindAt); late > reminderLateAfter {
+		data.Duration = FormatDuration(late)
+		lines = append(lines, m.Localizer.Text("reminders.late", data, locales...))
+	}
+
+	message := &discordgo.MessageSend{
+		Content: strings.Join(lines, "\n"),
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Users: []string{reminder.UserID},
+		},
+	}
+
+	if reminder.DM {
+		return m.Discord.SendDM(reminder.UserID, message)
+	}
+	_, err := m.Discord.ChannelMessageSendComplex(reminder.ChannelID, message)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
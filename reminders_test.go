@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReminderDelay(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  time.Duration
+		valid bool
+	}{
+		{"2h", 2 * time.Hour, true},
+		{"in 2 hours", 2 * time.Hour, true},
+		{"In 30 Minutes", 30 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"1 hour and 30 minutes", 90 * time.Minute, true},
+		{"in an hour", time.Hour, true},
+		{"a week", 7 * 24 * time.Hour, true},
+		{"tomorrow", 24 * time.Hour, true},
+		{"1 year", 365 * 24 * time.Hour, true},
+		{"2 years 3 days", (2*365 + 3) * 24 * time.Hour, true},
+		{"in a year and a day", 366 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"2 hours ago", 0, false},
+		{"an apple", 0, false},
+		{"101 years", 0, false},
+		{"99999999999999 years", 0, false},
+		{"50 years 50 years 50 years", 0, false},
+		{"100 years 15000 weeks", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			delay, err := parseReminderDelay(test.text)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("%q was accepted as %v", test.text, delay)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if delay != test.want {
+				t.Fatalf("%q is %v, want %v", test.text, delay, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRepeat(t *testing.T) {
+	module := NewRemindersModule(&RemindersConfig{Timezone: "Europe/Berlin"})
+
+	tests := []struct {
+		text  string
+		want  string
+		valid bool
+	}{
+		{"every day", "every 1 day", true},
+		{"1 day", "every 1 day", true},
+		{"1 week", "every 7 days", true},
+		{"every 2 hours", "every 2 hours", true},
+		{"@daily", "0 0 * * *", true},
+		{"0 9 * * mon-fri", "0 9 * * mon-fri", true},
+		{"0 9 * * mon-sun", "0 9 * * mon-sun", true},
+		{"0 * * * *", "0 * * * *", true},
+		{"0 9,10 * * *", "0 9,10 * * *", true},
+		// Less than an hour apart
+		{"every 30 minutes", "", false},
+		{"30m", "", false},
+		{"*/30 * * * *", "", false},
+		{"0,30 9 * * *", "", false},
+		{"0 0 31 2 *", "", false},
+		{"daily", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			schedule, err := module.parseRepeat(test.text)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("%q was accepted as %v", test.text, schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schedule.String() != test.want {
+				t.Fatalf("%q became %q, want %q", test.text, schedule.String(), test.want)
+			}
+		})
+	}
+}
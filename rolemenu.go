@@ -0,0 +1,774 @@
+// Role menu module
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type RoleMenuConfig struct {
+	// Roles that can be put in a menu. Empty allows every role the bot can
+	// hand out
+	AllowedRoles []string
+}
+
+type RoleMenuStyle string
+
+const (
+	RoleMenuButtons RoleMenuStyle = "buttons"
+	RoleMenuSelect  RoleMenuStyle = "select"
+)
+
+type RoleMenuMode string
+
+const (
+	// Every role can be toggled on and off on its own
+	RoleMenuToggle RoleMenuMode = "toggle"
+	// Picking a role removes the other roles of the menu
+	RoleMenuSingle RoleMenuMode = "single"
+	// The selection replaces the member's roles of the menu, select menus only
+	RoleMenuMulti RoleMenuMode = "multi"
+)
+
+// Discord allows 5 rows of 5 buttons, and 25 select menu options
+const roleMenuMaxOptions = 25
+
+// Roles with any of these can't be put in a menu, whatever their position
+const roleMenuForbiddenPermissions = discordgo.PermissionAdministrator | discordgo.PermissionManageServer |
+	discordgo.PermissionManageRoles | discordgo.PermissionBanMembers | discordgo.PermissionKickMembers
+
+type RoleMenu struct {
+	ID          int
+	GuildID     string
+	ChannelID   string
+	MessageID   string
+	Title       string
+	Description string
+	Style       RoleMenuStyle
+	Mode        RoleMenuMode
+	// Most roles of this menu a member can hold, 0 for no limit
+	MaxRoles int
+	// Only members with this role can use the menu
+	RequiredRole string
+	Options      []*RoleMenuOption
+}
+
+type RoleMenuOption struct {
+	RoleID      string
+	Label       string
+	Emoji       string
+	Description string
+}
+
+var rolemenuLog = ModuleLogger("rolemenu")
+
+type RoleMenuModule struct {
+	Config    *RoleMenuConfig
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+}
+
+const roleMenuSchema = `
+CREATE TABLE IF NOT EXISTS role_menus (
+	id            SERIAL PRIMARY KEY,
+	guild_id      TEXT NOT NULL,
+	channel_id    TEXT NOT NULL,
+	message_id    TEXT NOT NULL DEFAULT '',
+	title         TEXT NOT NULL,
+	description   TEXT NOT NULL DEFAULT '',
+	style         TEXT NOT NULL,
+	mode          TEXT NOT NULL,
+	max_roles     INTEGER NOT NULL DEFAULT 0,
+	required_role TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_menu_options (
+	menu_id     INTEGER NOT NULL REFERENCES role_menus (id) ON DELETE CASCADE,
+	role_id     TEXT NOT NULL,
+	label       TEXT NOT NULL,
+	emoji       TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	position    SERIAL,
+	PRIMARY KEY (menu_id, role_id)
+);
+`
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "rolemenu", NeedsDB: true}, func(config *Config) *RoleMenuConfig {
+		return config.RoleMenus
+	}, NewRoleMenuModule)
+}
+
+func NewRoleMenuModule(config *RoleMenuConfig) *RoleMenuModule {
+	return &RoleMenuModule{Config: config}
+}
+
+func (m *RoleMenuModule) Register(bot *Bot) error {
+	rolemenuLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(roleMenuSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	menuOption := func() *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "menu",
+			Description: "Menu number, see /rolemenu list",
+			Required:    true,
+			MinValue:    Ptr(1.0),
+		}
+	}
+	roleOption := func(description string) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionRole,
+			Name:        "role",
+			Description: description,
+			Required:    true,
+		}
+	}
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:                     "rolemenu",
+		Description:              "Manage self-assignable role menus",
+		DefaultMemberPermissions: Ptr(int64(discordgo.PermissionManageRoles)),
+		DMPermission:             Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Post a new role menu",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "title",
+						Description: "Title of the menu",
+						Required:    true,
+						MaxLength:   256,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "style",
+						Description: "Buttons or a select menu",
+						Required:    true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "Buttons", Value: string(RoleMenuButtons)},
+							{Name: "Select menu", Value: string(RoleMenuSelect)},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "mode",
+						Description: "How roles are picked",
+						Required:    true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "Toggle each role", Value: string(RoleMenuToggle)},
+							{Name: "Single choice", Value: string(RoleMenuSingle)},
+							{Name: "Multiple choice (select menu)", Value: string(RoleMenuMulti)},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "description",
+						Description: "Text shown above the roles",
+						MaxLength:   2000,
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Where to post the menu, defaults to this channel",
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "max",
+						Description: "Most roles of this menu a member can have",
+						MinValue:    Ptr(1.0),
+						MaxValue:    roleMenuMaxOptions,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "required_role",
+						Description: "Only members with this role can use the menu",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Add a role to a menu",
+				Options: []*discordgo.ApplicationCommandOption{
+					menuOption(),
+					roleOption("Role to add"),
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "label",
+						Description: "Shown on the button or option, defaults to the role name",
+						MaxLength:   80,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "emoji",
+						Description: "Emoji shown next to the label",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "description",
+						Description: "Shown below the option, select menus only",
+						MaxLength:   100,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Remove a role from a menu",
+				Options: []*discordgo.ApplicationCommandOption{
+					menuOption(),
+					roleOption("Role to remove"),
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "delete",
+				Description: "Delete a menu and its message",
+				Options:     []*discordgo.ApplicationCommandOption{menuOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List the role menus of this server",
+			},
+		},
+	}, m.RoleMenuCommand)
+
+	bot.Router.AddComponent("RoleMenuButton", m.RoleMenuButtonClick)
+	bot.Router.AddComponent("RoleMenuSelect", m.RoleMenuSelectSubmit)
+	return nil
+}
+
+// Whether the role is below the highest role of both the member adding it and
+// the bot, which hands it out. Otherwise members could give themselves roles
+// above their own by adding them to a menu.
+func (m *RoleMenuModule) belowRoles(interaction *discordgo.Interaction, role *discordgo.Role) (bool, error) {
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	if guild == nil {
+		return false, Errorf("guild %v not found", interaction.GuildID)
+	}
+	if interaction.Member.User.ID != guild.OwnerID && role.Position >= HighestRolePosition(guild, interaction.Member.Roles) {
+		return false, nil
+	}
+
+	bot, err := m.Discord.GuildMember(guild.ID, m.Discord.BotUserID())
+	if err != nil {
+		return false, WrapError(err)
+	}
+	return role.Position < HighestRolePosition(guild, bot.Roles), nil
+}
+
+// Whether a role on a menu can still be handed out. Roles can be given
+// dangerous permissions or be taken over by an integration after they were
+// added.
+func roleMenuAssignable(guild *discordgo.Guild, roleID string) bool {
+	if guild == nil {
+		return false
+	}
+	for _, role := range guild.Roles {
+		if role.ID == roleID {
+			return !role.Managed && role.Permissions&roleMenuForbiddenPermissions == 0
+		}
+	}
+	return false
+}
+
+func (m *RoleMenuModule) RoleMenuCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	if subcommand == "create" {
+		return m.createMenu(interaction, options)
+	}
+	if subcommand == "list" {
+		return m.listMenus(interaction)
+	}
+
+	menu, err := m.GetMenu(interaction.GuildID, int(OptionInt(options, "menu")))
+	if err != nil {
+		return err
+	}
+	if menu == nil {
+		data := NewTemplateData()
+		data.ID = int(OptionInt(options, "menu"))
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.not_found", data, locales...))
+	}
+
+	data := NewTemplateData()
+	data.ID = menu.ID
+
+	switch subcommand {
+	case "add":
+		roleID := OptionID(options, "role")
+		role := interaction.ApplicationCommandData().Resolved.Roles[roleID]
+		if role == nil || role.Managed || role.ID == interaction.GuildID || role.Permissions&roleMenuForbiddenPermissions != 0 ||
+			(len(m.Config.AllowedRoles) > 0 && !slices.Contains(m.Config.AllowedRoles, roleID)) {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.invalid_role", nil, locales...))
+		}
+		below, err := m.belowRoles(interaction, role)
+		if err != nil {
+			return err
+		}
+		if !below {
+			data.Role = role.Mention()
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.role_too_high", data, locales...))
+		}
+		if len(menu.Options) >= roleMenuMaxOptions {
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.full", nil, locales...))
+		}
+
+		option := &RoleMenuOption{
+			RoleID:      roleID,
+			Label:       OptionString(options, "label"),
+			Emoji:       OptionString(options, "emoji"),
+			Description: OptionString(options, "description"),
+		}
+		if option.Label == "" {
+			option.Label = role.Name
+		}
+
+		_, err = m.DB.Exec(`
+			INSERT INTO role_menu_options (menu_id, role_id, label, emoji, description) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (menu_id, role_id) DO UPDATE SET label = $3, emoji = $4, description = $5`,
+			menu.ID, option.RoleID, option.Label, option.Emoji, option.Description)
+		if err != nil {
+			return WrapError(err)
+		}
+
+	case "remove":
+		result, err := m.DB.Exec(`DELETE FROM role_menu_options WHERE menu_id = $1 AND role_id = $2`, menu.ID, OptionID(options, "role"))
+		if err != nil {
+			return WrapError(err)
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			data.Role = "<@&" + OptionID(options, "role") + ">"
+			return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.role_not_found", data, locales...))
+		}
+
+	case "delete":
+		if menu.MessageID != "" {
+			err = m.Discord.ChannelMessageDelete(menu.ChannelID, menu.MessageID)
+			if err != nil {
+				rolemenuLog.Warn("Could not delete menu message", "menu", menu.ID, "message", menu.MessageID, "error", err)
+			}
+		}
+		_, err = m.DB.Exec(`DELETE FROM role_menus WHERE id = $1`, menu.ID)
+		if err != nil {
+			return WrapError(err)
+		}
+
+		rolemenuLog.Info("Menu deleted", "menu", menu.ID, "guild", interaction.GuildID, "staff", interaction.Member.User.ID)
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.deleted", data, locales...))
+	}
+
+	// Show the changed options
+	menu, err = m.GetMenu(interaction.GuildID, menu.ID)
+	if err != nil {
+		return err
+	}
+	err = m.PublishMenu(menu)
+	if err != nil {
+		return err
+	}
+
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.updated", data, locales...))
+}
+
+func (m *RoleMenuModule) createMenu(interaction *discordgo.Interaction, options map[string]*discordgo.ApplicationCommandInteractionDataOption) error {
+	locales := InteractionLocales(interaction)
+
+	menu := &RoleMenu{
+		GuildID:      interaction.GuildID,
+		ChannelID:    OptionID(options, "channel"),
+		Title:        OptionString(options, "title"),
+		Description:  OptionString(options, "description"),
+		Style:        RoleMenuStyle(OptionString(options, "style")),
+		Mode:         RoleMenuMode(OptionString(options, "mode")),
+		MaxRoles:     int(OptionInt(options, "max")),
+		RequiredRole: OptionID(options, "required_role"),
+	}
+	if menu.ChannelID == "" {
+		menu.ChannelID = interaction.ChannelID
+	}
+	if menu.Mode == RoleMenuMulti && menu.Style != RoleMenuSelect {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.multi_needs_select", nil, locales...))
+	}
+
+	err := m.DB.QueryRow(`
+		INSERT INTO role_menus (guild_id, channel_id, title, description, style, mode, max_roles, required_role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		menu.GuildID, menu.ChannelID, menu.Title, menu.Description, menu.Style, menu.Mode, menu.MaxRoles, menu.RequiredRole,
+	).Scan(&menu.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	rolemenuLog.Info("Menu created", "menu", menu.ID, "guild", interaction.GuildID, "staff", interaction.Member.User.ID)
+
+	err = m.PublishMenu(menu)
+	if err != nil {
+		return err
+	}
+
+	data := NewTemplateData()
+	data.ID = menu.ID
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.created", data, locales...))
+}
+
+func (m *RoleMenuModule) listMenus(interaction *discordgo.Interaction) error {
+	locales := InteractionLocales(interaction)
+
+	rows, err := m.DB.Query(`
+		SELECT m.id, m.channel_id, m.title, m.mode, count(o.role_id)
+		FROM role_menus m LEFT JOIN role_menu_options o ON o.menu_id = m.id
+		WHERE m.guild_id = $1
+		GROUP BY m.id
+		ORDER BY m.id`,
+		interaction.GuildID)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		var id, roles int
+		var channelID, title, mode string
+		err = rows.Scan(&id, &channelID, &title, &mode, &roles)
+		if err != nil {
+			return WrapError(err)
+		}
+		lines = append(lines, fmt.Sprintf("**%d** %s in <#%s> (%s, %d)", id, title, channelID, mode, roles))
+	}
+	if err := rows.Err(); err != nil {
+		return WrapError(err)
+	}
+
+	if len(lines) == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.none", nil, locales...))
+	}
+	return m.Discord.FollowupEphemeral(interaction, strings.Join(lines, "\n"))
+}
+
+// Returns nil if the menu doesn't exist in the guild
+func (m *RoleMenuModule) GetMenu(guildID string, id int) (*RoleMenu, error) {
+	menu := &RoleMenu{}
+	err := m.DB.QueryRow(`
+		SELECT id, guild_id, channel_id, message_id, title, description, style, mode, max_roles, required_role
+		FROM role_menus WHERE guild_id = $1 AND id = $2`,
+		guildID, id,
+	).Scan(&menu.ID, &menu.GuildID, &menu.ChannelID, &menu.MessageID, &menu.Title, &menu.Description,
+		&menu.Style, &menu.Mode, &menu.MaxRoles, &menu.RequiredRole)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	rows, err := m.DB.Query(`
+		SELECT role_id, label, emoji, description FROM role_menu_options
+		WHERE menu_id = $1 ORDER BY position`,
+		menu.ID)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		option := &RoleMenuOption{}
+		err = rows.Scan(&option.RoleID, &option.Label, &option.Emoji, &option.Description)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		menu.Options = append(menu.Options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, WrapError(err)
+	}
+
+	return menu, nil
+}
+
+// Posts the menu message, or updates it if it was posted before
+func (m *RoleMenuModule) PublishMenu(menu *RoleMenu) error {
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       menu.Title,
+		Description: menu.Description,
+	}
+	components := menu.Components()
+
+	if menu.MessageID != "" {
+		_, err := m.Discord.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         menu.MessageID,
+			Channel:    menu.ChannelID,
+			Embeds:     &[]*discordgo.MessageEmbed{embed},
+			Components: &components,
+		})
+		if err != nil {
+			return WrapError(err)
+		}
+		return nil
+	}
+
+	message, err := m.Discord.ChannelMessageSendComplex(menu.ChannelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: components,
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+
+	menu.MessageID = message.ID
+	_, err = m.DB.Exec(`UPDATE role_menus SET message_id = $1 WHERE id = $2`, menu.MessageID, menu.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+var customEmojiRegexp = regexp.MustCompile(`^<(a?):(\w+):(\d+)>$`)
+
+func parseComponentEmoji(text string) *discordgo.ComponentEmoji {
+	if text == "" {
+		return nil
+	}
+	if match := customEmojiRegexp.FindStringSubmatch(text); match != nil {
+		return &discordgo.ComponentEmoji{
+			Animated: match[1] == "a",
+			Name:     match[2],
+			ID:       match[3],
+		}
+	}
+	return &discordgo.ComponentEmoji{Name: text}
+}
+
+func (menu *RoleMenu) Components() []discordgo.MessageComponent {
+	if len(menu.Options) == 0 {
+		return []discordgo.MessageComponent{}
+	}
+
+	if menu.Style == RoleMenuSelect {
+		selectOptions := []discordgo.SelectMenuOption{}
+		for _, option := range menu.Options {
+			selectOptions = append(selectOptions, discordgo.SelectMenuOption{
+				Label:       option.Label,
+				Value:       option.RoleID,
+				Description: option.Description,
+				Emoji:       parseComponentEmoji(option.Emoji),
+			})
+		}
+
+		maxValues := 1
+		if menu.Mode == RoleMenuMulti {
+			maxValues = len(selectOptions)
+			if menu.MaxRoles > 0 {
+				maxValues = min(maxValues, menu.MaxRoles)
+			}
+		}
+
+		return []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:  "RoleMenuSelect|" + strconv.Itoa(menu.ID),
+						MinValues: Ptr(0),
+						MaxValues: maxValues,
+						Options:   selectOptions,
+					},
+				},
+			},
+		}
+	}
+
+	rows := []discordgo.MessageComponent{}
+	for start := 0; start < len(menu.Options); start += 5 {
+		buttons := []discordgo.MessageComponent{}
+		for _, option := range menu.Options[start:min(start+5, len(menu.Options))] {
+			buttons = append(buttons, discordgo.Button{
+				Label:    option.Label,
+				Emoji:    parseComponentEmoji(option.Emoji),
+				Style:    discordgo.SecondaryButton,
+				CustomID: "RoleMenuButton|" + strconv.Itoa(menu.ID) + "|" + option.RoleID,
+			})
+		}
+		rows = append(rows, discordgo.ActionsRow{Components: buttons})
+	}
+	return rows
+}
+
+// Loads the menu a component belongs to and checks that the member may use it
+func (m *RoleMenuModule) menuForInteraction(interaction *discordgo.Interaction, menuIDText string) (*RoleMenu, error) {
+	locales := InteractionLocales(interaction)
+
+	menuID, err := strconv.Atoi(menuIDText)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	menu, err := m.GetMenu(interaction.GuildID, menuID)
+	if err != nil {
+		return nil, err
+	}
+	if menu == nil {
+		return nil, m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.gone", nil, locales...))
+	}
+
+	if menu.RequiredRole != "" && !slices.Contains(interaction.Member.Roles, menu.RequiredRole) {
+		data := NewTemplateData()
+		data.Role = "<@&" + menu.RequiredRole + ">"
+		return nil, m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.requires_role", data, locales...))
+	}
+
+	return menu, nil
+}
+
+func (m *RoleMenuModule) RoleMenuButtonClick(interaction *discordgo.Interaction) error {
+	args := strings.Split(interaction.MessageComponentData().CustomID, "|")
+	roleID := args[2]
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	menu, err := m.menuForInteraction(interaction, args[1])
+	if menu == nil {
+		return err
+	}
+
+	wanted := []string{}
+	for _, option := range menu.Options {
+		held := slices.Contains(interaction.Member.Roles, option.RoleID)
+		switch {
+		case option.RoleID == roleID:
+			// The clicked role toggles
+			if !held {
+				wanted = append(wanted, option.RoleID)
+			}
+		case held && menu.Mode != RoleMenuSingle:
+			wanted = append(wanted, option.RoleID)
+		}
+	}
+
+	return m.applySelection(interaction, menu, wanted)
+}
+
+func (m *RoleMenuModule) RoleMenuSelectSubmit(interaction *discordgo.Interaction) error {
+	args := strings.Split(interaction.MessageComponentData().CustomID, "|")
+	selected := interaction.MessageComponentData().Values
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	menu, err := m.menuForInteraction(interaction, args[1])
+	if menu == nil {
+		return err
+	}
+
+	wanted := []string{}
+	for _, option := range menu.Options {
+		held := slices.Contains(interaction.Member.Roles, option.RoleID)
+		picked := slices.Contains(selected, option.RoleID)
+		switch menu.Mode {
+		case RoleMenuMulti, RoleMenuSingle:
+			// The selection is the new set of roles
+			if picked {
+				wanted = append(wanted, option.RoleID)
+			}
+		case RoleMenuToggle:
+			if picked != held {
+				wanted = append(wanted, option.RoleID)
+			}
+		}
+	}
+
+	return m.applySelection(interaction, menu, wanted)
+}
+
+// Adds and removes roles of the menu so the member ends up with exactly the
+// wanted ones
+func (m *RoleMenuModule) applySelection(interaction *discordgo.Interaction, menu *RoleMenu, wanted []string) error {
+	locales := InteractionLocales(interaction)
+
+	if menu.MaxRoles > 0 && len(wanted) > menu.MaxRoles {
+		data := NewTemplateData()
+		data.Count = menu.MaxRoles
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("rolemenu.max_reached", data, locales...))
+	}
+
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	added, removed, unavailable := []string{}, []string{}, []string{}
+	for _, option := range menu.Options {
+		held := slices.Contains(interaction.Member.Roles, option.RoleID)
+		want := slices.Contains(wanted, option.RoleID)
+
+		if want && !held && !roleMenuAssignable(guild, option.RoleID) {
+			rolemenuLog.Warn("Role can't be handed out anymore", "menu", menu.ID, "role", option.RoleID, "guild", interaction.GuildID)
+			unavailable = append(unavailable, "<@&"+option.RoleID+">")
+		} else if want && !held {
+			err := m.Discord.GuildMemberRoleAdd(interaction.GuildID, interaction.Member.User.ID, option.RoleID)
+			if err != nil {
+				rolemenuLog.Warn("Could not add role", "role", option.RoleID, "guild", interaction.GuildID, "user", interaction.Member.User.ID, "error", err)
+				continue
+			}
+			added = append(added, "<@&"+option.RoleID+">")
+		} else if !want && held {
+			err := m.Discord.GuildMemberRoleRemove(interaction.GuildID, interaction.Member.User.ID, option.RoleID)
+			if err != nil {
+				rolemenuLog.Warn("Could not remove role", "role", option.RoleID, "guild", interaction.GuildID, "user", interaction.Member.User.ID, "error", err)
+				continue
+			}
+			removed = append(removed, "<@&"+option.RoleID+">")
+		}
+	}
+
+	lines := []string{}
+	data := NewTemplateData()
+	if len(added) > 0 {
+		data.Role = strings.Join(added, ", ")
+		lines = append(lines, m.Localizer.Text("rolemenu.added", data, locales...))
+	}
+	if len(removed) > 0 {
+		data.Role = strings.Join(removed, ", ")
+		lines = append(lines, m.Localizer.Text("rolemenu.removed", data, locales...))
+	}
+	if len(unavailable) > 0 {
+		data.Role = strings.Join(unavailable, ", ")
+		lines = append(lines, m.Localizer.Text("rolemenu.unavailable", data, locales...))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, m.Localizer.Text("rolemenu.unchanged", nil, locales...))
+	}
+
+	return m.Discord.FollowupEphemeral(interaction, strings.Join(lines, "\n"))
+}
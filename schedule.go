@@ -0,0 +1,263 @@
+// Recurring schedules
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// When something recurs, either a cron expression like "0 18 * * fri" or an
+// interval like "every 2 hours"
+type Schedule interface {
+	// First time after the given time
+	Next(after time.Time) time.Time
+	String() string
+}
+
+type IntervalSchedule struct {
+	Interval time.Duration
+}
+
+func (s *IntervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(s.Interval)
+}
+
+func (s *IntervalSchedule) String() string {
+	return "every " + FormatDuration(s.Interval)
+}
+
+// Minute, hour, day of month, month and day of week, each a bit set of the
+// allowed values
+type CronSchedule struct {
+	text       string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+	location   *time.Location
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Loads a time zone name like "Europe/Berlin", empty for the time zone of the
+// system
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return location, nil
+}
+
+// Parses a cron expression or an interval. Cron expressions are evaluated in
+// the given location.
+func ParseSchedule(text string, location *time.Location) (Schedule, error) {
+	text = strings.TrimSpace(strings.ToLower(text))
+
+	if interval, ok := strings.CutPrefix(text, "every "); ok {
+		// "every day" is every 1 day
+		if interval != "" && !unicode.IsDigit(rune(interval[0])) {
+			interval = "1 " + interval
+		}
+		duration, err := ParseDuration(interval)
+		if err != nil {
+			return nil, err
+		}
+		if duration < time.Minute {
+			return nil, Errorf("schedules can't repeat more often than every minute")
+		}
+		return &IntervalSchedule{Interval: duration}, nil
+	}
+
+	if expanded, ok := cronShortcuts[text]; ok {
+		text = expanded
+	}
+	fields := strings.Fields(text)
+	if len(fields) != 5 {
+		return nil, Errorf("%q is neither a cron expression like \"0 18 * * fri\" nor an interval like \"every 2 hours\"", text)
+	}
+
+	schedule := &CronSchedule{
+		text:       strings.Join(fields, " "),
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+		location:   location,
+	}
+
+	var err error
+	parts := []struct {
+		name   string
+		target *uint64
+		min    int
+		max    int
+		names  []string
+	}{
+		{"minute", &schedule.minutes, 0, 59, nil},
+		{"hour", &schedule.hours, 0, 23, nil},
+		{"day of month", &schedule.days, 1, 31, nil},
+		{"month", &schedule.months, 1, 12, cronMonthNames},
+		{"day of week", &schedule.weekdays, 0, 7, cronWeekdayNames},
+	}
+	for i, part := range parts {
+		*part.target, err = parseCronField(fields[i], part.min, part.max, part.names)
+		if err != nil {
+			return nil, Errorf("%s: %w", part.name, err)
+		}
+	}
+
+	// Sunday can be written as 0 or 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	return schedule, nil
+}
+
+// Parses lists of values, ranges and steps like "1,15", "mon-fri" or "*/15"
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	parseValue := func(text string) (int, error) {
+		for i, name := range names {
+			if text == name {
+				// Month names start at 1, weekday names at 0
+				return i + min, nil
+			}
+		}
+		value, err := strconv.Atoi(text)
+		if err != nil || value < min || value > max {
+			return 0, Errorf("%q is not between %d and %d", text, min, max)
+		}
+		return value, nil
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step < 1 {
+				return 0, Errorf("%q is not a valid step", stepText)
+			}
+		}
+
+		start, end := min, max
+		if rangeText != "*" {
+			startText, endText, isRange := strings.Cut(rangeText, "-")
+			var err error
+			start, err = parseValue(startText)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				end, err = parseValue(endText)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 on
+				end = max
+			}
+			// "mon-sun" means up to 7, Sunday's other number
+			if end == 0 && start > 0 && max == 7 {
+				end = 7
+			}
+			if end < start {
+				return 0, Errorf("range %q ends before it starts", rangeText)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+
+	// Like cron, if both are restricted either one matching is enough
+	if !s.anyDay && !s.anyWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Walks the wall clock in UTC, which has no daylight saving changes, and
+// converts matches to the location. Times skipped when the clocks go forward
+// run right after the change, e.g. 02:30 at 03:30, and times repeated when
+// they go back run once, the first time.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	local := after.In(s.location)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC).Add(time.Minute)
+
+	// Gives up after a few years for expressions like "0 0 31 2 *" that never match
+	limit := wall.AddDate(5, 0, 0)
+	for wall.Before(limit) {
+		if s.months&(1<<int(wall.Month())) == 0 {
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(wall) {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hours&(1<<wall.Hour()) == 0 {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minutes&(1<<wall.Minute()) == 0 {
+			wall = wall.Add(time.Minute)
+			continue
+		}
+
+		// Wall times of a repeated hour map to its first pass, so in the
+		// second pass they have passed already
+		t := firstOccurrence(time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, s.location))
+		if !t.After(after) {
+			wall = wall.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// time.Date picks either time of a wall clock time that happens twice because
+// the clocks went back, this returns the earlier one
+func firstOccurrence(t time.Time) time.Time {
+	_, offset := t.Zone()
+	_, offsetDayBefore := t.Add(-24 * time.Hour).Zone()
+	if offsetDayBefore <= offset {
+		return t
+	}
+	earlier := t.Add(-time.Duration(offsetDayBefore-offset) * time.Second)
+	if earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute() {
+		return earlier
+	}
+	return t
+}
+
+func (s *CronSchedule) String() string {
+	return s.text
+}
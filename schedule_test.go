@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  string
+		valid bool
+	}{
+		{"every 2 hours", "every 2 hours", true},
+		{"Every 30m", "every 30 minutes", true},
+		{"every day", "every 1 day", true},
+		{"every 30s", "", false},
+		{"every", "", false},
+		{"0 18 * * fri", "0 18 * * fri", true},
+		{"  0   18 * * FRI ", "0 18 * * fri", true},
+		{"@daily", "0 0 * * *", true},
+		{"*/15 9-17 * * mon-fri", "*/15 9-17 * * mon-fri", true},
+		{"0 9 * * mon-sun", "0 9 * * mon-sun", true},
+		{"0 0 1 jan,jul *", "0 0 1 jan,jul *", true},
+		{"60 * * * *", "", false},
+		{"0 24 * * *", "", false},
+		{"0 0 0 * *", "", false},
+		{"0 0 * * fri-mon", "", false},
+		{"0 0 * * 8", "", false},
+		{"*/0 * * * *", "", false},
+		{"0 18 * *", "", false},
+		{"tomorrow", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.text, time.UTC)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("%q was accepted as %v", test.text, schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schedule.String() != test.want {
+				t.Fatalf("%q became %q, want %q", test.text, schedule.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(text string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", text, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		cron  string
+		after time.Time
+		want  []time.Time
+	}{
+		{"later the same day", "0 18 * * *", at("2026-05-04 12:00"), []time.Time{at("2026-05-04 18:00"), at("2026-05-05 18:00")}},
+		{"strictly after", "0 18 * * *", at("2026-05-04 18:00"), []time.Time{at("2026-05-05 18:00")}},
+		{"seconds are dropped", "* * * * *", at("2026-05-04 18:00").Add(30 * time.Second), []time.Time{at("2026-05-04 18:01")}},
+		{"weekday", "0 9 * * mon", at("2026-05-06 12:00"), []time.Time{at("2026-05-11 09:00"), at("2026-05-18 09:00")}},
+		{"mon-sun includes sunday", "0 9 * * mon-sun", at("2026-05-09 12:00"), []time.Time{at("2026-05-10 09:00"), at("2026-05-11 09:00")}},
+		{"sunday as 7", "0 9 * * 7", at("2026-05-04 12:00"), []time.Time{at("2026-05-10 09:00")}},
+		{"day of month or weekday", "0 0 1 * fri", at("2026-05-27 12:00"), []time.Time{at("2026-05-29 00:00"), at("2026-06-01 00:00"), at("2026-06-05 00:00")}},
+		{"end of month", "0 0 31 * *", at("2026-04-15 00:00"), []time.Time{at("2026-05-31 00:00"), at("2026-07-31 00:00")}},
+		{"leap day", "0 0 29 2 *", at("2026-03-01 00:00"), []time.Time{at("2028-02-29 00:00")}},
+		{"never", "0 0 31 2 *", at("2026-01-01 00:00"), []time.Time{{}}},
+		// Clocks go from 02:00 to 03:00 on March 29th 2026
+		{"skipped time runs after the change", "30 2 * * *", at("2026-03-28 12:00"), []time.Time{
+			at("2026-03-29 01:30").Add(time.Hour), at("2026-03-30 02:30"),
+		}},
+		{"hourly over the change", "0 * * * *", at("2026-03-29 00:30"), []time.Time{
+			at("2026-03-29 01:00"), at("2026-03-29 03:00"), at("2026-03-29 04:00"),
+		}},
+		// Clocks go from 03:00 back to 02:00 on October 25th 2026
+		{"repeated time runs once", "30 2 * * *", at("2026-10-24 12:00"), []time.Time{
+			at("2026-10-25 01:30").Add(time.Hour), at("2026-10-26 02:30"),
+		}},
+		{"hourly over the repeated hour", "0 * * * *", at("2026-10-25 00:30"), []time.Time{
+			at("2026-10-25 01:00"), at("2026-10-25 01:00").Add(time.Hour), at("2026-10-25 03:00"),
+		}},
+		{"same wall time across the change", "0 12 * * *", at("2026-10-24 12:00"), []time.Time{
+			at("2026-10-25 12:00"), at("2026-10-26 12:00"),
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.cron, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := test.after
+			for i, want := range test.want {
+				next = schedule.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("run %d of %q after %v is %v, want %v", i+1, test.cron, test.after, next, want)
+				}
+			}
+		})
+	}
+}
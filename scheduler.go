@@ -0,0 +1,90 @@
+// Periodic background tasks
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Runs module tasks periodically while the bot is connected. Tasks keep
+// their state in the database, so nothing is lost when the bot restarts.
+//
+// Meant for sweeps over rows a module owns anyway, like reminders and
+// announcements that users list, edit and pause, or pruning old data. One-off
+// actions at a later time, like lifting a temporary ban, go into the JobQueue
+// instead, which retries them and shows failures in /jobs.
+type Scheduler struct {
+	tasks   []*schedulerTask
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+type schedulerTask struct {
+	name     string
+	interval time.Duration
+	run      func(now time.Time) error
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		stop: make(chan struct{}),
+	}
+}
+
+// Calls run every interval. The first call happens right after the bot
+// connects, so work that came due while it was down isn't delayed further.
+func (s *Scheduler) Every(name string, interval time.Duration, run func(now time.Time) error) {
+	s.tasks = append(s.tasks, &schedulerTask{
+		name:     name,
+		interval: interval,
+		run:      run,
+	})
+}
+
+func (s *Scheduler) Start() {
+	for _, task := range s.tasks {
+		s.running.Add(1)
+		go s.loop(task)
+	}
+}
+
+// Waits for running tasks to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.running.Wait()
+}
+
+func (s *Scheduler) loop(task *schedulerTask) {
+	defer s.running.Done()
+
+	ticker := time.NewTicker(task.interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.runTask(task)
+		if err != nil {
+			Log.Error("Scheduled task failed", "task", task.name, "error", ErrorToStr(err))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// A panicking task fails this run instead of taking the bot down
+func (s *Scheduler) runTask(task *schedulerTask) error {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			handlerPanics.Inc(handlerModule(task.run))
+			Log.Error("Scheduled task panicked", "task", task.name, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		}
+	}()
+	return task.run(time.Now())
+}
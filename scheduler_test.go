@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A panicking task must not stop the bot or its own later runs
+func TestSchedulerRecoversPanics(t *testing.T) {
+	scheduler := NewScheduler()
+	var runs atomic.Int32
+	scheduler.Every("panics", 10*time.Millisecond, func(now time.Time) error {
+		if runs.Add(1) == 1 {
+			panic("boom")
+		}
+		return nil
+	})
+
+	scheduler.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	scheduler.Stop()
+
+	if runs.Load() < 3 {
+		t.Fatalf("the task ran %v times after panicking", runs.Load())
+	}
+}
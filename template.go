@@ -0,0 +1,487 @@
+// Templating for configured messages
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Variables of templates. Configured messages can only use the fields their
+// context fills in, see templateContexts, plus .Now.
+//
+//	{{.User.Mention}} {{.User.Name}} {{.User.Username}} {{.User.ID}}
+//	{{.User.AvatarURL}} {{.User.CreatedAt}} {{.User.AccountAge}}
+//	{{.Staff.Mention}} {{.Staff.Name}} {{.Staff.Username}} {{.Staff.ID}}
+//	{{.Guild.Name}} {{.Guild.ID}} {{.Guild.MemberCount}} {{.Guild.IconURL}}
+//	{{.Reason}} {{.Now}}
+//	{{index .Answers "What is your name?"}}
+//	{{.Action}} {{.CaseNumber}} {{.Duration}} {{.Count}}
+//
+// Functions: date, datetime, timestamp, since, default, upper, lower.
+type TemplateData struct {
+	User    TemplateUser
+	Staff   TemplateUser
+	Guild   TemplateGuild
+	Reason  string
+	Answers map[string]string
+	Now     time.Time
+
+	// Moderation actions
+	Action     string
+	CaseNumber int
+	Duration   string
+	Count      int
+
+	// Built-in messages
+	// Number of a role menu, announcement, reminder or job
+	ID int
+	// Mentions, e.g. "<#123>" and "<@&456>"
+	Channel string
+	Role    string
+	// Time markup, e.g. "<t:1700000000:R>"
+	Time string
+	URL  string
+	// Automod rule name
+	Rule string
+	// Ticket category
+	Topic string
+	// Account age threshold of an anti-raid trigger
+	Age string
+	// Text of a reminder
+	Text string
+	// What the user entered and what was wrong with it
+	Input string
+	Error string
+}
+
+type TemplateUser struct {
+	ID        string
+	Name      string
+	Username  string
+	Mention   string
+	AvatarURL string
+	CreatedAt time.Time
+}
+
+type TemplateGuild struct {
+	ID          string
+	Name        string
+	MemberCount int
+	IconURL     string
+}
+
+// Template is a plain text template, configured as a string
+type Template struct {
+	Source   string
+	template *template.Template
+}
+
+type MessageTemplateEmbedField struct {
+	Name   *Template
+	Value  *Template
+	Inline bool
+}
+
+type MessageTemplateEmbed struct {
+	Title       *Template
+	Description *Template
+	URL         *Template
+	Color       int
+	Thumbnail   *Template
+	Image       *Template
+	Footer      *Template
+	Fields      []MessageTemplateEmbedField
+}
+
+// MessageTemplate is configured either as a plain string, which becomes the
+// message content, or as an object with Content and/or Embed
+type MessageTemplate struct {
+	Content *Template
+	Embed   *MessageTemplateEmbed
+}
+
+var templateFuncs = template.FuncMap{
+	"date": func(t time.Time) string {
+		return t.Local().Format("2006-01-02")
+	},
+	"datetime": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"timestamp": func(t time.Time) string {
+		return fmt.Sprintf("<t:%d:f>", t.Unix())
+	},
+	"since": func(t time.Time) string {
+		return FormatDuration(time.Since(t))
+	},
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Older configs used $VARIABLE placeholders, keep them working. Only whole
+// tokens are replaced, "$USERNAME" stays as it is.
+var legacyTemplateVariables = map[string]string{
+	"$USER":   "{{.User.Mention}}",
+	"$STAFF":  "{{.Staff.Mention}}",
+	"$REASON": "{{.Reason}}",
+}
+
+var legacyTemplateVariable = regexp.MustCompile(`\$(USER|STAFF|REASON)\b`)
+
+func replaceLegacyVariables(source string) string {
+	return legacyTemplateVariable.ReplaceAllStringFunc(source, func(token string) string {
+		return legacyTemplateVariables[token]
+	})
+}
+
+func NewTemplate(source string) (*Template, error) {
+	tmpl, err := template.New("").
+		Funcs(templateFuncs).
+		Option("missingkey=zero").
+		Parse(replaceLegacyVariables(source))
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	return &Template{
+		Source:   source,
+		template: tmpl,
+	}, nil
+}
+
+func (t *Template) UnmarshalJSON(data []byte) error {
+	var source string
+	err := json.Unmarshal(data, &source)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := NewTemplate(source)
+	if err != nil {
+		return err
+	}
+	*t = *parsed
+	return nil
+}
+
+func (t *Template) Render(data *TemplateData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	err := t.template.Execute(&buf, data)
+	if err != nil {
+		return "", WrapError(err)
+	}
+	return buf.String(), nil
+}
+
+func (t *MessageTemplate) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		content := &Template{}
+		err := json.Unmarshal(data, content)
+		if err != nil {
+			return err
+		}
+		*t = MessageTemplate{Content: content}
+		return nil
+	}
+
+	// Alias to avoid recursing into this method
+	type messageTemplate MessageTemplate
+	var message messageTemplate
+	err := json.Unmarshal(data, &message)
+	if err != nil {
+		return err
+	}
+	*t = MessageTemplate(message)
+	return nil
+}
+
+func (t *MessageTemplate) Render(data *TemplateData) (*discordgo.MessageSend, error) {
+	message := &discordgo.MessageSend{}
+	if t == nil {
+		return message, nil
+	}
+
+	var err error
+	message.Content, err = t.Content.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Embed != nil {
+		embed, err := t.Embed.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		message.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+
+	return message, nil
+}
+
+func (t *MessageTemplateEmbed) Render(data *TemplateData) (*discordgo.MessageEmbed, error) {
+	var err error
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Color: t.Color,
+	}
+
+	embed.Title, err = t.Title.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	embed.Description, err = t.Description.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	embed.URL, err = t.URL.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := t.Thumbnail.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	if thumbnail != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: thumbnail}
+	}
+
+	image, err := t.Image.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	if image != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: image}
+	}
+
+	footer, err := t.Footer.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	if footer != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: footer}
+	}
+
+	for _, field := range t.Fields {
+		name, err := field.Name.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		value, err := field.Value.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   name,
+			Value:  value,
+			Inline: field.Inline,
+		})
+	}
+
+	return embed, nil
+}
+
+// Top-level TemplateData fields each configured message is rendered with,
+// keyed by config path. Translations of these messages in locale catalogs use
+// the same keys.
+var templateContexts = map[string][]string{
+	"VerificationSystem.WelcomeMessage":              {"Guild"},
+	"VerificationSystem.FormEmbedDescription":        {"Guild", "User", "Answers"},
+	"VerificationSystem.FormSubmitUserMessage":       {"Guild", "User", "Answers"},
+	"VerificationSystem.ApprovedAnnouncementMessage": {"Guild", "User", "Staff", "Answers"},
+	"VerificationSystem.DenyDmMessage":               {"Guild", "User", "Staff", "Answers", "Reason"},
+	"Moderation.DmMessage":                           {"Guild", "User", "Staff", "Reason", "Action", "Duration"},
+	"Lockdown.StartNotice":                           {"Guild", "Staff", "Reason"},
+	"Lockdown.EndNotice":                             {"Guild", "Staff", "Reason"},
+	"Welcome.Message":                                {"Guild", "User", "Count"},
+	"Welcome.DmMessage":                              {"Guild", "User", "Count"},
+	"Welcome.FarewellMessage":                        {"Guild", "User", "Count"},
+	"Tickets.PanelMessage":                           {"Guild"},
+	"Tickets.OpenMessage":                            {"Guild", "User", "Topic", "Reason", "CaseNumber"},
+	"Modmail.OpenMessage":                            {"Guild", "User"},
+	"Modmail.CloseMessage":                           {"Guild", "User", "Staff", "Reason"},
+}
+
+// Executes the template against sample data of the config path's context,
+// so that unknown variables and variables the message never gets are
+// reported at config load instead of when the message is first sent. Keys
+// without a context, like built-in catalog messages, can use every field.
+func (t *MessageTemplate) Validate(key string) error {
+	if t == nil {
+		return nil
+	}
+
+	templates := []*Template{t.Content}
+	if t.Embed != nil {
+		templates = append(templates, t.Embed.Title, t.Embed.Description, t.Embed.URL, t.Embed.Thumbnail, t.Embed.Image, t.Embed.Footer)
+		for _, field := range t.Embed.Fields {
+			templates = append(templates, field.Name, field.Value)
+		}
+	}
+
+	for _, tmpl := range templates {
+		err := tmpl.Validate(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Template) Validate(key string) error {
+	if t == nil {
+		return nil
+	}
+
+	fields, ok := templateContexts[key]
+	if !ok {
+		_, err := t.Render(SampleTemplateData())
+		return err
+	}
+
+	// Only the fields of the context are in the map, the others are
+	// reported as missing
+	sample := reflect.ValueOf(SampleTemplateData()).Elem()
+	data := map[string]any{"Now": sample.FieldByName("Now").Interface()}
+	for _, field := range fields {
+		data[field] = sample.FieldByName(field).Interface()
+	}
+
+	tmpl, err := t.template.Clone()
+	if err != nil {
+		return WrapError(err)
+	}
+	err = tmpl.Option("missingkey=error").Execute(io.Discard, data)
+	if err != nil {
+		return Errorf("%w, this message can use .%s and .Now", err, strings.Join(fields, ", ."))
+	}
+	return nil
+}
+
+func SampleTemplateData() *TemplateData {
+	now := time.Now()
+	return &TemplateData{
+		User: TemplateUser{
+			ID:        "0",
+			Name:      "User",
+			Username:  "user",
+			Mention:   "<@0>",
+			CreatedAt: now,
+		},
+		Staff: TemplateUser{
+			ID:        "0",
+			Name:      "Staff",
+			Username:  "staff",
+			Mention:   "<@0>",
+			CreatedAt: now,
+		},
+		Guild: TemplateGuild{
+			ID:          "0",
+			Name:        "Guild",
+			MemberCount: 1,
+		},
+		Reason:     "Reason",
+		Answers:    map[string]string{},
+		Now:        now,
+		Action:     "warned",
+		CaseNumber: 1,
+		Duration:   "1 hour",
+		Count:      1,
+		ID:         1,
+		Channel:    "<#0>",
+		Role:       "<@&0>",
+		Time:       fmt.Sprintf("<t:%d:R>", now.Unix()),
+		URL:        "https://discord.com",
+		Rule:       "Rule",
+		Topic:      "Topic",
+		Age:        "1 day",
+		Text:       "Text",
+		Input:      "Input",
+		Error:      "Error",
+	}
+}
+
+func NewTemplateData() *TemplateData {
+	return &TemplateData{
+		Answers: map[string]string{},
+		Now:     time.Now(),
+	}
+}
+
+func NewTemplateUser(user *discordgo.User, member *discordgo.Member) TemplateUser {
+	if member != nil && member.User != nil {
+		user = member.User
+	}
+	if user == nil {
+		return TemplateUser{}
+	}
+
+	createdAt, _ := discordgo.SnowflakeTimestamp(user.ID)
+	templateUser := TemplateUser{
+		ID:        user.ID,
+		Name:      user.GlobalName,
+		Username:  user.Username,
+		Mention:   user.Mention(),
+		AvatarURL: user.AvatarURL(""),
+		CreatedAt: createdAt,
+	}
+	if templateUser.Name == "" {
+		templateUser.Name = user.Username
+	}
+	if member != nil {
+		templateUser.Name = member.DisplayName()
+		templateUser.AvatarURL = member.AvatarURL("")
+	}
+	return templateUser
+}
+
+// Only the ID is known, e.g. when it was carried in a component custom ID
+func NewTemplateUserFromID(userID string) TemplateUser {
+	createdAt, _ := discordgo.SnowflakeTimestamp(userID)
+	return TemplateUser{
+		ID:        userID,
+		Name:      "<@" + userID + ">",
+		Username:  userID,
+		Mention:   "<@" + userID + ">",
+		CreatedAt: createdAt,
+	}
+}
+
+func NewTemplateGuild(guild *discordgo.Guild) TemplateGuild {
+	if guild == nil {
+		return TemplateGuild{}
+	}
+
+	return TemplateGuild{
+		ID:          guild.ID,
+		Name:        guild.Name,
+		MemberCount: guild.MemberCount,
+		IconURL:     guild.IconURL(""),
+	}
+}
+
+func (u TemplateUser) AccountAge() string {
+	if u.CreatedAt.IsZero() {
+		return ""
+	}
+	return FormatDuration(time.Since(u.CreatedAt))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMessageTemplateValidateContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		message string
+		valid   bool
+	}{
+		{"field of the context", "Welcome.Message", `"Welcome {{.User.Mention}} to {{.Guild.Name}}"`, true},
+		{"field of another context", "Welcome.Message", `"Welcome, {{.Staff.Name}} says hi"`, false},
+		{"now is always there", "Tickets.PanelMessage", `"{{date .Now}}"`, true},
+		{"legacy variable", "Lockdown.StartNotice", `"Locked by $STAFF: $REASON"`, true},
+		{"legacy variable of another context", "Tickets.PanelMessage", `"Hello $USER"`, false},
+		{"embed field", "Modmail.OpenMessage", `{"Embed": {"Fields": [{"Name": "Reason", "Value": "{{.Reason}}"}]}}`, false},
+		{"answers", "VerificationSystem.DenyDmMessage", `"{{index .Answers \"Name\"}}: {{.Reason}}"`, true},
+		{"unknown field", "Welcome.Message", `"{{.Nickname}}"`, false},
+		{"catalog message without a context", "automod.reason", `"{{.Rule}} {{.Staff.Name}}"`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := &MessageTemplate{}
+			err := json.Unmarshal([]byte(test.message), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = message.Validate(test.key)
+			if test.valid && err != nil {
+				t.Fatalf("%s was rejected: %v", test.message, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("%s was accepted for %s", test.message, test.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,910 @@
+// Ticket module
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type TicketMode string
+
+const (
+	// Private threads in the channel of the panel
+	TicketThreads TicketMode = "thread"
+	// Channels in Category, only visible to the member and staff
+	TicketChannels TicketMode = "channel"
+)
+
+type TicketConfig struct {
+	// Defaults to thread
+	Mode TicketMode
+	// Parent category of ticket channels
+	Category string
+	// Can see, claim and close every ticket
+	StaffRoles []string
+	// Where transcripts of closed tickets are posted
+	TranscriptChannel string
+	// Open tickets a member can have at once, defaults to 1
+	MaxOpenTickets int
+
+	// Shown above the panel buttons
+	PanelMessage *MessageTemplate
+	// Posted in a new ticket. Reason (or Topic) is the ticket category label,
+	// CaseNumber the ticket number.
+	OpenMessage *MessageTemplate
+
+	Categories []*TicketCategory
+}
+
+type TicketCategory struct {
+	// Identifies the category, don't change it while tickets are open
+	Name string
+	// Defaults to Name
+	Label string
+	Emoji string
+	// In addition to StaffRoles
+	StaffRoles []string
+}
+
+func (c *TicketConfig) Validate() error {
+	switch c.Mode {
+	case "", TicketThreads:
+	case TicketChannels:
+		if c.Category == "" {
+			return Errorf("Tickets.Category is required in channel mode")
+		}
+	default:
+		return Errorf("Tickets.Mode: unknown mode %q", c.Mode)
+	}
+
+	if c.MaxOpenTickets < 0 {
+		return Errorf("Tickets.MaxOpenTickets can't be negative")
+	}
+	if len(c.Categories) == 0 || len(c.Categories) > 25 {
+		return Errorf("Tickets.Categories needs between 1 and 25 categories")
+	}
+	for i, category := range c.Categories {
+		if category.Name == "" || strings.Contains(category.Name, "|") {
+			return Errorf("Tickets.Categories.%d: Name is required and can't contain |", i)
+		}
+	}
+
+	if c.PanelMessage != nil {
+		err := c.PanelMessage.Validate("Tickets.PanelMessage")
+		if err != nil {
+			return Errorf("Tickets.PanelMessage: %w", err)
+		}
+	}
+	if c.OpenMessage != nil {
+		err := c.OpenMessage.Validate("Tickets.OpenMessage")
+		if err != nil {
+			return Errorf("Tickets.OpenMessage: %w", err)
+		}
+	}
+	return nil
+}
+
+// Mode with its default
+func (c *TicketConfig) ticketMode() TicketMode {
+	if c.Mode == "" {
+		return TicketThreads
+	}
+	return c.Mode
+}
+
+// MaxOpenTickets with its default
+func (c *TicketConfig) openTicketLimit() int {
+	if c.MaxOpenTickets == 0 {
+		return 1
+	}
+	return c.MaxOpenTickets
+}
+
+// Label with its default
+func (category *TicketCategory) label() string {
+	if category.Label == "" {
+		return category.Name
+	}
+	return category.Label
+}
+
+func (c *TicketConfig) FindCategory(name string) *TicketCategory {
+	for _, category := range c.Categories {
+		if category.Name == name {
+			return category
+		}
+	}
+	return nil
+}
+
+type Ticket struct {
+	ID          int
+	GuildID     string
+	ChannelID   string
+	OwnerID     string
+	Category    string
+	ClaimedBy   string
+	Open        bool
+	CloseReason string
+	ClosedBy    string
+	CreatedAt   time.Time
+	ClosedAt    *time.Time
+}
+
+var ticketLog = ModuleLogger("ticket")
+
+type TicketModule struct {
+	Discord   DiscordAPI
+	DB        *sql.DB
+	Localizer *Localizer
+	Config    *TicketConfig
+}
+
+const ticketSchema = `
+CREATE TABLE IF NOT EXISTS tickets (
+	id           SERIAL PRIMARY KEY,
+	guild_id     TEXT NOT NULL,
+	channel_id   TEXT NOT NULL DEFAULT '',
+	owner_id     TEXT NOT NULL,
+	category     TEXT NOT NULL,
+	claimed_by   TEXT NOT NULL DEFAULT '',
+	open         BOOLEAN NOT NULL DEFAULT TRUE,
+	close_reason TEXT NOT NULL DEFAULT '',
+	closed_by    TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	closed_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS tickets_channel ON tickets (channel_id);
+
+CREATE TABLE IF NOT EXISTS ticket_transcripts (
+	ticket_id INTEGER PRIMARY KEY REFERENCES tickets (id) ON DELETE CASCADE,
+	text      TEXT NOT NULL,
+	html      TEXT NOT NULL
+);
+`
+
+const ticketColumns = `id, guild_id, channel_id, owner_id, category, claimed_by, open, close_reason, closed_by, created_at, closed_at`
+
+// Permissions of the member and staff in ticket channels
+const ticketPermissions = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages |
+	discordgo.PermissionReadMessageHistory | discordgo.PermissionAttachFiles | discordgo.PermissionEmbedLinks
+
+func init() {
+	RegisterModule(ModuleInfo{Name: "ticket", NeedsDB: true}, func(config *Config) *TicketConfig {
+		return config.Tickets
+	}, NewTicketModule)
+}
+
+func NewTicketModule(config *TicketConfig) *TicketModule {
+	return &TicketModule{
+		Config: config,
+	}
+}
+
+func (m *TicketModule) Register(bot *Bot) error {
+	ticketLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.DB = bot.DB
+	m.Localizer = bot.Localizer
+
+	_, err := m.DB.Exec(ticketSchema)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	userOption := func(description string) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: description,
+			Required:    true,
+		}
+	}
+
+	bot.Router.AddCommand(&discordgo.ApplicationCommand{
+		Name:         "ticket",
+		Description:  "Manage support tickets",
+		DMPermission: Ptr(false),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "panel",
+				Description: "Post the panel members open tickets with",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "claim",
+				Description: "Claim this ticket",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Add someone to this ticket",
+				Options:     []*discordgo.ApplicationCommandOption{userOption("User to add")},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Remove someone from this ticket",
+				Options:     []*discordgo.ApplicationCommandOption{userOption("User to remove")},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "close",
+				Description: "Close this ticket",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "reason",
+						Description: "Why the ticket is closed",
+						MaxLength:   1000,
+					},
+				},
+			},
+		},
+	}, m.TicketCommand)
+
+	bot.Router.AddComponent("TicketOpenButton", m.OpenButtonClick)
+	bot.Router.AddComponent("TicketClaimButton", m.ClaimButtonClick)
+	bot.Router.AddComponent("TicketCloseButton", m.CloseButtonClick)
+	bot.Router.AddModal("TicketCloseModal", m.CloseModalSubmit)
+	return nil
+}
+
+// Staff of any category, or of the ticket's category if one is given
+func (m *TicketModule) isStaff(member *discordgo.Member, category *TicketCategory) bool {
+	if member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	for _, roleID := range member.Roles {
+		if slices.Contains(m.Config.StaffRoles, roleID) {
+			return true
+		}
+		if category != nil && slices.Contains(category.StaffRoles, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *TicketModule) TicketCommand(interaction *discordgo.Interaction) error {
+	subcommand, options := Subcommand(interaction)
+	locales := InteractionLocales(interaction)
+
+	if subcommand == "panel" {
+		return m.postPanel(interaction)
+	}
+
+	ticket, err := m.ChannelTicket(interaction.ChannelID)
+	if err != nil {
+		return err
+	}
+	if ticket == nil || !ticket.Open {
+		err = m.Discord.DeferEphemeral(interaction)
+		if err != nil {
+			return err
+		}
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.not_a_ticket", nil, locales...))
+	}
+
+	switch subcommand {
+	case "claim":
+		return m.claim(interaction, ticket)
+	case "add", "remove":
+		return m.changeMember(interaction, ticket, subcommand == "add", OptionID(options, "user"))
+	case "close":
+		err = m.Discord.DeferEphemeral(interaction)
+		if err != nil {
+			return err
+		}
+		return m.closeFromInteraction(interaction, ticket, OptionString(options, "reason"))
+	}
+	return nil
+}
+
+func (m *TicketModule) postPanel(interaction *discordgo.Interaction) error {
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+	if interaction.Member.Permissions&discordgo.PermissionManageServer == 0 {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.no_permission", nil, locales...))
+	}
+
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	data := NewTemplateData()
+	if guild != nil {
+		data.Guild = NewTemplateGuild(guild)
+	}
+
+	panel, err := m.Localizer.Message("Tickets.PanelMessage", m.Config.PanelMessage, data, GuildLocales(guild)...)
+	if err != nil {
+		return err
+	}
+	if panel == nil || (panel.Content == "" && len(panel.Embeds) == 0) {
+		panel = &discordgo.MessageSend{Content: m.Localizer.Text("tickets.panel", data, GuildLocales(guild)...)}
+	}
+
+	rows := []discordgo.MessageComponent{}
+	for start := 0; start < len(m.Config.Categories); start += 5 {
+		buttons := []discordgo.MessageComponent{}
+		for _, category := range m.Config.Categories[start:min(start+5, len(m.Config.Categories))] {
+			buttons = append(buttons, discordgo.Button{
+				Label:    m.Localizer.ConfigText("Tickets.Categories."+category.Name, category.label(), GuildLocales(guild)...),
+				Emoji:    parseComponentEmoji(category.Emoji),
+				Style:    discordgo.PrimaryButton,
+				CustomID: "TicketOpenButton|" + category.Name,
+			})
+		}
+		rows = append(rows, discordgo.ActionsRow{Components: buttons})
+	}
+	panel.Components = rows
+
+	_, err = m.Discord.ChannelMessageSendComplex(interaction.ChannelID, panel)
+	if err != nil {
+		return WrapError(err)
+	}
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.panel_posted", nil, locales...))
+}
+
+func (m *TicketModule) OpenButtonClick(interaction *discordgo.Interaction) error {
+	args := strings.Split(interaction.MessageComponentData().CustomID, "|")
+	locales := InteractionLocales(interaction)
+	user := interaction.Member.User
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	category := m.Config.FindCategory(args[1])
+	if category == nil {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.unknown_category", nil, locales...))
+	}
+
+	ticket := &Ticket{
+		GuildID:  interaction.GuildID,
+		OwnerID:  user.ID,
+		Category: category.Name,
+		Open:     true,
+	}
+	created, err := m.insertTicket(ticket)
+	if err != nil {
+		return err
+	}
+	if !created {
+		data := NewTemplateData()
+		data.Count = m.Config.openTicketLimit()
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.too_many", data, locales...))
+	}
+
+	channel, err := m.createTicketChannel(interaction, ticket, category)
+	if err != nil {
+		// Don't leave a ticket behind that has no channel
+		_, deleteErr := m.DB.Exec(`DELETE FROM tickets WHERE id = $1`, ticket.ID)
+		if deleteErr != nil {
+			ticketLog.Warn("Could not delete ticket", "ticket", ticket.ID, "error", deleteErr)
+		}
+		return err
+	}
+
+	ticket.ChannelID = channel.ID
+	_, err = m.DB.Exec(`UPDATE tickets SET channel_id = $1 WHERE id = $2`, ticket.ChannelID, ticket.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+
+	err = m.postOpenMessage(interaction, ticket, category)
+	if err != nil {
+		return err
+	}
+
+	ticketLog.Info("Ticket opened", "ticket", ticket.ID, "guild", ticket.GuildID, "user", user.ID)
+
+	data := NewTemplateData()
+	data.Channel = "<#" + channel.ID + ">"
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.opened", data, locales...))
+}
+
+// insertTicket stores a new ticket unless the owner already has
+// MaxOpenTickets open ones. The advisory lock serializes concurrent opens by
+// the same user, so double clicks can't get past the limit.
+func (m *TicketModule) insertTicket(ticket *Ticket) (bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, WrapError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))`, ticket.GuildID, ticket.OwnerID)
+	if err != nil {
+		return false, WrapError(err)
+	}
+
+	var openTickets int
+	err = tx.QueryRow(`SELECT count(*) FROM tickets WHERE guild_id = $1 AND owner_id = $2 AND open`,
+		ticket.GuildID, ticket.OwnerID).Scan(&openTickets)
+	if err != nil {
+		return false, WrapError(err)
+	}
+	if openTickets >= m.Config.openTicketLimit() {
+		return false, nil
+	}
+
+	err = tx.QueryRow(`INSERT INTO tickets (guild_id, owner_id, category) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		ticket.GuildID, ticket.OwnerID, ticket.Category).Scan(&ticket.ID, &ticket.CreatedAt)
+	if err != nil {
+		return false, WrapError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, WrapError(err)
+	}
+	return true, nil
+}
+
+func (m *TicketModule) createTicketChannel(interaction *discordgo.Interaction, ticket *Ticket, category *TicketCategory) (*discordgo.Channel, error) {
+	name := fmt.Sprintf("ticket-%04d", ticket.ID)
+
+	if m.Config.ticketMode() == TicketThreads {
+		thread, err := m.Discord.ThreadStartComplex(interaction.ChannelID, &discordgo.ThreadStart{
+			Name:                name,
+			Type:                discordgo.ChannelTypeGuildPrivateThread,
+			AutoArchiveDuration: 7 * 24 * 60,
+			Invitable:           false,
+		})
+		if err != nil {
+			return nil, WrapError(err)
+		}
+
+		err = m.Discord.ThreadMemberAdd(thread.ID, ticket.OwnerID)
+		if err != nil {
+			// The owner can't see the thread, don't leave it lying around
+			_, deleteErr := m.Discord.ChannelDelete(thread.ID)
+			if deleteErr != nil {
+				ticketLog.Warn("Could not delete thread", "ticket", ticket.ID, "thread", thread.ID, "error", deleteErr)
+			}
+			return nil, WrapError(err)
+		}
+		return thread, nil
+	}
+
+	overwrites := []*discordgo.PermissionOverwrite{
+		{
+			ID:   ticket.GuildID,
+			Type: discordgo.PermissionOverwriteTypeRole,
+			Deny: discordgo.PermissionViewChannel,
+		},
+		{
+			ID:    m.Discord.BotUserID(),
+			Type:  discordgo.PermissionOverwriteTypeMember,
+			Allow: ticketPermissions | discordgo.PermissionManageChannels,
+		},
+		{
+			ID:    ticket.OwnerID,
+			Type:  discordgo.PermissionOverwriteTypeMember,
+			Allow: ticketPermissions,
+		},
+	}
+	for _, roleID := range append(slices.Clone(m.Config.StaffRoles), category.StaffRoles...) {
+		overwrites = append(overwrites, &discordgo.PermissionOverwrite{
+			ID:    roleID,
+			Type:  discordgo.PermissionOverwriteTypeRole,
+			Allow: ticketPermissions,
+		})
+	}
+
+	channel, err := m.Discord.GuildChannelCreateComplex(ticket.GuildID, discordgo.GuildChannelCreateData{
+		Name:                 name,
+		Type:                 discordgo.ChannelTypeGuildText,
+		ParentID:             m.Config.Category,
+		PermissionOverwrites: overwrites,
+	})
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return channel, nil
+}
+
+func (m *TicketModule) postOpenMessage(interaction *discordgo.Interaction, ticket *Ticket, category *TicketCategory) error {
+	guild := m.Discord.CachedGuild(ticket.GuildID)
+	locales := GuildLocales(guild)
+
+	data := NewTemplateData()
+	data.User = NewTemplateUser(interaction.Member.User, interaction.Member)
+	data.Topic = m.Localizer.ConfigText("Tickets.Categories."+category.Name, category.label(), locales...)
+	// The config documents the label as Reason
+	data.Reason = data.Topic
+	data.CaseNumber = ticket.ID
+	if guild != nil {
+		data.Guild = NewTemplateGuild(guild)
+	}
+
+	message, err := m.Localizer.Message("Tickets.OpenMessage", m.Config.OpenMessage, data, locales...)
+	if err != nil {
+		return err
+	}
+	if message == nil || (message.Content == "" && len(message.Embeds) == 0) {
+		message = &discordgo.MessageSend{Content: m.Localizer.Text("tickets.open_message", data, locales...)}
+	}
+
+	// Mentioning staff roles also adds them to private threads
+	mentions := []string{"<@" + ticket.OwnerID + ">"}
+	for _, roleID := range append(slices.Clone(m.Config.StaffRoles), category.StaffRoles...) {
+		mentions = append(mentions, "<@&"+roleID+">")
+	}
+	message.Content = strings.TrimSpace(strings.Join(mentions, " ") + "\n" + message.Content)
+
+	message.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    m.Localizer.Text("tickets.claim_button", nil, locales...),
+					Style:    discordgo.SecondaryButton,
+					CustomID: "TicketClaimButton",
+				},
+				discordgo.Button{
+					Label:    m.Localizer.Text("tickets.close_button", nil, locales...),
+					Style:    discordgo.DangerButton,
+					CustomID: "TicketCloseButton",
+				},
+			},
+		},
+	}
+
+	_, err = m.Discord.ChannelMessageSendComplex(ticket.ChannelID, message)
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Returns nil if the channel isn't a ticket
+func (m *TicketModule) ChannelTicket(channelID string) (*Ticket, error) {
+	ticket := &Ticket{}
+	err := m.DB.QueryRow(`SELECT `+ticketColumns+` FROM tickets WHERE channel_id = $1 ORDER BY id DESC LIMIT 1`, channelID).Scan(
+		&ticket.ID, &ticket.GuildID, &ticket.ChannelID, &ticket.OwnerID, &ticket.Category, &ticket.ClaimedBy,
+		&ticket.Open, &ticket.CloseReason, &ticket.ClosedBy, &ticket.CreatedAt, &ticket.ClosedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return ticket, nil
+}
+
+func (m *TicketModule) ClaimButtonClick(interaction *discordgo.Interaction) error {
+	ticket, err := m.ChannelTicket(interaction.ChannelID)
+	if err != nil {
+		return err
+	}
+	if ticket == nil || !ticket.Open {
+		err = m.Discord.DeferEphemeral(interaction)
+		if err != nil {
+			return err
+		}
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.not_a_ticket", nil, InteractionLocales(interaction)...))
+	}
+	return m.claim(interaction, ticket)
+}
+
+func (m *TicketModule) claim(interaction *discordgo.Interaction, ticket *Ticket) error {
+	locales := InteractionLocales(interaction)
+	staff := interaction.Member
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	if !m.isStaff(staff, m.Config.FindCategory(ticket.Category)) {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.staff_only", nil, locales...))
+	}
+
+	// Only one of several staff members clicking at once gets the ticket
+	result, err := m.DB.Exec(`UPDATE tickets SET claimed_by = $1 WHERE id = $2 AND claimed_by = ''`, staff.User.ID, ticket.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+	if claimed, _ := result.RowsAffected(); claimed == 0 {
+		err = m.DB.QueryRow(`SELECT claimed_by FROM tickets WHERE id = $1`, ticket.ID).Scan(&ticket.ClaimedBy)
+		if err != nil {
+			return WrapError(err)
+		}
+		data := NewTemplateData()
+		data.Staff = NewTemplateUserFromID(ticket.ClaimedBy)
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.already_claimed", data, locales...))
+	}
+
+	data := NewTemplateData()
+	data.Staff = NewTemplateUser(staff.User, staff)
+	_, err = m.Discord.ChannelMessageSend(ticket.ChannelID,
+		m.Localizer.Text("tickets.claimed", data, GuildLocales(m.Discord.CachedGuild(ticket.GuildID))...))
+	if err != nil {
+		return WrapError(err)
+	}
+
+	ticketLog.Info("Ticket claimed", "ticket", ticket.ID, "guild", ticket.GuildID, "staff", staff.User.ID)
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.claim_done", nil, locales...))
+}
+
+func (m *TicketModule) changeMember(interaction *discordgo.Interaction, ticket *Ticket, add bool, userID string) error {
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	staff := m.isStaff(interaction.Member, m.Config.FindCategory(ticket.Category))
+	// The owner can bring others in, but only staff can take people out
+	if !staff && !(add && interaction.Member.User.ID == ticket.OwnerID) {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.staff_only", nil, locales...))
+	}
+	if !add && userID == ticket.OwnerID {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.cant_remove_owner", nil, locales...))
+	}
+
+	if m.Config.ticketMode() == TicketThreads {
+		if add {
+			err = m.Discord.ThreadMemberAdd(ticket.ChannelID, userID)
+		} else {
+			err = m.Discord.ThreadMemberRemove(ticket.ChannelID, userID)
+		}
+	} else {
+		if add {
+			err = m.Discord.ChannelPermissionSet(ticket.ChannelID, userID, discordgo.PermissionOverwriteTypeMember, ticketPermissions, 0)
+		} else {
+			err = m.Discord.ChannelPermissionDelete(ticket.ChannelID, userID)
+		}
+	}
+	if err != nil {
+		return WrapError(err)
+	}
+
+	data := NewTemplateData()
+	data.User = NewTemplateUserFromID(userID)
+	key := "tickets.user_removed"
+	if add {
+		key = "tickets.user_added"
+	}
+	return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text(key, data, locales...))
+}
+
+func (m *TicketModule) CloseButtonClick(interaction *discordgo.Interaction) error {
+	locales := InteractionLocales(interaction)
+
+	err := m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "TicketCloseModal",
+			Title:    m.Localizer.Text("tickets.close_modal.title", nil, locales...),
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "Reason",
+							Label:     m.Localizer.Text("tickets.close_modal.reason", nil, locales...),
+							Style:     discordgo.TextInputParagraph,
+							Required:  false,
+							MaxLength: 1000,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (m *TicketModule) CloseModalSubmit(interaction *discordgo.Interaction) error {
+	modalData := interaction.ModalSubmitData()
+	reason := modalData.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+	err := m.Discord.DeferEphemeral(interaction)
+	if err != nil {
+		return err
+	}
+
+	ticket, err := m.ChannelTicket(interaction.ChannelID)
+	if err != nil {
+		return err
+	}
+	if ticket == nil || !ticket.Open {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.not_a_ticket", nil, InteractionLocales(interaction)...))
+	}
+	return m.closeFromInteraction(interaction, ticket, reason)
+}
+
+// Expects the interaction to be deferred
+func (m *TicketModule) closeFromInteraction(interaction *discordgo.Interaction, ticket *Ticket, reason string) error {
+	locales := InteractionLocales(interaction)
+	closer := interaction.Member
+
+	if closer.User.ID != ticket.OwnerID && !m.isStaff(closer, m.Config.FindCategory(ticket.Category)) {
+		return m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.staff_only", nil, locales...))
+	}
+
+	// Answer before the channel goes away
+	err := m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("tickets.closing", nil, locales...))
+	if err != nil {
+		return err
+	}
+
+	return m.Close(ticket, closer.User, reason)
+}
+
+// Saves the transcript, posts it and removes the ticket channel. Does nothing
+// if the ticket was closed in the meantime.
+func (m *TicketModule) Close(ticket *Ticket, closer *discordgo.User, reason string) error {
+	now := time.Now()
+	ticket.Open = false
+	ticket.ClosedBy = closer.ID
+	ticket.CloseReason = reason
+	ticket.ClosedAt = &now
+
+	// Claimed first so that a second close finds the ticket closed, without
+	// holding a row lock while the messages are fetched from Discord
+	result, err := m.DB.Exec(`UPDATE tickets SET open = FALSE, closed_by = $1, close_reason = $2, closed_at = $3 WHERE id = $4 AND open`,
+		ticket.ClosedBy, ticket.CloseReason, ticket.ClosedAt, ticket.ID)
+	if err != nil {
+		return WrapError(err)
+	}
+	if closed, _ := result.RowsAffected(); closed == 0 {
+		ticketLog.Info("Ticket was already closed", "ticket", ticket.ID, "guild", ticket.GuildID, "user", closer.ID)
+		return nil
+	}
+
+	messages, err := m.fetchMessages(ticket.ChannelID)
+	if err != nil {
+		// Reopened so that closing can be tried again
+		_, reopenErr := m.DB.Exec(`UPDATE tickets SET open = TRUE, closed_by = '', close_reason = '', closed_at = NULL WHERE id = $1`, ticket.ID)
+		if reopenErr != nil {
+			ticketLog.Error("Could not reopen ticket", "ticket", ticket.ID, "guild", ticket.GuildID, "error", ErrorToStr(reopenErr))
+		}
+		return err
+	}
+	transcript := NewTicketTranscript(ticket, messages)
+
+	_, err = m.DB.Exec(`INSERT INTO ticket_transcripts (ticket_id, text, html) VALUES ($1, $2, $3)
+		ON CONFLICT (ticket_id) DO UPDATE SET text = $2, html = $3`,
+		ticket.ID, transcript.Text(), transcript.HTML())
+	if err != nil {
+		return WrapError(err)
+	}
+
+	err = m.postTranscript(ticket, transcript)
+	if err != nil {
+		// The transcript is in the database, closing should still go through
+		ticketLog.Warn("Could not post transcript", "ticket", ticket.ID, "guild", ticket.GuildID, "error", ErrorToStr(err))
+	}
+
+	if m.Config.ticketMode() == TicketThreads {
+		_, err = m.Discord.ChannelEdit(ticket.ChannelID, &discordgo.ChannelEdit{
+			Archived: Ptr(true),
+			Locked:   Ptr(true),
+		})
+	} else {
+		_, err = m.Discord.ChannelDelete(ticket.ChannelID)
+	}
+	if err != nil {
+		return WrapError(err)
+	}
+
+	ticketLog.Info("Ticket closed", "ticket", ticket.ID, "guild", ticket.GuildID, "user", closer.ID)
+	return nil
+}
+
+// All messages of the channel, oldest first
+func (m *TicketModule) fetchMessages(channelID string) ([]*discordgo.Message, error) {
+	messages := []*discordgo.Message{}
+	before := ""
+	for {
+		page, err := m.Discord.ChannelMessages(channelID, 100, before, "", "")
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		messages = append(messages, page...)
+		if len(page) < 100 {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+func (m *TicketModule) postTranscript(ticket *Ticket, transcript *TicketTranscript) error {
+	if m.Config.TranscriptChannel == "" {
+		return nil
+	}
+
+	locales := GuildLocales(m.Discord.CachedGuild(ticket.GuildID))
+	data := NewTemplateData()
+	data.CaseNumber = ticket.ID
+
+	reason := ticket.CloseReason
+	if reason == "" {
+		reason = "-"
+	}
+	claimedBy := "-"
+	if ticket.ClaimedBy != "" {
+		claimedBy = "<@" + ticket.ClaimedBy + ">"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeRich,
+		Title: m.Localizer.Text("tickets.transcript_title", data, locales...),
+		Color: ColorBlue,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   m.Localizer.Text("tickets.field.owner", nil, locales...),
+				Value:  "<@" + ticket.OwnerID + ">",
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("tickets.field.category", nil, locales...),
+				Value:  ticket.Category,
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("tickets.field.claimed_by", nil, locales...),
+				Value:  claimedBy,
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("tickets.field.closed_by", nil, locales...),
+				Value:  "<@" + ticket.ClosedBy + ">",
+				Inline: true,
+			},
+			{
+				Name:   m.Localizer.Text("tickets.field.messages", nil, locales...),
+				Value:  strconv.Itoa(len(transcript.Messages)),
+				Inline: true,
+			},
+			{
+				Name:  m.Localizer.Text("moderation.field.reason", nil, locales...),
+				Value: truncateField(reason),
+			},
+		},
+		Timestamp: ticket.ClosedAt.Format(time.RFC3339),
+	}
+
+	name := fmt.Sprintf("ticket-%04d", ticket.ID)
+	_, err := m.Discord.ChannelMessageSendComplex(m.Config.TranscriptChannel, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Files: []*discordgo.File{
+			{
+				Name:        name + ".html",
+				ContentType: "text/html",
+				Reader:      strings.NewReader(transcript.HTML()),
+			},
+			{
+				Name:        name + ".txt",
+				ContentType: "text/plain",
+				Reader:      strings.NewReader(transcript.Text()),
+			},
+		},
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
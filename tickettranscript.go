@@ -0,0 +1,119 @@
+// Ticket transcripts
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type TicketTranscript struct {
+	Ticket   *Ticket
+	Messages []*discordgo.Message
+}
+
+// Messages are expected oldest first
+func NewTicketTranscript(ticket *Ticket, messages []*discordgo.Message) *TicketTranscript {
+	return &TicketTranscript{
+		Ticket:   ticket,
+		Messages: messages,
+	}
+}
+
+func (t *TicketTranscript) header() []string {
+	lines := []string{
+		fmt.Sprintf("Ticket #%d (%s)", t.Ticket.ID, t.Ticket.Category),
+		fmt.Sprintf("Opened by %s at %s", t.Ticket.OwnerID, t.Ticket.CreatedAt.UTC().Format(time.DateTime)),
+	}
+	if t.Ticket.ClosedAt != nil {
+		line := fmt.Sprintf("Closed by %s at %s", t.Ticket.ClosedBy, t.Ticket.ClosedAt.UTC().Format(time.DateTime))
+		if t.Ticket.CloseReason != "" {
+			line += ": " + t.Ticket.CloseReason
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Embeds sent by bots are summarized by title and description
+func transcriptEmbedText(embed *discordgo.MessageEmbed) string {
+	parts := []string{}
+	if embed.Title != "" {
+		parts = append(parts, embed.Title)
+	}
+	if embed.Description != "" {
+		parts = append(parts, embed.Description)
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (t *TicketTranscript) Text() string {
+	var builder strings.Builder
+	for _, line := range t.header() {
+		builder.WriteString(line + "\n")
+	}
+	builder.WriteString("\n")
+
+	for _, message := range t.Messages {
+		fmt.Fprintf(&builder, "[%s] %s (%s): %s\n",
+			message.Timestamp.UTC().Format(time.DateTime), message.Author.Username, message.Author.ID, message.Content)
+		for _, embed := range message.Embeds {
+			builder.WriteString("    [embed] " + transcriptEmbedText(embed) + "\n")
+		}
+		for _, attachment := range message.Attachments {
+			builder.WriteString("    [attachment] " + attachment.URL + "\n")
+		}
+	}
+	return builder.String()
+}
+
+const transcriptStyle = `
+body { background: #313338; color: #dbdee1; font-family: sans-serif; margin: 2em; }
+header { border-bottom: 1px solid #4e5058; margin-bottom: 1em; }
+.message { display: flex; gap: 1em; margin: 0.8em 0; }
+.message img.avatar { width: 40px; height: 40px; border-radius: 50%; }
+.author { font-weight: bold; color: #f2f3f5; }
+.time { color: #949ba4; font-size: 0.8em; margin-left: 0.5em; }
+.content { white-space: pre-wrap; }
+.embed { border-left: 4px solid #5865f2; background: #2b2d31; padding: 0.5em; margin-top: 0.3em; }
+a { color: #00a8fc; }
+`
+
+func (t *TicketTranscript) HTML() string {
+	var builder strings.Builder
+	title := html.EscapeString(fmt.Sprintf("Ticket #%d", t.Ticket.ID))
+
+	builder.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	builder.WriteString("<title>" + title + "</title>\n<style>" + transcriptStyle + "</style>\n</head>\n<body>\n<header>\n")
+	for _, line := range t.header() {
+		builder.WriteString("<p>" + html.EscapeString(line) + "</p>\n")
+	}
+	builder.WriteString("</header>\n")
+
+	for _, message := range t.Messages {
+		builder.WriteString("<div class=\"message\">\n")
+		fmt.Fprintf(&builder, "<img class=\"avatar\" src=\"%s\" alt=\"\">\n", html.EscapeString(message.Author.AvatarURL("64")))
+		builder.WriteString("<div>\n")
+		fmt.Fprintf(&builder, "<span class=\"author\" title=\"%s\">%s</span><span class=\"time\">%s</span>\n",
+			html.EscapeString(message.Author.ID), html.EscapeString(message.Author.Username),
+			message.Timestamp.UTC().Format(time.DateTime))
+		if message.Content != "" {
+			builder.WriteString("<div class=\"content\">" + html.EscapeString(message.Content) + "</div>\n")
+		}
+		for _, embed := range message.Embeds {
+			builder.WriteString("<div class=\"embed\">" + html.EscapeString(transcriptEmbedText(embed)) + "</div>\n")
+		}
+		for _, attachment := range message.Attachments {
+			url := html.EscapeString(attachment.URL)
+			fmt.Fprintf(&builder, "<div><a href=\"%s\">%s</a></div>\n", url, html.EscapeString(attachment.Filename))
+		}
+		builder.WriteString("</div>\n</div>\n")
+	}
+
+	builder.WriteString("</body>\n</html>\n")
+	return builder.String()
+}
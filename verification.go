@@ -5,6 +5,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/enescakir/emoji"
@@ -20,66 +21,122 @@ type VerificationConfigFormField struct {
 
 type VerificationConfig struct {
 	InitialRole      string
-	WelcomeMessage   string
+	WelcomeMessage   *MessageTemplate
 	VerifyButtonText string
 
 	FormTitle             string
 	FormFields            []VerificationConfigFormField
 	FormSubmitChannel     string
-	FormSubmitUserMessage string
-	FormEmbedDescription  string
+	FormSubmitUserMessage *MessageTemplate
+	FormEmbedDescription  *Template
 
 	ApproveButtonText string
 	DenyButtonText    string
 	BanButtonText     string
 
 	ApprovedRole                string
-	ApprovedAnnouncementMessage string
+	ApprovedAnnouncementMessage *MessageTemplate
 	ApprovedAnnouncementChannel string
 	ApprovedFormChannel         string
 
-	DenyDmMessage string
+	DenyDmMessage *MessageTemplate
+}
+
+var verificationLog = ModuleLogger("verification")
+
+var (
+	verificationClicks = NewCounter("fbot_verification_button_clicks_total",
+		"Clicks on the verify button.")
+	verificationForms = NewCounter("fbot_verification_forms_submitted_total",
+		"Verification forms submitted.")
+	verificationDecisions = NewCounter("fbot_verification_decisions_total",
+		"Staff decisions on verification forms, by decision.", "decision")
+	verificationDecisionTime = NewHistogram("fbot_verification_decision_seconds",
+		"Time from form submission to the staff decision, by decision.",
+		[]float64{60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600}, "decision")
+)
+
+// Counts a decision on the form posted as the given staff room message
+func observeVerificationDecision(decision string, formMessageID string) {
+	verificationDecisions.Inc(decision)
+	submitted, err := discordgo.SnowflakeTimestamp(formMessageID)
+	if err == nil {
+		verificationDecisionTime.Observe(time.Since(submitted).Seconds(), decision)
+	}
 }
 
 type VerificationModule struct {
-	Discord *Discord
-	Config  *VerificationConfig
+	Discord   DiscordAPI
+	Localizer *Localizer
+	AntiRaid  *AntiRaidModule
+	Config    *VerificationConfig
 }
 
 const (
 	ColorRed        int = 15548997
 	ColorGreen      int = 5763719
 	ColorDarkOrange int = 11027200
+	ColorBlue       int = 3447003
 )
 
+func (c *VerificationConfig) Validate() error {
+	messageTemplates := map[string]*MessageTemplate{
+		"WelcomeMessage":              c.WelcomeMessage,
+		"FormSubmitUserMessage":       c.FormSubmitUserMessage,
+		"ApprovedAnnouncementMessage": c.ApprovedAnnouncementMessage,
+		"DenyDmMessage":               c.DenyDmMessage,
+	}
+	for name, messageTemplate := range messageTemplates {
+		if messageTemplate == nil {
+			return Errorf("VerificationSystem.%s is missing", name)
+		}
+		err := messageTemplate.Validate("VerificationSystem." + name)
+		if err != nil {
+			return Errorf("VerificationSystem.%s: %w", name, err)
+		}
+	}
+
+	err := c.FormEmbedDescription.Validate("VerificationSystem.FormEmbedDescription")
+	if err != nil {
+		return Errorf("VerificationSystem.FormEmbedDescription: %w", err)
+	}
+
+	return nil
+}
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "verification",
+		Intents: discordgo.IntentsGuildMembers | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent,
+	}, func(config *Config) *VerificationConfig {
+		return config.VerificationSystem
+	}, NewVerificationModule)
+}
+
 func NewVerificationModule(config *VerificationConfig) *VerificationModule {
 	return &VerificationModule{
 		Config: config,
 	}
 }
 
-func (m *VerificationModule) Register(bot *Bot) {
-	Logf("Registering module")
+func (m *VerificationModule) Register(bot *Bot) error {
+	verificationLog.Info("Registering module")
 
 	m.Discord = bot.Discord
-	m.Discord.AddHandler(func(_ *discordgo.Session, event *discordgo.GuildMemberAdd) {
-		err := m.OnGuildMemberAdd(event)
-		if err != nil {
-			Logf("Error: %s", ErrorToStr(err))
-		}
-	})
-	m.Discord.AddHandler(func(_ *discordgo.Session, event *discordgo.MessageCreate) {
-		err := m.OnMessageCreate(event)
-		if err != nil {
-			Logf("Error: %s", ErrorToStr(err))
-		}
-	})
-	m.Discord.AddHandler(func(_ *discordgo.Session, event *discordgo.InteractionCreate) {
-		err := m.OnInteractionCreate(event)
-		if err != nil {
-			Logf("Error: %s", ErrorToStr(err))
-		}
-	})
+	m.Localizer = bot.Localizer
+	m.AntiRaid = FindModule[*AntiRaidModule](bot)
+	HandleEvent(bot.Discord, m.OnGuildMemberAdd)
+	HandleEvent(bot.Discord, m.OnMessageCreate)
+
+	bot.Router.AddComponent("VerifyButton", m.SendVerifyFormModal)
+	bot.Router.AddComponent("VerificationApproveButton", m.VerificationApproveButtonClick)
+	bot.Router.AddComponent("VerificationDenyButton", m.VerificationDenyButtonClick)
+	bot.Router.AddComponent("VerificationBanButton", m.VerificationBanButtonClick)
+	bot.Router.AddComponent("VerificationBanConfirmYesButton", m.VerificationBanConfirmYesButtonClick)
+	bot.Router.AddComponent("VerificationBanConfirmNoButton", m.VerificationBanConfirmNoButtonClick)
+	bot.Router.AddModal("VerifyFormModal", m.SubmitVerifyForm)
+	bot.Router.AddModal("VerificationDenyModal", m.VerificationDenyModalSubmit)
+	return nil
 }
 
 func (m *VerificationModule) OnGuildMemberAdd(member *discordgo.GuildMemberAdd) error {
@@ -88,28 +145,34 @@ func (m *VerificationModule) OnGuildMemberAdd(member *discordgo.GuildMemberAdd)
 		return WrapError(err)
 	}
 
-	Logf("Auto assigned role %v to user %v on join", m.Config.InitialRole, member.Member.DisplayName())
+	verificationLog.Info("Assigned initial role on join", "role", m.Config.InitialRole, "guild", member.GuildID, "user", member.User.ID)
 	return nil
 }
 
 func (m *VerificationModule) OnMessageCreate(message *discordgo.MessageCreate) error {
 	if strings.EqualFold(message.Content, "!SpawnVerifyButton") {
-		messageData := &discordgo.MessageSend{
-			Content: m.Config.WelcomeMessage,
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						&discordgo.Button{
-							Label:    m.Config.VerifyButtonText,
-							Style:    discordgo.PrimaryButton,
-							CustomID: "VerifyButton",
-						},
+		guild := m.Discord.CachedGuild(message.GuildID)
+		locales := GuildLocales(guild)
+		data := NewTemplateData()
+		data.Guild = NewTemplateGuild(guild)
+
+		messageData, err := m.Localizer.Message("VerificationSystem.WelcomeMessage", m.Config.WelcomeMessage, data, locales...)
+		if err != nil {
+			return err
+		}
+		messageData.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					&discordgo.Button{
+						Label:    m.Localizer.ConfigText("VerificationSystem.VerifyButtonText", m.Config.VerifyButtonText, locales...),
+						Style:    discordgo.PrimaryButton,
+						CustomID: "VerifyButton",
 					},
 				},
 			},
 		}
 
-		_, err := m.Discord.ChannelMessageSendComplex(message.ChannelID, messageData)
+		_, err = m.Discord.ChannelMessageSendComplex(message.ChannelID, messageData)
 		if err != nil {
 			return WrapError(err)
 		}
@@ -123,70 +186,34 @@ func (m *VerificationModule) OnMessageCreate(message *discordgo.MessageCreate) e
 	return nil
 }
 
-func (m *VerificationModule) OnInteractionCreate(interaction *discordgo.InteractionCreate) error {
-	if interaction.Type == discordgo.InteractionMessageComponent {
-		data := interaction.MessageComponentData()
-		if data.CustomID == "VerifyButton" {
-			err := m.SendVerifyFormModal(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationApproveButton|") {
-			err := m.VerificationApproveButtonClick(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationDenyButton|") {
-			err := m.VerificationDenyButtonClick(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationBanButton|") {
-			err := m.VerificationBanButtonClick(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationBanConfirmYesButton|") {
-			err := m.VerificationBanConfirmYesButtonClick(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationBanConfirmNoButton|") {
-			err := m.VerificationBanConfirmNoButtonClick(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	if interaction.Type == discordgo.InteractionModalSubmit {
-		data := interaction.ModalSubmitData()
-		if data.CustomID == "VerifyFormModal" {
-			err := m.SubmitVerifyForm(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(data.CustomID, "VerificationDenyModal") {
-			err := m.VerificationDenyModalSubmit(interaction.Interaction)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
 func (m *VerificationModule) SendVerifyFormModal(interaction *discordgo.Interaction) error {
 	components := []discordgo.MessageComponent{}
+	locales := InteractionLocales(interaction)
+	verificationClicks.Inc()
+
+	if m.AntiRaid != nil && m.AntiRaid.VerificationPaused(interaction.GuildID) {
+		err := m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: m.Localizer.Text("verification.paused", nil, locales...),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			return WrapError(err)
+		}
+		return nil
+	}
 
-	for _, formField := range m.Config.FormFields {
+	for i, formField := range m.Config.FormFields {
+		key := fmt.Sprintf("VerificationSystem.FormFields.%d.", i)
 		textInput := discordgo.TextInput{
+			// The configured label identifies the answer regardless of locale
 			CustomID:    formField.Label,
-			Label:       formField.Label,
+			Label:       m.Localizer.ConfigText(key+"Label", formField.Label, locales...),
 			Style:       discordgo.TextInputShort,
 			Required:    true,
-			Placeholder: formField.Placeholder,
+			Placeholder: m.Localizer.ConfigText(key+"Placeholder", formField.Placeholder, locales...),
 			MaxLength:   1000,
 		}
 
@@ -215,7 +242,7 @@ func (m *VerificationModule) SendVerifyFormModal(interaction *discordgo.Interact
 		Type: discordgo.InteractionResponseModal,
 		Data: &discordgo.InteractionResponseData{
 			CustomID:   "VerifyFormModal",
-			Title:      m.Config.FormTitle,
+			Title:      m.Localizer.ConfigText("VerificationSystem.FormTitle", m.Config.FormTitle, locales...),
 			Components: components,
 		},
 	})
@@ -228,7 +255,8 @@ func (m *VerificationModule) SendVerifyFormModal(interaction *discordgo.Interact
 func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction) error {
 	var err error
 
-	Logf("Verification form submitted by user %v (%v)", interaction.Member.DisplayName(), interaction.Member.User.ID)
+	verificationLog.Info("Form submitted", "guild", interaction.GuildID, "user", interaction.Member.User.ID)
+	verificationForms.Inc()
 
 	// Acknowledge the interaction
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
@@ -242,8 +270,21 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 	}
 
 	// Craft the staff room message
-	embedDescription := m.Config.FormEmbedDescription
-	embedDescription = strings.ReplaceAll(embedDescription, "$USER", interaction.Member.Mention())
+	modalData := interaction.ModalSubmitData()
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+	guildLocales := GuildLocales(guild)
+	data := NewTemplateData()
+	data.Guild = NewTemplateGuild(guild)
+	data.User = NewTemplateUser(nil, interaction.Member)
+	for _, component := range modalData.Components {
+		textInput := component.(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput)
+		data.Answers[textInput.CustomID] = textInput.Value
+	}
+
+	embedDescription, err := m.Config.FormEmbedDescription.Render(data)
+	if err != nil {
+		return err
+	}
 
 	embed := &discordgo.MessageEmbed{
 		Type: discordgo.EmbedTypeRich,
@@ -254,7 +295,6 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 		Description: embedDescription,
 	}
 
-	modalData := interaction.ModalSubmitData()
 	for _, component := range modalData.Components {
 		textInput := component.(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput)
 		// CustomID is the field question
@@ -267,11 +307,11 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 
 	userCreateTime, _ := discordgo.SnowflakeTimestamp(interaction.Member.User.ID)
 	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
-		Name:  emoji.PageFacingUp.String() + " User ID",
+		Name:  emoji.PageFacingUp.String() + " " + m.Localizer.Text("verification.field.user_id", data, guildLocales...),
 		Value: interaction.Member.User.ID,
 	})
 	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
-		Name:  emoji.ThreeThirty.String() + " Account created",
+		Name:  emoji.ThreeThirty.String() + " " + m.Localizer.Text("verification.field.account_created", data, guildLocales...),
 		Value: userCreateTime.Local().Format("2006-01-02 15:04:05"),
 	})
 
@@ -282,7 +322,7 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 			discordgo.ActionsRow{
 				Components: []discordgo.MessageComponent{
 					&discordgo.Button{
-						Label: "Approve",
+						Label: m.Localizer.ConfigText("VerificationSystem.ApproveButtonText", m.Config.ApproveButtonText, guildLocales...),
 						Emoji: &discordgo.ComponentEmoji{
 							Name: emoji.ThumbsUp.String(),
 						},
@@ -290,7 +330,7 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 						CustomID: "VerificationApproveButton|" + userID,
 					},
 					&discordgo.Button{
-						Label: "Deny",
+						Label: m.Localizer.ConfigText("VerificationSystem.DenyButtonText", m.Config.DenyButtonText, guildLocales...),
 						Emoji: &discordgo.ComponentEmoji{
 							Name: emoji.ThumbsDown.String(),
 						},
@@ -298,7 +338,7 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 						CustomID: "VerificationDenyButton|" + userID,
 					},
 					&discordgo.Button{
-						Label: "Ban",
+						Label: m.Localizer.ConfigText("VerificationSystem.BanButtonText", m.Config.BanButtonText, guildLocales...),
 						Emoji: &discordgo.ComponentEmoji{
 							Name: emoji.Hammer.String(),
 						},
@@ -315,10 +355,13 @@ func (m *VerificationModule) SubmitVerifyForm(interaction *discordgo.Interaction
 	}
 
 	// Provide action feedback
-	userMessage := m.Config.FormSubmitUserMessage
-	userMessage = strings.ReplaceAll(userMessage, "$USER", interaction.Member.Mention())
+	userMessage, err := m.Localizer.Message("VerificationSystem.FormSubmitUserMessage", m.Config.FormSubmitUserMessage, data, InteractionLocales(interaction)...)
+	if err != nil {
+		return err
+	}
 	_, err = m.Discord.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
-		Content: userMessage,
+		Content: userMessage.Content,
+		Embeds:  userMessage.Embeds,
 		Flags:   discordgo.MessageFlagsEphemeral,
 	})
 	if err != nil {
@@ -334,7 +377,8 @@ func (m *VerificationModule) VerificationApproveButtonClick(interaction *discord
 	// CustomID contains the original user ID
 	userID := strings.Split(interaction.MessageComponentData().CustomID, "|")[1]
 
-	Logf("Verification of user %v approved by staff %v (%v)", userID, interaction.Member.DisplayName(), interaction.Member.User.ID)
+	verificationLog.Info("Verification approved", "guild", interaction.GuildID, "user", userID, "staff", interaction.Member.User.ID)
+	observeVerificationDecision("approved", interaction.Message.ID)
 
 	// Acknowledge the interaction
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
@@ -350,16 +394,18 @@ func (m *VerificationModule) VerificationApproveButtonClick(interaction *discord
 	// Add new role to the user, remove old role
 	err = m.Discord.GuildMemberRoleRemove(interaction.GuildID, userID, m.Config.InitialRole)
 	if err != nil {
-		Logf("Warning: Failed to remove initial role from user %v: %v", userID, err)
+		verificationLog.Warn("Could not remove initial role", "guild", interaction.GuildID, "user", userID, "error", err)
 	}
 	err = m.Discord.GuildMemberRoleAdd(interaction.GuildID, userID, m.Config.ApprovedRole)
 	if err != nil {
-		Logf("Warning: Failed to add approved role to user %v: %v", userID, err)
+		verificationLog.Warn("Could not add approved role", "guild", interaction.GuildID, "user", userID, "error", err)
 	}
 
 	// Send form copy to another channel
 	embeds := interaction.Message.Embeds
 	embeds[0].Fields = embeds[0].Fields[:len(embeds[0].Fields)-2]
+
+	data, guildLocales := m.StaffActionTemplateData(interaction, userID)
 	approvedFormMessage := &discordgo.MessageSend{
 		Embeds: interaction.Message.Embeds,
 	}
@@ -369,16 +415,19 @@ func (m *VerificationModule) VerificationApproveButtonClick(interaction *discord
 	}
 
 	// Send announcement message
-	announcementMessage := m.Config.ApprovedAnnouncementMessage
-	announcementMessage = strings.ReplaceAll(announcementMessage, "$USER", "<@"+userID+">")
-	_, err = m.Discord.ChannelMessageSend(m.Config.ApprovedAnnouncementChannel, announcementMessage)
+	announcementMessage, err := m.Localizer.Message("VerificationSystem.ApprovedAnnouncementMessage", m.Config.ApprovedAnnouncementMessage, data, guildLocales...)
+	if err != nil {
+		return err
+	}
+	// Sent right away, staff should hear about it if the announcement fails
+	_, err = m.Discord.ChannelMessageSendComplex(m.Config.ApprovedAnnouncementChannel, announcementMessage)
 	if err != nil {
 		return WrapError(err)
 	}
 
 	// Provide action feedback
 	_, err = m.Discord.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
-		Content: "Verification approved",
+		Content: m.Localizer.Text("verification.approved", data, InteractionLocales(interaction)...),
 		Flags:   discordgo.MessageFlagsEphemeral,
 	})
 	if err != nil {
@@ -389,7 +438,7 @@ func (m *VerificationModule) VerificationApproveButtonClick(interaction *discord
 	embeds = interaction.Message.Embeds
 	embeds[0].Color = ColorGreen
 	embeds[0].Footer = &discordgo.MessageEmbedFooter{
-		Text:    "Approved by " + fmt.Sprintf("%s (%s)", interaction.Member.DisplayName(), interaction.Member.User.ID),
+		Text:    m.Localizer.Text("verification.approved_footer", data, guildLocales...),
 		IconURL: interaction.Member.AvatarURL(""),
 	}
 	messageEdit := &discordgo.MessageEdit{
@@ -408,17 +457,19 @@ func (m *VerificationModule) VerificationApproveButtonClick(interaction *discord
 
 func (m *VerificationModule) VerificationDenyButtonClick(interaction *discordgo.Interaction) error {
 	userID := strings.Split(interaction.MessageComponentData().CustomID, "|")[1]
+	locales := InteractionLocales(interaction)
 
 	err := m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseModal,
 		Data: &discordgo.InteractionResponseData{
 			CustomID: "VerificationDenyModal|" + userID,
-			Title:    "Deny verification",
+			Title:    m.Localizer.Text("verification.deny_modal.title", nil, locales...),
 			Components: []discordgo.MessageComponent{
 				discordgo.ActionsRow{
 					Components: []discordgo.MessageComponent{
 						discordgo.TextInput{
-							Label:    "Reason",
+							CustomID: "Reason",
+							Label:    m.Localizer.Text("verification.deny_modal.reason", nil, locales...),
 							Style:    discordgo.TextInputParagraph,
 							Required: false,
 						},
@@ -441,7 +492,11 @@ func (m *VerificationModule) VerificationDenyModalSubmit(interaction *discordgo.
 	userID := strings.Split(modalData.CustomID, "|")[1]
 	reasonText := modalData.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
 
-	Logf("Verification of user %v denied by staff %v (%v)", userID, interaction.Member.DisplayName(), interaction.Member.User.ID)
+	verificationLog.Info("Verification denied", "guild", interaction.GuildID, "user", userID, "staff", interaction.Member.User.ID)
+	observeVerificationDecision("denied", interaction.Message.ID)
+
+	data, guildLocales := m.StaffActionTemplateData(interaction, userID)
+	data.Reason = reasonText
 
 	// Acknowledge the interaction
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
@@ -457,22 +512,24 @@ func (m *VerificationModule) VerificationDenyModalSubmit(interaction *discordgo.
 	// DM the denied user
 	dmChannel, err := m.Discord.UserChannelCreate(userID)
 	if err != nil {
-		Logf("Warning: Could not DM user %v with deny reason: %v", userID, err)
+		verificationLog.Warn("Could not DM deny reason", "guild", interaction.GuildID, "user", userID, "error", err)
 	} else {
-		denyMessage := m.Config.DenyDmMessage
-		denyMessage = strings.ReplaceAll(denyMessage, "$USER", "<@"+userID+">")
-		denyMessage = strings.ReplaceAll(denyMessage, "$STAFF", interaction.Member.User.Mention())
-		denyMessage = strings.ReplaceAll(denyMessage, "$REASON", reasonText)
-		_, err = m.Discord.ChannelMessageSend(dmChannel.ID, denyMessage)
+		denyMessage, err := m.Localizer.Message("VerificationSystem.DenyDmMessage", m.Config.DenyDmMessage, data, guildLocales...)
 		if err != nil {
-			Logf("Warning: Could not DM user %v: %v", userID, err)
+			// Don't leave staff with a response that never finishes
+			m.Discord.FollowupEphemeral(interaction, m.Localizer.Text("verification.deny_failed", nil, InteractionLocales(interaction)...))
+			return err
+		}
+		_, err = m.Discord.ChannelMessageSendComplex(dmChannel.ID, denyMessage)
+		if err != nil {
+			verificationLog.Warn("Could not DM deny reason", "guild", interaction.GuildID, "user", userID, "error", err)
 		}
 
 	}
 
 	// Provide action feedback
 	_, err = m.Discord.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
-		Content: "Verification denied",
+		Content: m.Localizer.Text("verification.denied", data, InteractionLocales(interaction)...),
 		Flags:   discordgo.MessageFlagsEphemeral,
 	})
 	if err != nil {
@@ -483,7 +540,7 @@ func (m *VerificationModule) VerificationDenyModalSubmit(interaction *discordgo.
 	embeds := interaction.Message.Embeds
 	embeds[0].Color = ColorDarkOrange
 	embeds[0].Footer = &discordgo.MessageEmbedFooter{
-		Text:    "Denied by " + fmt.Sprintf("%s (%s)", interaction.Member.DisplayName(), interaction.Member.User.ID),
+		Text:    m.Localizer.Text("verification.denied_footer", data, guildLocales...),
 		IconURL: interaction.Member.AvatarURL(""),
 	}
 	messageEdit := &discordgo.MessageEdit{
@@ -504,22 +561,23 @@ func (m *VerificationModule) VerificationBanButtonClick(interaction *discordgo.I
 	var err error
 
 	userID := strings.Split(interaction.MessageComponentData().CustomID, "|")[1]
+	locales := InteractionLocales(interaction)
 
 	// Send confirmation message
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "Are you sure you want to ban the user?",
+			Content: m.Localizer.Text("verification.ban_confirm", nil, locales...),
 			Components: []discordgo.MessageComponent{
 				discordgo.ActionsRow{
 					Components: []discordgo.MessageComponent{
 						&discordgo.Button{
-							Label:    "Yes, I am sure",
+							Label:    m.Localizer.Text("verification.ban_confirm.yes", nil, locales...),
 							Style:    discordgo.DangerButton,
 							CustomID: "VerificationBanConfirmYesButton|" + userID + "|" + interaction.Message.ChannelID + "|" + interaction.Message.ID,
 						},
 						&discordgo.Button{
-							Label:    "No, cancel",
+							Label:    m.Localizer.Text("verification.ban_confirm.no", nil, locales...),
 							Style:    discordgo.SuccessButton,
 							CustomID: "VerificationBanConfirmNoButton|" + userID,
 						},
@@ -543,12 +601,12 @@ func (m *VerificationModule) VerificationBanConfirmNoButtonClick(interaction *di
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseUpdateMessage,
 		Data: &discordgo.InteractionResponseData{
-			Content: "Ban cancelled",
+			Content: m.Localizer.Text("verification.ban_cancelled", nil, InteractionLocales(interaction)...),
 			Embeds:  []*discordgo.MessageEmbed{},
 		},
 	})
 	if err != nil {
-		Logf("Warning: %s", ErrorToStr(err))
+		verificationLog.Warn("Could not cancel ban", "guild", interaction.GuildID, "error", ErrorToStr(err))
 	}
 
 	return nil
@@ -559,7 +617,9 @@ func (m *VerificationModule) VerificationBanConfirmYesButtonClick(interaction *d
 	args := strings.Split(interaction.MessageComponentData().CustomID, "|")
 	userID := args[1]
 
-	Logf("Verification of user %v banned by staff %v (%v)", userID, interaction.Member.DisplayName(), interaction.Member.User.ID)
+	verificationLog.Info("Verification banned", "guild", interaction.GuildID, "user", userID, "staff", interaction.Member.User.ID)
+	// The form message is passed along, this interaction is on the confirmation
+	observeVerificationDecision("banned", args[3])
 
 	// Acknowledge the interaction
 	err = m.Discord.InteractionRespond(interaction, &discordgo.InteractionResponse{
@@ -575,17 +635,18 @@ func (m *VerificationModule) VerificationBanConfirmYesButtonClick(interaction *d
 	// Ban the user
 	err = m.Discord.GuildBanCreateWithReason(interaction.GuildID, userID, fmt.Sprintf("Verification ban by %v (%v)", interaction.Member.DisplayName(), interaction.Member.User.ID), 0)
 	if err != nil {
-		Logf("Warning: Failed to ban user %v: %v", userID, err)
+		verificationLog.Warn("Could not ban user", "guild", interaction.GuildID, "user", userID, "error", err)
 	}
 
 	// Provide action feedback
-	content := "User has been banned"
+	data, guildLocales := m.StaffActionTemplateData(interaction, userID)
+	content := m.Localizer.Text("verification.banned", data, InteractionLocales(interaction)...)
 	_, err = m.Discord.FollowupMessageEdit(interaction, interaction.Message.ID, &discordgo.WebhookEdit{
 		Content: &content,
 		Embeds:  &[]*discordgo.MessageEmbed{},
 	})
 	if err != nil {
-		Logf("Warning: %s", ErrorToStr(err))
+		verificationLog.Warn("Could not confirm ban", "guild", interaction.GuildID, "user", userID, "error", ErrorToStr(err))
 	}
 
 	// Edit the bot message
@@ -597,7 +658,7 @@ func (m *VerificationModule) VerificationBanConfirmYesButtonClick(interaction *d
 	embeds := messageWithButtons.Embeds
 	embeds[0].Color = ColorRed
 	embeds[0].Footer = &discordgo.MessageEmbedFooter{
-		Text:    "Banned by " + fmt.Sprintf("%s (%s)", interaction.Member.DisplayName(), interaction.Member.User.ID),
+		Text:    m.Localizer.Text("verification.banned_footer", data, guildLocales...),
 		IconURL: interaction.Member.AvatarURL(""),
 	}
 	messageEdit := &discordgo.MessageEdit{
@@ -613,3 +674,23 @@ func (m *VerificationModule) VerificationBanConfirmYesButtonClick(interaction *d
 
 	return nil
 }
+
+// Template data for a staff member acting on the verification of a user,
+// along with the locales of the guild
+func (m *VerificationModule) StaffActionTemplateData(interaction *discordgo.Interaction, userID string) (*TemplateData, []discordgo.Locale) {
+	guild := m.Discord.CachedGuild(interaction.GuildID)
+
+	data := NewTemplateData()
+	data.Guild = NewTemplateGuild(guild)
+	data.User = NewTemplateUserFromID(userID)
+	data.Staff = NewTemplateUser(nil, interaction.Member)
+
+	// The staff room message carries the answers as embed fields
+	if interaction.Message != nil && len(interaction.Message.Embeds) > 0 {
+		for _, field := range interaction.Message.Embeds[0].Fields {
+			data.Answers[field.Name] = field.Value
+		}
+	}
+
+	return data, GuildLocales(guild)
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"fbot/fakediscord"
+	"github.com/bwmarrin/discordgo"
+)
+
+const verificationTestTimeout = 5 * time.Second
+
+type verificationTest struct {
+	t      *testing.T
+	server *fakediscord.Server
+	bot    *Bot
+
+	guild       *discordgo.Guild
+	staff       *discordgo.User
+	welcome     *discordgo.Channel
+	forms       *discordgo.Channel
+	approved    *discordgo.Channel
+	announce    *discordgo.Channel
+	initialRole string
+	memberRole  string
+}
+
+// Verification config posting forms to formChannel, approved members get
+// approvedRole and are announced in announceChannel
+func testVerificationConfig(t *testing.T, initialRole string, formChannel string, approvedRole string, announceChannel string, approvedFormChannel string) *VerificationConfig {
+	config := &VerificationConfig{}
+	err := UnmarshalJSONC([]byte(fmt.Sprintf(`{
+		"InitialRole": %q,
+		"WelcomeMessage": "Welcome! Click the button to verify",
+		"VerifyButtonText": "Verify",
+		"FormTitle": "Tell us about yourself",
+		"FormFields": [
+			{ "Label": "What is your name?", "Type": "ShortInput" },
+			{ "Label": "Why did you join?", "Type": "ParagraphInput" },
+		],
+		"FormSubmitChannel": %q,
+		"FormSubmitUserMessage": "Thank you!",
+		"FormEmbedDescription": "Introduction of {{.User.Mention}}",
+		"ApproveButtonText": "Approve",
+		"DenyButtonText": "Deny",
+		"BanButtonText": "Ban",
+		"ApprovedRole": %q,
+		"ApprovedAnnouncementMessage": "Everyone welcome {{.User.Mention}}!",
+		"ApprovedAnnouncementChannel": %q,
+		"ApprovedFormChannel": %q,
+		"DenyDmMessage": "Denied by {{.Staff.Mention}}{{if .Reason}}: {{.Reason}}{{end}}",
+	}`, initialRole, formChannel, approvedRole, announceChannel, approvedFormChannel)), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// Runs the bot with only the verification module against a fake Discord
+func newVerificationTest(t *testing.T) *verificationTest {
+	server := fakediscord.NewServer()
+	t.Cleanup(server.Close)
+
+	v := &verificationTest{
+		t:           t,
+		server:      server,
+		initialRole: server.NewID(),
+		memberRole:  server.NewID(),
+	}
+	v.guild = server.AddGuild(&discordgo.Guild{
+		Name: "Test server",
+		Roles: []*discordgo.Role{
+			{ID: v.initialRole, Name: "Unverified", Position: 1},
+			{ID: v.memberRole, Name: "Member", Position: 2},
+		},
+	})
+	v.welcome = server.AddChannel(v.guild.ID, "welcome")
+	v.forms = server.AddChannel(v.guild.ID, "forms")
+	v.approved = server.AddChannel(v.guild.ID, "approved-forms")
+	v.announce = server.AddChannel(v.guild.ID, "general")
+	v.staff = server.NewUser("staff")
+	server.InjectMemberAdd(v.guild.ID, v.staff)
+
+	config := &Config{
+		DiscordToken:       "token",
+		DiscordEndpoint:    server.URL,
+		VerificationSystem: testVerificationConfig(t, v.initialRole, v.forms.ID, v.memberRole, v.announce.ID, v.approved.ID),
+	}
+	err := config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v.bot, err = NewBot(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.bot.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.bot.Stop)
+
+	err = server.WaitConnected(verificationTestTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func (v *verificationTest) waitFor(what string, condition func() bool) {
+	v.t.Helper()
+	err := v.server.WaitFor(verificationTestTimeout, condition)
+	if err != nil {
+		v.t.Fatalf("%v: %v", what, err)
+	}
+}
+
+// The first message in the channel satisfying the condition, waits for one
+func (v *verificationTest) waitForMessage(what string, channelID string, condition func(*discordgo.Message) bool) *discordgo.Message {
+	v.t.Helper()
+	var found *discordgo.Message
+	v.waitFor(what, func() bool {
+		for _, message := range v.server.Messages(channelID) {
+			if condition(message) {
+				found = message
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
+
+func (v *verificationTest) waitForResponse(what string, interaction *discordgo.Interaction) *discordgo.InteractionResponse {
+	v.t.Helper()
+	var found *discordgo.InteractionResponse
+	v.waitFor(what, func() bool {
+		for _, response := range v.server.InteractionResponses() {
+			if response.InteractionID == interaction.ID {
+				found = response.Response
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
+
+func (v *verificationTest) hasRoleChange(userID string, roleID string, added bool) bool {
+	for _, change := range v.server.RoleChanges() {
+		if change.GuildID == v.guild.ID && change.UserID == userID && change.RoleID == roleID && change.Added == added {
+			return true
+		}
+	}
+	return false
+}
+
+func hasButton(message *discordgo.Message, customID string) bool {
+	for _, component := range message.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range row.Components {
+			button, ok := component.(*discordgo.Button)
+			if ok && button.CustomID == customID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Goes from joining to the form in the staff channel, returns the form
+// message
+func (v *verificationTest) submitForm(user *discordgo.User) *discordgo.Message {
+	v.t.Helper()
+
+	v.server.InjectMemberAdd(v.guild.ID, user)
+	v.waitFor("initial role on join", func() bool {
+		return v.hasRoleChange(user.ID, v.initialRole, true)
+	})
+
+	v.server.InjectMessage(v.guild.ID, v.welcome.ID, v.staff, "!SpawnVerifyButton")
+	button := v.waitForMessage("verify button", v.welcome.ID, func(message *discordgo.Message) bool {
+		return hasButton(message, "VerifyButton")
+	})
+
+	click := v.server.ClickComponent(button, user, "VerifyButton")
+	response := v.waitForResponse("verify form", click)
+	if response.Type != discordgo.InteractionResponseModal || response.Data.CustomID != "VerifyFormModal" {
+		v.t.Fatalf("verify button answered with %+v", response)
+	}
+	if len(response.Data.Components) != 2 {
+		v.t.Fatalf("form has %v fields, want 2", len(response.Data.Components))
+	}
+
+	v.server.SubmitModal(click, user, "VerifyFormModal", map[string]string{
+		"What is your name?": user.Username,
+		"Why did you join?":  "To test the verification",
+	})
+	return v.waitForMessage("form in staff channel", v.forms.ID, func(message *discordgo.Message) bool {
+		return hasButton(message, "VerificationApproveButton|"+user.ID)
+	})
+}
+
+func TestVerificationApprove(t *testing.T) {
+	v := newVerificationTest(t)
+	user := v.server.NewUser("newbie")
+
+	form := v.submitForm(user)
+	if len(form.Embeds) != 1 || !strings.Contains(form.Embeds[0].Description, "<@"+user.ID+">") {
+		t.Fatalf("unexpected form embeds %+v", form.Embeds)
+	}
+	fields := map[string]string{}
+	for _, field := range form.Embeds[0].Fields {
+		fields[field.Name] = field.Value
+	}
+	if fields["What is your name?"] != "newbie" || fields["Why did you join?"] != "To test the verification" {
+		t.Fatalf("form is missing the answers: %v", fields)
+	}
+
+	v.server.ClickComponent(form, v.staff, "VerificationApproveButton|"+user.ID)
+	v.waitFor("role swap", func() bool {
+		return v.hasRoleChange(user.ID, v.initialRole, false) && v.hasRoleChange(user.ID, v.memberRole, true)
+	})
+	v.waitForMessage("form copy", v.approved.ID, func(message *discordgo.Message) bool {
+		return len(message.Embeds) == 1
+	})
+	v.waitForMessage("buttons removed from the form", v.forms.ID, func(message *discordgo.Message) bool {
+		return message.ID == form.ID && len(message.Components) == 0 && message.Embeds[0].Color == ColorGreen
+	})
+
+	v.waitForMessage("announcement", v.announce.ID, func(message *discordgo.Message) bool {
+		return message.Content == "Everyone welcome <@"+user.ID+">!"
+	})
+
+	member := v.server.Member(v.guild.ID, user.ID)
+	if member == nil || len(member.Roles) != 1 || member.Roles[0] != v.memberRole {
+		t.Fatalf("member should only have the approved role: %+v", member)
+	}
+	if len(v.server.Bans()) != 0 {
+		t.Fatalf("nobody should be banned: %+v", v.server.Bans())
+	}
+}
+
+func TestVerificationDeny(t *testing.T) {
+	v := newVerificationTest(t)
+	user := v.server.NewUser("newbie")
+
+	form := v.submitForm(user)
+	click := v.server.ClickComponent(form, v.staff, "VerificationDenyButton|"+user.ID)
+	response := v.waitForResponse("deny reason modal", click)
+	if response.Type != discordgo.InteractionResponseModal {
+		t.Fatalf("deny button answered with %+v", response)
+	}
+
+	v.server.SubmitModal(click, v.staff, response.Data.CustomID, map[string]string{"Reason": "Too young"})
+	v.waitFor("deny DM", func() bool {
+		messages := v.server.DirectMessages(user.ID)
+		return len(messages) == 1 && messages[0].Content == "Denied by <@"+v.staff.ID+">: Too young"
+	})
+	v.waitForMessage("buttons removed from the form", v.forms.ID, func(message *discordgo.Message) bool {
+		return message.ID == form.ID && len(message.Components) == 0 && message.Embeds[0].Color == ColorDarkOrange
+	})
+
+	if v.hasRoleChange(user.ID, v.memberRole, true) {
+		t.Fatal("denied member got the approved role")
+	}
+	if len(v.server.Bans()) != 0 {
+		t.Fatalf("nobody should be banned: %+v", v.server.Bans())
+	}
+}
+
+func TestVerificationBan(t *testing.T) {
+	v := newVerificationTest(t)
+	user := v.server.NewUser("spammer")
+
+	form := v.submitForm(user)
+	click := v.server.ClickComponent(form, v.staff, "VerificationBanButton|"+user.ID)
+	response := v.waitForResponse("ban confirmation", click)
+	confirmID := "VerificationBanConfirmYesButton|" + user.ID + "|" + form.ChannelID + "|" + form.ID
+	confirmation := &discordgo.Message{
+		ID:         v.server.NewID(),
+		GuildID:    v.guild.ID,
+		ChannelID:  form.ChannelID,
+		Content:    response.Data.Content,
+		Components: response.Data.Components,
+	}
+	if !hasButton(confirmation, confirmID) {
+		t.Fatalf("confirmation is missing the yes button: %+v", response.Data.Components)
+	}
+
+	v.server.ClickComponent(confirmation, v.staff, confirmID)
+	v.waitFor("ban", func() bool {
+		bans := v.server.Bans()
+		return len(bans) == 1 && bans[0].GuildID == v.guild.ID && bans[0].UserID == user.ID
+	})
+	v.waitForMessage("buttons removed from the form", v.forms.ID, func(message *discordgo.Message) bool {
+		return message.ID == form.ID && len(message.Components) == 0 && message.Embeds[0].Color == ColorRed
+	})
+
+	if v.server.Member(v.guild.ID, user.ID) != nil {
+		t.Fatal("banned user is still a member")
+	}
+}
+
+// The module on its own, with Discord recorded in memory
+func newRecordedVerification(t *testing.T) (*VerificationModule, *RecordingDiscord) {
+	localizer, err := NewLocalizer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discord := NewRecordingDiscord()
+	module := &VerificationModule{
+		Discord:   discord,
+		Localizer: localizer,
+		Config:    testVerificationConfig(t, "10", "20", "11", "21", "22"),
+	}
+	return module, discord
+}
+
+// A staff member clicking a button on the form of the user
+func recordedFormClick(discord *RecordingDiscord, form *discordgo.Message, staff *discordgo.Member, customID string) *discordgo.Interaction {
+	return &discordgo.Interaction{
+		ID:        discord.newID(),
+		Type:      discordgo.InteractionMessageComponent,
+		GuildID:   form.GuildID,
+		ChannelID: form.ChannelID,
+		Member:    staff,
+		Message:   form,
+		Locale:    discordgo.EnglishUS,
+		Data:      discordgo.MessageComponentInteractionData{CustomID: customID},
+	}
+}
+
+func TestVerificationJoinAssignsInitialRole(t *testing.T) {
+	module, discord := newRecordedVerification(t)
+	user := &discordgo.User{ID: "100", Username: "newbie"}
+
+	err := module.OnGuildMemberAdd(&discordgo.GuildMemberAdd{Member: &discordgo.Member{GuildID: "1", User: user}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RecordedRoleChange{{GuildID: "1", UserID: "100", RoleID: "10", Added: true}}
+	if fmt.Sprint(discord.RoleChanges) != fmt.Sprint(want) {
+		t.Fatalf("role changes %+v, want %+v", discord.RoleChanges, want)
+	}
+}
+
+// Approving still gives the approved role and announces the member when the
+// initial role can't be removed
+func TestVerificationApproveWithoutInitialRole(t *testing.T) {
+	module, discord := newRecordedVerification(t)
+	discord.Errors["GuildMemberRoleRemove"] = fmt.Errorf("missing permissions")
+	staff := discord.AddMember("1", &discordgo.User{ID: "200", Username: "staff"})
+	discord.AddMember("1", &discordgo.User{ID: "100", Username: "newbie"}, "10")
+
+	form, err := discord.ChannelMessageSendComplex("20", &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Description: "Introduction of <@100>",
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "What is your name?", Value: "newbie"},
+				{Name: "User ID", Value: "100"},
+				{Name: "Account created", Value: "2024-01-01 00:00:00"},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.GuildID = "1"
+
+	interaction := recordedFormClick(discord, form, staff, "VerificationApproveButton|100")
+	err = module.VerificationApproveButtonClick(interaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RecordedRoleChange{{GuildID: "1", UserID: "100", RoleID: "11", Added: true}}
+	if fmt.Sprint(discord.RoleChanges) != fmt.Sprint(want) {
+		t.Fatalf("role changes %+v, want %+v", discord.RoleChanges, want)
+	}
+	if len(discord.Messages["21"]) != 1 || discord.Messages["21"][0].Content != "Everyone welcome <@100>!" {
+		t.Fatalf("unexpected announcements %+v", discord.Messages["21"])
+	}
+	copied := discord.Messages["22"]
+	if len(copied) != 1 || len(copied[0].Embeds[0].Fields) != 1 {
+		t.Fatalf("the form copy should only have the answers: %+v", copied)
+	}
+	if len(discord.Followups[interaction.ID]) != 1 {
+		t.Fatalf("staff got %v followups, want 1", len(discord.Followups[interaction.ID]))
+	}
+	if len(discord.Messages["20"][0].Components) != 0 || discord.Messages["20"][0].Embeds[0].Color != ColorGreen {
+		t.Fatalf("form wasn't marked as approved: %+v", discord.Messages["20"][0])
+	}
+}
+
+// A failing DM doesn't stop the denial
+func TestVerificationDenyWithClosedDMs(t *testing.T) {
+	module, discord := newRecordedVerification(t)
+	discord.Errors["UserChannelCreate"] = fmt.Errorf("cannot send messages to this user")
+	staff := discord.AddMember("1", &discordgo.User{ID: "200", Username: "staff"})
+
+	form, err := discord.ChannelMessageSendComplex("20", &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{Description: "Introduction of <@100>"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interaction := &discordgo.Interaction{
+		ID:        discord.newID(),
+		Type:      discordgo.InteractionModalSubmit,
+		GuildID:   "1",
+		ChannelID: "20",
+		Member:    staff,
+		Message:   form,
+		Locale:    discordgo.EnglishUS,
+		Data: discordgo.ModalSubmitInteractionData{
+			CustomID: "VerificationDenyModal|100",
+			Components: []discordgo.MessageComponent{
+				&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					&discordgo.TextInput{CustomID: "Reason", Value: "Too young"},
+				}},
+			},
+		},
+	}
+	err = module.VerificationDenyModalSubmit(interaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discord.RoleChanges) != 0 || len(discord.Bans) != 0 {
+		t.Fatalf("denial changed roles %+v or banned %+v", discord.RoleChanges, discord.Bans)
+	}
+	if len(discord.Followups[interaction.ID]) != 1 {
+		t.Fatalf("staff got %v followups, want 1", len(discord.Followups[interaction.ID]))
+	}
+	if discord.Messages["20"][0].Embeds[0].Color != ColorDarkOrange {
+		t.Fatalf("form wasn't marked as denied: %+v", discord.Messages["20"][0])
+	}
+}
@@ -0,0 +1,222 @@
+// Welcome module
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type WelcomeConfig struct {
+	// Where Message is posted when a member joins
+	Channel string
+	Message *MessageTemplate
+	// Sent to the member's DMs when they join
+	DmMessage *MessageTemplate
+	// Generated image attached to the welcome messages, shown inside the
+	// embed if there is one
+	Card *WelcomeCardConfig
+
+	// Where FarewellMessage is posted when a member leaves, defaults to
+	// Channel
+	FarewellChannel string
+	FarewellMessage *MessageTemplate
+
+	// Don't greet bots
+	IgnoreBots bool
+}
+
+func (c *WelcomeConfig) Validate() error {
+	templates := map[string]*MessageTemplate{
+		"Message":         c.Message,
+		"DmMessage":       c.DmMessage,
+		"FarewellMessage": c.FarewellMessage,
+	}
+	for name, messageTemplate := range templates {
+		if messageTemplate == nil {
+			continue
+		}
+		err := messageTemplate.Validate("Welcome." + name)
+		if err != nil {
+			return Errorf("Welcome.%s: %w", name, err)
+		}
+	}
+
+	if c.Message != nil && c.Channel == "" {
+		return Errorf("Welcome.Message needs a Channel")
+	}
+	if c.FarewellMessage != nil && c.farewellChannel() == "" {
+		return Errorf("Welcome.FarewellMessage needs a FarewellChannel or Channel")
+	}
+
+	if c.Card != nil {
+		err := c.Card.Validate()
+		if err != nil {
+			return Errorf("Welcome.Card: %w", err)
+		}
+	}
+	return nil
+}
+
+// FarewellChannel with its default
+func (c *WelcomeConfig) farewellChannel() string {
+	if c.FarewellChannel == "" {
+		return c.Channel
+	}
+	return c.FarewellChannel
+}
+
+var welcomeLog = ModuleLogger("welcome")
+
+type WelcomeModule struct {
+	Discord   DiscordAPI
+	Localizer *Localizer
+	Config    *WelcomeConfig
+
+	// Nil without Config.Card
+	card *welcomeCard
+}
+
+func init() {
+	RegisterModule(ModuleInfo{
+		Name:    "welcome",
+		Intents: discordgo.IntentsGuildMembers,
+	}, func(config *Config) *WelcomeConfig {
+		return config.Welcome
+	}, NewWelcomeModule)
+}
+
+func NewWelcomeModule(config *WelcomeConfig) *WelcomeModule {
+	m := &WelcomeModule{
+		Config: config,
+	}
+	if config.Card != nil {
+		m.card = newWelcomeCard(config.Card)
+	}
+	return m
+}
+
+func (m *WelcomeModule) Register(bot *Bot) error {
+	welcomeLog.Info("Registering module")
+
+	m.Discord = bot.Discord
+	m.Localizer = bot.Localizer
+
+	HandleEvent(bot.Discord, m.OnGuildMemberAdd)
+	HandleEvent(bot.Discord, m.OnGuildMemberRemove)
+
+	return nil
+}
+
+func (m *WelcomeModule) memberTemplateData(guildID string, user *discordgo.User, member *discordgo.Member) (*TemplateData, *discordgo.Guild) {
+	guild := m.Discord.CachedGuild(guildID)
+
+	data := NewTemplateData()
+	data.User = NewTemplateUser(user, member)
+	if guild != nil {
+		data.Guild = NewTemplateGuild(guild)
+		data.Count = guild.MemberCount
+	}
+	return data, guild
+}
+
+func (m *WelcomeModule) OnGuildMemberAdd(event *discordgo.GuildMemberAdd) error {
+	if m.Config.IgnoreBots && event.User.Bot {
+		return nil
+	}
+	if m.Config.Message == nil && m.Config.DmMessage == nil {
+		return nil
+	}
+
+	data, guild := m.memberTemplateData(event.GuildID, event.User, event.Member)
+	locales := GuildLocales(guild)
+
+	var card []byte
+	if m.card != nil {
+		var err error
+		card, err = m.card.Render(event.User, m.cardSubtitle(data, locales))
+		if err != nil {
+			// Still greet the member without the card
+			welcomeLog.Warn("Could not render welcome card", "guild", event.GuildID, "user", event.User.ID, "error", ErrorToStr(err))
+		}
+	}
+
+	if m.Config.Message != nil {
+		message, err := m.Localizer.Message("Welcome.Message", m.Config.Message, data, locales...)
+		if err != nil {
+			return err
+		}
+		attachCard(message, card)
+
+		m.Discord.Announce(m.Config.Channel, message)
+	}
+
+	if m.Config.DmMessage != nil {
+		message, err := m.Localizer.Message("Welcome.DmMessage", m.Config.DmMessage, data, locales...)
+		if err != nil {
+			return err
+		}
+		attachCard(message, card)
+
+		err = m.Discord.SendDM(event.User.ID, message)
+		if err != nil {
+			// Members can have DMs from server members turned off
+			welcomeLog.Warn("Could not send welcome DM", "guild", event.GuildID, "user", event.User.ID, "error", ErrorToStr(err))
+		}
+	}
+
+	welcomeLog.Info("Welcomed user", "guild", event.GuildID, "user", event.User.ID)
+	return nil
+}
+
+func (m *WelcomeModule) OnGuildMemberRemove(event *discordgo.GuildMemberRemove) error {
+	if m.Config.FarewellMessage == nil {
+		return nil
+	}
+	if m.Config.IgnoreBots && event.User.Bot {
+		return nil
+	}
+
+	data, guild := m.memberTemplateData(event.GuildID, event.User, event.Member)
+	message, err := m.Localizer.Message("Welcome.FarewellMessage", m.Config.FarewellMessage, data, GuildLocales(guild)...)
+	if err != nil {
+		return err
+	}
+
+	m.Discord.Announce(m.Config.farewellChannel(), message)
+	return nil
+}
+
+// Member count line of the card. Translations the card font can't draw fall
+// back to just the number.
+func (m *WelcomeModule) cardSubtitle(data *TemplateData, locales []discordgo.Locale) string {
+	if data.Count <= 0 {
+		return ""
+	}
+	subtitle := strings.ToUpper(m.Localizer.Text("welcome.card_member", data, locales...))
+	if !cardCanDraw(subtitle) {
+		subtitle = "#" + strconv.Itoa(data.Count)
+	}
+	return subtitle
+}
+
+func attachCard(message *discordgo.MessageSend, card []byte) {
+	if card == nil {
+		return
+	}
+
+	message.Files = append(message.Files, &discordgo.File{
+		Name:        "welcome.png",
+		ContentType: "image/png",
+		Reader:      bytes.NewReader(card),
+	})
+	for _, embed := range message.Embeds {
+		if embed.Image == nil {
+			embed.Image = &discordgo.MessageEmbedImage{URL: "attachment://welcome.png"}
+			break
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Welcome card images
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type WelcomeCardConfig struct {
+	// Big text next to the avatar, drawn in uppercase. Only the letters A-Z,
+	// digits, spaces and # ! ? - . , ' can be drawn. Defaults to "WELCOME".
+	Title string
+	// Hex colors like "#2b2d31"
+	BackgroundColor string
+	AccentColor     string
+	TextColor       string
+}
+
+const (
+	cardWidth        = 800
+	cardHeight       = 260
+	cardAvatarRadius = 95
+	cardRingWidth    = 6
+)
+
+func (c *WelcomeCardConfig) Validate() error {
+	if !cardCanDraw(strings.ToUpper(c.Title)) {
+		return Errorf("Title: %q has characters the card can't draw, use letters A-Z, digits, spaces and # ! ? - . , '", c.Title)
+	}
+
+	colors := map[string]string{
+		"BackgroundColor": c.BackgroundColor,
+		"AccentColor":     c.AccentColor,
+		"TextColor":       c.TextColor,
+	}
+	for name, value := range colors {
+		if value == "" {
+			continue
+		}
+		_, err := parseHexColor(value)
+		if err != nil {
+			return Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Card settings with defaults filled in and colors parsed
+type welcomeCard struct {
+	title      string
+	background color.RGBA
+	accent     color.RGBA
+	text       color.RGBA
+}
+
+// The config must have passed Validate
+func newWelcomeCard(config *WelcomeCardConfig) *welcomeCard {
+	card := &welcomeCard{
+		title:      strings.ToUpper(config.Title),
+		background: cardColor(config.BackgroundColor, "#2b2d31"),
+		accent:     cardColor(config.AccentColor, "#5865f2"),
+		text:       cardColor(config.TextColor, "#ffffff"),
+	}
+	if card.title == "" {
+		card.title = "WELCOME"
+	}
+	return card
+}
+
+func cardColor(value string, def string) color.RGBA {
+	if value == "" {
+		value = def
+	}
+	parsed, _ := parseHexColor(value)
+	return parsed
+}
+
+func parseHexColor(text string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(text, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, Errorf("%q is not a color like #5865f2", text)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, Errorf("%q is not a color like #5865f2", text)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}, nil
+}
+
+func fetchAvatar(user *discordgo.User) (image.Image, error) {
+	// AvatarURL gives animated avatars as gif, the png has the first frame
+	url := user.AvatarURL("256")
+	if user.Avatar != "" {
+		url = discordgo.EndpointUserAvatar(user.ID, user.Avatar) + "?size=256"
+	}
+
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, Errorf("fetching avatar: %s", response.Status)
+	}
+
+	avatar, _, err := image.Decode(response.Body)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return avatar, nil
+}
+
+// Draws the card and encodes it as PNG. Subtitle is drawn below the title,
+// characters the font doesn't have show up as "?".
+func (c *welcomeCard) Render(user *discordgo.User, subtitle string) ([]byte, error) {
+	avatar, err := fetchAvatar(user)
+	if err != nil {
+		return nil, err
+	}
+
+	card := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	fillRect(card, card.Bounds(), c.background)
+	fillRect(card, image.Rect(0, cardHeight-8, cardWidth, cardHeight), c.accent)
+
+	centerX, centerY := cardHeight/2, cardHeight/2
+	drawCircle(card, centerX, centerY, cardAvatarRadius+cardRingWidth, c.accent)
+	drawAvatar(card, avatar, centerX, centerY, cardAvatarRadius)
+
+	textX := cardHeight + 10
+	drawText(card, fitCardText(c.title, textX, 7), textX, 60, 7, c.text)
+	if subtitle != "" {
+		drawText(card, fitCardText(subtitle, textX, 4), textX, 150, 4, c.accent)
+	}
+
+	var buffer bytes.Buffer
+	err = png.Encode(&buffer, card)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, fill color.RGBA) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, fill)
+		}
+	}
+}
+
+func drawCircle(img *image.RGBA, centerX int, centerY int, radius int, fill color.RGBA) {
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= radius*radius {
+				img.SetRGBA(centerX+x, centerY+y, fill)
+			}
+		}
+	}
+}
+
+// Scales the avatar into a circle, nearest neighbour is good enough at this size
+func drawAvatar(img *image.RGBA, avatar image.Image, centerX int, centerY int, radius int) {
+	bounds := avatar.Bounds()
+	diameter := 2 * radius
+
+	for y := -radius; y < radius; y++ {
+		for x := -radius; x < radius; x++ {
+			if x*x+y*y > radius*radius {
+				continue
+			}
+			srcX := bounds.Min.X + (x+radius)*bounds.Dx()/diameter
+			srcY := bounds.Min.Y + (y+radius)*bounds.Dy()/diameter
+			r, g, b, a := avatar.At(srcX, srcY).RGBA()
+
+			// Blend transparent avatars over what is already there
+			dst := img.RGBAAt(centerX+x, centerY+y)
+			img.SetRGBA(centerX+x, centerY+y, color.RGBA{
+				R: uint8((r + uint32(dst.R)*(0xffff-a)/0xff) >> 8),
+				G: uint8((g + uint32(dst.G)*(0xffff-a)/0xff) >> 8),
+				B: uint8((b + uint32(dst.B)*(0xffff-a)/0xff) >> 8),
+				A: 255,
+			})
+		}
+	}
+}
+
+// Glyphs are 5x7 pixels, plus one pixel of spacing
+const glyphAdvance = 6
+
+// Whether the font has every character of the text
+func cardCanDraw(text string) bool {
+	for _, char := range text {
+		if _, ok := cardFont[char]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Cuts the text off where it would run off the card
+func fitCardText(text string, x int, scale int) string {
+	maxChars := (cardWidth - x - 20) / (glyphAdvance * scale)
+	if len([]rune(text)) > maxChars {
+		return string([]rune(text)[:maxChars])
+	}
+	return text
+}
+
+func drawText(img *image.RGBA, text string, x int, y int, scale int, fill color.RGBA) {
+	for _, char := range text {
+		glyph, ok := cardFont[char]
+		if !ok {
+			glyph = cardFont['?']
+		}
+
+		for row, bits := range glyph {
+			for column := 0; column < 5; column++ {
+				if bits&(1<<(4-column)) == 0 {
+					continue
+				}
+				pixelX := x + column*scale
+				pixelY := y + row*scale
+				fillRect(img, image.Rect(pixelX, pixelY, pixelX+scale, pixelY+scale), fill)
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// Each row is 5 bits, the highest bit is the leftmost pixel
+var cardFont = map[rune][7]uint8{
+	' ':  {0, 0, 0, 0, 0, 0, 0},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'#':  {0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010},
+	'!':  {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00000, 0b00100},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b00000, 0b00100},
+	'-':  {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	'.':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100},
+	',':  {0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000},
+	'\'': {0b00100, 0b00100, 0b01000, 0b00000, 0b00000, 0b00000, 0b00000},
+}